@@ -1,8 +1,19 @@
 package geecache
 
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// ErrInvalidRange 表示 Slice/GetRange 请求的字节范围超出了值的长度
+var ErrInvalidRange = errors.New("geecache: invalid byte range")
+
 // b 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储
 type ByteView struct {
-	b []byte 
+	b    []byte
+	miss bool // 负缓存的墓碑，表示数据源中不存在该key，见 WithNegativeTTL
+	encoding string // b 使用的内容编码，只用于缓存中保存的形式，见 ContentEncoder
 }
 
 func (v ByteView) Len() int{
@@ -18,6 +29,22 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// Slice 返回 [start, end) 范围内的子视图，与原值共享底层数据而不拷贝，同样是只读的。
+// 范围越界或 start > end 时返回包装了 ErrInvalidRange 的错误
+func (v ByteView) Slice(start, end int) (ByteView, error) {
+	if start < 0 || end > len(v.b) || start > end {
+		return ByteView{}, fmt.Errorf("%w: [%d:%d] of %d bytes", ErrInvalidRange, start, end, len(v.b))
+	}
+	return ByteView{b: v.b[start:end:end]}, nil
+}
+
+// Version 返回由内容计算出的版本号(FNV-1a 64位哈希)，内容相同的值在任何节点上版本号都相同，可用作 ETag
+func (v ByteView) Version() uint64 {
+	h := fnv.New64a()
+	h.Write(v.b)
+	return h.Sum64()
+}
+
 func cloneBytes(b []byte) []byte{
 	c := make([]byte,len(b))
 	copy(c,b)
@@ -0,0 +1,33 @@
+package geecache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestByteViewSlice(t *testing.T) {
+	v := ByteView{b: []byte("hello world")}
+	for _, tc := range []struct {
+		start, end int
+		want       string
+	}{{0, 5, "hello"}, {6, 11, "world"}, {0, 11, "hello world"}, {3, 3, ""}, {11, 11, ""}} {
+		got, err := v.Slice(tc.start, tc.end)
+		if err != nil || got.String() != tc.want {
+			t.Errorf("Slice(%d, %d) = %q, %v, want %q", tc.start, tc.end, got.String(), err, tc.want)
+		}
+	}
+
+	for _, r := range [][2]int{{-1, 3}, {0, 12}, {5, 4}, {12, 12}} {
+		if _, err := v.Slice(r[0], r[1]); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("Slice(%d, %d) error = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+	}
+
+	// 子视图共享数据但无法修改原值
+	sub, _ := v.Slice(0, 5)
+	b := sub.ByteSlice()
+	b[0] = 'H'
+	if v.String() != "hello world" || sub.String() != "hello" {
+		t.Fatalf("modifying the copy changed the view: %q, %q", v.String(), sub.String())
+	}
+}
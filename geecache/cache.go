@@ -1,7 +1,10 @@
 package geecache
 
 import (
+	"errors"
 	"geecache/lru"
+	"log"
+	"slices"
 	"sync"
 	"time"
 )
@@ -12,29 +15,337 @@ type cache struct {
 	lru        *lru.Cache
 	cacheBytes int64         // lru的maxbytes
 	ttl        time.Duration // lru 的defaultttl
+	softTTL    time.Duration // 软过期时间，超过后数据仍可读取但需要后台刷新，为0表示不启用
+	maxStale   time.Duration // 陈旧或过期的数据写入超过该时长后不再返回，为0表示不限制
+	lruOpts    []lru.Option  // 延迟创建 lru 时附加的可选配置
+	onEvicted  func(key string, value lru.Value) // lru 的淘汰回调
+	removing   bool                              // 正在 remove，主动删除不算作淘汰
+	codec      ValueCodec                        // 保存前编码、读出时解码，为 nil 表示原样保存
+	cleanup    time.Duration                     // 后台主动清理过期数据的间隔，为0表示只在访问或淘汰时清理
+}
+
+// newLRU 创建底层的 lru，调用方需持有锁
+func (c *cache) newLRU() *lru.Cache {
+	opts := c.lruOpts
+	if c.cleanup > 0 {
+		opts = append(opts[:len(opts):len(opts)], lru.WithCleanupInterval(c.cleanup, &c.mu))
+	}
+	return lru.New(c.cacheBytes, func(key string, value lru.Value) {
+		if c.onEvicted != nil && !c.removing {
+			c.onEvicted(key, value)
+		}
+	}, c.ttl, opts...)
+}
+
+// close 停止后台清理，之后再创建的 lru 也不会开启清理，缓存仍可继续读写
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanup = 0
+	if c.lru != nil {
+		c.lru.Close()
+	}
+}
+
+// encode 使用 codec 编码要保存的值
+func (c *cache) encode(value ByteView) (ByteView, error) {
+	if c.codec == nil || value.miss {
+		return value, nil
+	}
+	b, err := c.codec.Encode(value.b)
+	if err != nil {
+		return ByteView{}, err
+	}
+	stored := ByteView{b: b}
+	if ce, ok := c.codec.(ContentEncoder); ok {
+		stored.encoding = ce.ContentEncoding()
+	}
+	return stored, nil
+}
+
+// decode 使用 codec 解码保存的值，解码失败时记录日志并视为未命中。负缓存的墓碑同样视为未命中
+func (c *cache) decode(v lru.Value) (ByteView, bool) {
+	value := v.(ByteView)
+	if value.miss {
+		return ByteView{}, false
+	}
+	if c.codec == nil {
+		return value, true
+	}
+	b, err := c.codec.Decode(value.b)
+	if err != nil {
+		log.Println("[GeeCache] Failed to decode cached value:", err)
+		return ByteView{}, false
+	}
+	return ByteView{b: b}, true
 }
 
 // 向缓存添加数据
 func (c *cache) add(key string, value ByteView) {
+	c.addWithTTL(key, value, 0)
+}
+
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	value, _, ok = c.lookup(key)
+	return
+}
+
+// lookup 与 get 相同，额外返回数据是否已超过软过期时间
+func (c *cache) lookup(key string) (value ByteView, stale bool, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// 延迟初始化
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil,c.ttl)
+		return
 	}
-	c.lru.Add(key, value,c.ttl)
+
+	if v, stale, ok := c.lru.GetWithStale(key); ok {
+		if stale && c.tooStale(key) {
+			return ByteView{}, false, false
+		}
+		value, ok = c.decode(v)
+		return value, stale, ok
+	}
+
+	return
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+// tooStale 返回 key 的数据写入后是否已超过 maxStale，超过时不能再作为陈旧数据返回，调用方需持有锁
+func (c *cache) tooStale(key string) bool {
+	if c.maxStale <= 0 {
+		return false
+	}
+	// 在持有锁时被每次读取调用，不记录日志
+	updated, ok := c.lru.Updated(key)
+	return ok && time.Since(updated) > c.maxStale
+}
+
+// getEncoded 返回 key 在缓存中保存的编码后的字节及其内容编码，保存的形式没有使用 accept 中的内容编码、
+// 不存在或已陈旧时返回 false
+func (c *cache) getEncoded(key string, accept []string) (value ByteView, encoding string, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
 		return
 	}
+	v, stale, ok := c.lru.GetWithStale(key)
+	if !ok || stale {
+		return ByteView{}, "", false
+	}
+	stored := v.(ByteView)
+	if stored.miss || stored.encoding == "" || !slices.Contains(accept, stored.encoding) {
+		return ByteView{}, "", false
+	}
+	return ByteView{b: stored.b}, stored.encoding, true
+}
+
+// tombstone 返回 key 是否命中了未过期的负缓存墓碑
+func (c *cache) tombstone(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	v, ok := c.lru.Get(key)
+	return ok && v.(ByteView).miss
+}
+
+// getPrevious 返回键被覆盖前的旧值
+func (c *cache) getPrevious(key string) (value ByteView, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+
+	if v, ok := c.lru.GetPrevious(key); ok {
+		return c.decode(v)
+	}
+
+	return
+}
+
+// bytes 返回缓存已占用的容量
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Bytes()
+}
+
+// len 返回缓存中的节点数，包括尚未被清理的过期节点
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Len()
+}
+
+// entries 返回缓存中所有未过期数据的快照，值已经解码
+func (c *cache) entries() []lru.KeyValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	entries := make([]lru.KeyValue, 0, c.lru.Len())
+	c.lru.Range(func(key string, v lru.Value) bool {
+		if value, ok := c.decode(v); ok {
+			entries = append(entries, lru.KeyValue{Key: key, Value: value})
+		}
+		return true
+	})
+	return entries
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+// keys 按最近使用的顺序返回所有未过期的键，不包括负缓存的墓碑，遍历期间持有锁
+func (c *cache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
 	}
+	keys := make([]string, 0, c.lru.Len())
+	c.lru.Range(func(key string, v lru.Value) bool {
+		if !v.(ByteView).miss {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// snapshotEntry 是 snapshot 返回的一条数据，值为保存的形式(经过 codec 编码)，不会被解码
+type snapshotEntry struct {
+	key    string
+	value  ByteView
+	expire time.Time
+}
+
+// snapshot 返回缓存中所有未过期数据保存的形式及其过期时间，不包括负缓存的墓碑。
+// 开启 ValueCodec 加密时快照中同样是密文
 
+func (c *cache) snapshot() []snapshotEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	entries := make([]snapshotEntry, 0, c.lru.Len())
+	c.lru.Range(func(key string, v lru.Value) bool {
+		value := v.(ByteView)
+		if value.miss {
+			return true
+		}
+		if expire, _, ok := c.lru.Expiry(key); ok {
+			entries = append(entries, snapshotEntry{key: key, value: value, expire: expire})
+		}
+		return true
+	})
+	return entries
+}
+
+// expiry 返回未过期数据的过期时间和最近一次写入的时间
+func (c *cache) expiry(key string) (expire, updated time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	return c.lru.Expiry(key)
+}
+
+// getExpired 返回已过期但仍在保留期内的数据，写入后超过 maxStale 的数据除外
+func (c *cache) getExpired(key string) (value ByteView, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	if v, ok := c.lru.GetExpired(key); ok && !c.tooStale(key) {
+		return c.decode(v)
+	}
 	return
 }
+
+// addWithTTL 向缓存添加数据并指定过期时间，ttl 为0时使用默认的过期时间
+func (c *cache) addWithTTL(key string, value ByteView, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		c.lru = c.newLRU()
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	stored, err := c.encode(value)
+	if err != nil {
+		log.Println("[GeeCache] Failed to encode value of", key, err)
+		return
+	}
+	c.lru.AddWithSoftTTL(key, stored, c.softTTL, ttl)
+}
+
+// restore 将 snapshot 返回的保存形式原样写回缓存，不会再次编码
+func (c *cache) restore(key string, stored ByteView, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		c.lru = c.newLRU()
+	}
+	c.lru.AddWithSoftTTL(key, stored, c.softTTL, ttl)
+}
+
+// update 在持有锁的情况下读取 key 的当前值(不存在或已过期时 ok 为 false)，并写入 fn 返回的新值，
+// 整个读-改-写过程是原子的。fn 返回错误时不写入
+func (c *cache) update(key string, fn func(old ByteView, ok bool) (ByteView, error)) (ByteView, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		c.lru = c.newLRU()
+	}
+	var old ByteView
+	v, ok := c.lru.Get(key)
+	if ok {
+		old, ok = c.decode(v)
+	}
+	value, err := fn(old, ok)
+	if err != nil {
+		return ByteView{}, err
+	}
+	stored, err := c.encode(value)
+	if err != nil {
+		return ByteView{}, err
+	}
+	c.lru.AddWithSoftTTL(key, stored, c.softTTL, c.ttl)
+	return value, nil
+}
+
+// replace 仅在 key 已缓存时用 value 替换旧值并重置过期时间，返回是否替换
+func (c *cache) replace(key string, value ByteView) bool {
+	_, err := c.update(key, func(_ ByteView, ok bool) (ByteView, error) {
+		if !ok {
+			return ByteView{}, errNotCached
+		}
+		return value, nil
+	})
+	return err == nil
+}
+
+// errNotCached 使 replace 在 key 不存在时放弃写入
+var errNotCached = errors.New("not cached")
+
+// remove 删除 key，返回 key 是否存在；主动删除不会触发 onEvicted
+func (c *cache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	c.removing = true
+	defer func() { c.removing = false }()
+	return c.lru.Remove(key)
+}
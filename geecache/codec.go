@@ -0,0 +1,129 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ValueCodec 在值保存到 mainCache 和 hotCache 之前编码、读出时解码，例如加密敏感数据。
+// 缓存容量按编码后的大小计算，Export 导出和 Get 返回的都是解码后的值
+type ValueCodec interface {
+	Encode(value []byte) ([]byte, error)  // 将原始值编码为保存的形式
+	Decode(stored []byte) ([]byte, error) // 将保存的形式还原为原始值
+}
+
+// ContentEncoder 是保存形式为标准内容编码(如 gzip)的 ValueCodec，请求方接受该编码时
+// 可以直接返回缓存中保存的字节，省去解码再编码，见 Group.GetEncoded
+type ContentEncoder interface {
+	ValueCodec
+	ContentEncoding() string // 保存形式使用的内容编码，与 HTTP 的 Content-Encoding 取值相同
+}
+
+// WithValueCodec 使用 codec 对缓存中保存的值编码，默认原样保存
+func WithValueCodec(codec ValueCodec) GroupOption {
+	return func(g *Group) {
+		g.mainCache.codec = codec
+		g.hotCache.codec = codec
+	}
+}
+
+// errCiphertextTooShort 保存的值比 nonce 还短，不是 aeadCodec 加密的结果
+var errCiphertextTooShort = errors.New("geecache: ciphertext too short")
+
+// aeadCodec 使用 AEAD 加密保存的值，每次加密使用随机的 nonce 并保存在密文之前
+type aeadCodec struct {
+	aead cipher.AEAD
+}
+
+// NewAEADCodec 返回使用 aead 加密的 ValueCodec，每个值额外占用 nonce 和认证标签的长度
+func NewAEADCodec(aead cipher.AEAD) ValueCodec {
+	return aeadCodec{aead: aead}
+}
+
+// NewAESGCMCodec 返回使用 AES-GCM 加密的 ValueCodec，key 的长度须为 16、24 或 32 字节
+func NewAESGCMCodec(key []byte) (ValueCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return NewAEADCodec(aead), nil
+}
+
+func (c aeadCodec) Encode(value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(value)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, value, nil), nil
+}
+
+func (c aeadCodec) Decode(stored []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(stored) < n {
+		return nil, errCiphertextTooShort
+	}
+	return c.aead.Open(nil, stored[:n], stored[n:], nil)
+}
+
+// gzipCodec 使用 gzip 压缩保存的值
+type gzipCodec struct {
+	level int
+}
+
+// NewGzipCodec 返回使用 gzip 压缩的 ValueCodec，level 为 gzip 的压缩级别。
+// 它实现了 ContentEncoder，接受 gzip 的请求方可以直接得到压缩后的字节
+func NewGzipCodec(level int) (ValueCodec, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return gzipCodec{level: level}, nil
+}
+
+func (c gzipCodec) Encode(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Decode(stored []byte) ([]byte, error) {
+	return decodeContent("gzip", stored)
+}
+
+func (c gzipCodec) ContentEncoding() string {
+	return "gzip"
+}
+
+// decodeContent 将使用内容编码 encoding 编码的字节还原为原始值，encoding 为空时原样返回
+func decodeContent(encoding string, b []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return b, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("geecache: unsupported content encoding %q", encoding)
+}
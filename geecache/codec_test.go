@@ -0,0 +1,129 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAESGCMCodec(t *testing.T) {
+	if _, err := NewAESGCMCodec([]byte("short")); err == nil {
+		t.Fatal("invalid key size should fail")
+	}
+	codec, err := NewAESGCMCodec(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := codec.Encode([]byte("secret"))
+	if err != nil || bytes.Contains(stored, []byte("secret")) {
+		t.Fatalf("Encode = %q, %v", stored, err)
+	}
+	if plain, err := codec.Decode(stored); err != nil || string(plain) != "secret" {
+		t.Fatalf("Decode = %q, %v", plain, err)
+	}
+	stored[len(stored)-1] ^= 1
+	if _, err := codec.Decode(stored); err == nil {
+		t.Fatal("tampered ciphertext should fail")
+	}
+	if _, err := codec.Decode([]byte("x")); err == nil {
+		t.Fatal("short ciphertext should fail")
+	}
+}
+
+func TestValueCodec(t *testing.T) {
+	codec, _ := NewAESGCMCodec(bytes.Repeat([]byte{2}, 16))
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("secret-" + key), nil
+	})
+	plain := NewGroup("codec-plain", 2<<10, getter, WithTTL(time.Hour))
+	enc := NewGroup("codec-enc", 2<<10, getter, WithTTL(time.Hour), WithValueCodec(codec), WithHistory(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		key := strconv.Itoa(i)
+		plain.Get(key)
+		if v, err := enc.Get(key); err != nil || v.String() != "secret-"+key {
+			t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+		}
+		if v, err := enc.Get(key); err != nil || v.String() != "secret-"+key {
+			t.Fatalf("cached Get(%s) = %q, %v", key, v.String(), err)
+		}
+		// 缓存中保存的是密文
+		raw, ok := enc.mainCache.lru.Get(key)
+		if !ok || bytes.Contains(raw.(ByteView).b, []byte("secret")) {
+			t.Fatalf("stored value of %s = %q, %v", key, raw, ok)
+		}
+	}
+	if loads != 6 {
+		t.Fatalf("loads = %d, want 6", loads)
+	}
+
+	// 容量按密文大小计算
+	mainPlain, _ := plain.CacheBytes()
+	mainEnc, _ := enc.CacheBytes()
+	if overhead := int64(3 * (12 + 16)); mainEnc != mainPlain+overhead {
+		t.Fatalf("encrypted bytes = %d, plain bytes = %d", mainEnc, mainPlain)
+	}
+
+	// 导出的快照和旧值都已解码
+	for _, e := range enc.mainCache.entries() {
+		if v := e.Value.(ByteView).String(); v != "secret-"+e.Key {
+			t.Fatalf("entry %s = %q", e.Key, v)
+		}
+	}
+	if err := enc.Set("0", []byte("updated"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := enc.mainCache.getPrevious("0"); !ok || v.String() != "secret-0" {
+		t.Fatalf("previous = %q, %v", v.String(), ok)
+	}
+
+	// 原子更新读出解码后的旧值，并加密保存新值
+	enc.Set("counter", []byte("5"), 0)
+	if n, err := enc.Increment("counter", 2); err != nil || n != 7 {
+		t.Fatalf("Increment = %d, %v", n, err)
+	}
+	if raw, _ := enc.mainCache.lru.Get("counter"); bytes.Equal(raw.(ByteView).b, []byte("7")) {
+		t.Fatal("counter should be stored encrypted")
+	}
+}
+
+func TestGzipCodec(t *testing.T) {
+	if _, err := NewGzipCodec(42); err == nil {
+		t.Fatal("invalid level should fail")
+	}
+	codec, err := NewGzipCodec(gzip.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("geecache "), 100)
+	stored, err := codec.Encode(value)
+	if err != nil || len(stored) >= len(value) {
+		t.Fatalf("Encode = %d bytes, %v", len(stored), err)
+	}
+	if plain, err := codec.Decode(stored); err != nil || !bytes.Equal(plain, value) {
+		t.Fatalf("Decode = %q, %v", plain, err)
+	}
+	if ce, ok := codec.(ContentEncoder); !ok || ce.ContentEncoding() != "gzip" {
+		t.Fatal("gzip codec should report its content encoding")
+	}
+
+	g := NewGroup("codec-gzip", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}), WithTTL(time.Hour), WithValueCodec(codec))
+	// 未缓存时返回原始值
+	if v, enc, err := g.GetEncoded("k", "br", "gzip"); err != nil || enc != "" || !bytes.Equal(v.b, value) {
+		t.Fatalf("GetEncoded on miss = %d bytes, %q, %v", v.Len(), enc, err)
+	}
+	raw, _ := g.mainCache.lru.Peek("k")
+	if v, enc, err := g.GetEncoded("k", "br", "gzip"); err != nil || enc != "gzip" || !bytes.Equal(v.b, raw.(ByteView).b) {
+		t.Fatalf("GetEncoded = %d bytes, %q, %v, want the stored bytes", v.Len(), enc, err)
+	}
+	// 不接受 gzip 时解码
+	if v, enc, err := g.GetEncoded("k", "br"); err != nil || enc != "" || !bytes.Equal(v.b, value) {
+		t.Fatalf("GetEncoded without gzip = %d bytes, %q, %v", v.Len(), enc, err)
+	}
+}
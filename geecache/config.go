@@ -0,0 +1,215 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"geecache/registry"
+	"time"
+)
+
+// 哈希算法的名字，用于 Config.Hash
+const (
+	HashRing = "ring" // 带虚拟节点的哈希环(默认)
+	HashJump = "jump" // 跳跃一致性哈希
+)
+
+// Config 汇总了 Server 的所有可调参数，可以序列化保存，便于复现部署
+type Config struct {
+	Self        string            `json:"self"`                   // 当前节点地址 ip:port
+	Hash        string            `json:"hash,omitempty"`         // HashRing 或 HashJump，为空时使用 HashRing
+	Replicas    int               `json:"replicas,omitempty"`     // 哈希环的虚拟节点数，为0时使用 defaultReplicas
+	Metadata    registry.Metadata `json:"metadata"`               // 注册至etcd的节点元数据
+	Etcd        []string          `json:"etcd,omitempty"`         // etcd集群的地址，为空时使用 registry.EtcdConfig
+	WarmGroups  []string          `json:"warm_groups,omitempty"`  // 加入集群时需要预热的缓存组
+	EventsToken string            `json:"events_token,omitempty"` // 订阅 Events 需要的令牌，为空表示不开放
+	PickTrace   bool              `json:"pick_trace,omitempty"`   // 记录每次节点选择的过程
+	SingleNode  bool              `json:"single_node,omitempty"`  // 单节点模式，不注册也不发现其他节点
+}
+
+// GroupConfig 汇总了 Group 的所有可调参数，Getter 无法序列化，需要在代码中设置
+type GroupConfig struct {
+	Name               string        `json:"name"`
+	CacheBytes         int64         `json:"cache_bytes"`                    // mainCache 的容量，0表示不限制
+	TTL                time.Duration `json:"ttl,omitempty"`                  // 数据的过期时间
+	SoftTTL            time.Duration `json:"soft_ttl,omitempty"`             // 超过后在后台刷新，需小于 TTL
+	History            time.Duration `json:"history,omitempty"`              // 更新后旧值的保留时长
+	MinRefetchInterval time.Duration `json:"min_refetch_interval,omitempty"` // 同一个key两次从数据源加载的最小间隔
+	NegativeTTL        time.Duration `json:"negative_ttl,omitempty"`         // 数据源中不存在的key的负缓存时长，为0表示不开启
+	HotKeyFactor       float64       `json:"hot_key_factor,omitempty"`       // 为0时使用 defaultHotKeyFactor
+	HotCacheRatio      int           `json:"hot_cache_ratio,omitempty"`      // hotCache 为 CacheBytes 的几分之一，为0时使用 defaultHotCacheRatio
+	PromotionThreshold int64         `json:"promotion_threshold,omitempty"`  // 每个窗口的远程访问次数达到后提升到 hotCache，为0时使用 defaultMaxMinuteRemoteQPS
+	PromotionWindow    time.Duration `json:"promotion_window,omitempty"`     // 计算远程访问频率的窗口，为0时使用 defaultPromotionWindow
+	NoHotCache         bool          `json:"no_hot_cache,omitempty"`         // 不使用 hotCache
+	SingleNode         bool          `json:"single_node,omitempty"`          // 单节点模式，只在本地加载
+	ReadReplicas       int           `json:"read_replicas,omitempty"`        // 主从读写分离的副本集合大小
+	MaxKeyStats        int           `json:"max_key_stats,omitempty"`        // 为0时使用 defaultMaxKeyStats
+	StaleOnPeerError   time.Duration `json:"stale_on_peer_error,omitempty"`  // 远程节点故障时过期数据的保留时长
+	MaxStaleness       time.Duration `json:"max_staleness,omitempty"`        // 陈旧数据写入后最多返回多久，为0表示不限制
+	NoCopy             bool          `json:"no_copy,omitempty"`
+	Checksum           bool          `json:"checksum,omitempty"` // 读取时校验值是否在内存中被破坏
+	Getter             Getter        `json:"-"`
+}
+
+// Validate 检查配置是否合法，返回所有问题
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.Self == "" {
+		errs = append(errs, errors.New("self is required"))
+	}
+	switch cfg.Hash {
+	case "", HashRing:
+	case HashJump:
+		if cfg.Replicas != 0 {
+			errs = append(errs, errors.New("replicas cannot be set with jump hash"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown hash %q", cfg.Hash))
+	}
+	if cfg.Replicas < 0 {
+		errs = append(errs, fmt.Errorf("replicas must not be negative, got %d", cfg.Replicas))
+	}
+	if cfg.SingleNode && len(cfg.WarmGroups) > 0 {
+		errs = append(errs, errors.New("warm_groups cannot be set with single_node"))
+	}
+	return errors.Join(errs...)
+}
+
+// Options 将配置转换为等价的 ServerOption
+func (cfg Config) Options() []ServerOption {
+	opts := []ServerOption{WithMetadata(cfg.Metadata)}
+	if len(cfg.Etcd) > 0 {
+		etcd := registry.EtcdConfig()
+		etcd.Endpoints = cfg.Etcd
+		opts = append(opts, WithEtcdConfig(etcd))
+	}
+	if cfg.Hash == HashJump {
+		opts = append(opts, WithJumpHash())
+	} else if cfg.Replicas > 0 {
+		opts = append(opts, WithReplicas(cfg.Replicas))
+	}
+	if len(cfg.WarmGroups) > 0 {
+		opts = append(opts, WithJoinWarming(cfg.WarmGroups...))
+	}
+	if cfg.EventsToken != "" {
+		opts = append(opts, WithEventsToken(cfg.EventsToken))
+	}
+	if cfg.PickTrace {
+		opts = append(opts, WithPickTrace())
+	}
+	if cfg.SingleNode {
+		opts = append(opts, WithSingleNodeCluster())
+	}
+	return opts
+}
+
+// NewServerFromConfig 校验配置后创建 Server
+func NewServerFromConfig(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config: %w", err)
+	}
+	return NewServer(cfg.Self, cfg.Options()...)
+}
+
+// Validate 检查配置是否合法，返回所有问题
+func (cfg GroupConfig) Validate() error {
+	var errs []error
+	if cfg.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if cfg.Getter == nil {
+		errs = append(errs, errors.New("getter is required"))
+	}
+	if cfg.CacheBytes < 0 {
+		errs = append(errs, fmt.Errorf("cache_bytes must not be negative, got %d", cfg.CacheBytes))
+	}
+	if cfg.SoftTTL < 0 || cfg.History < 0 || cfg.MinRefetchInterval < 0 || cfg.StaleOnPeerError < 0 || cfg.NegativeTTL < 0 || cfg.PromotionWindow < 0 || cfg.MaxStaleness < 0 {
+		errs = append(errs, errors.New("durations other than ttl must not be negative"))
+	}
+	if cfg.SoftTTL > 0 && cfg.TTL > 0 && cfg.SoftTTL >= cfg.TTL {
+		errs = append(errs, fmt.Errorf("soft_ttl %v must be shorter than ttl %v", cfg.SoftTTL, cfg.TTL))
+	}
+	if cfg.HotKeyFactor < 0 {
+		errs = append(errs, fmt.Errorf("hot_key_factor must not be negative, got %v", cfg.HotKeyFactor))
+	}
+	if cfg.HotCacheRatio < 0 {
+		errs = append(errs, fmt.Errorf("hot_cache_ratio must not be negative, got %d", cfg.HotCacheRatio))
+	}
+	if cfg.NoHotCache && cfg.HotCacheRatio > 0 {
+		errs = append(errs, errors.New("hot_cache_ratio cannot be set with no_hot_cache"))
+	}
+	if cfg.PromotionThreshold < 0 {
+		errs = append(errs, fmt.Errorf("promotion_threshold must not be negative, got %d", cfg.PromotionThreshold))
+	}
+	if cfg.ReadReplicas < 0 {
+		errs = append(errs, fmt.Errorf("read_replicas must not be negative, got %d", cfg.ReadReplicas))
+	}
+	if cfg.MaxKeyStats < 0 {
+		errs = append(errs, fmt.Errorf("max_key_stats must not be negative, got %d", cfg.MaxKeyStats))
+	}
+	return errors.Join(errs...)
+}
+
+// Options 将配置转换为等价的 GroupOption
+func (cfg GroupConfig) Options() []GroupOption {
+	var opts []GroupOption
+	if cfg.TTL != 0 {
+		opts = append(opts, WithTTL(cfg.TTL))
+	}
+	if cfg.SoftTTL > 0 {
+		opts = append(opts, WithSoftTTL(cfg.SoftTTL))
+	}
+	if cfg.History > 0 {
+		opts = append(opts, WithHistory(cfg.History))
+	}
+	if cfg.MinRefetchInterval > 0 {
+		opts = append(opts, WithMinRefetchInterval(cfg.MinRefetchInterval))
+	}
+	if cfg.NegativeTTL > 0 {
+		opts = append(opts, WithNegativeTTL(cfg.NegativeTTL))
+	}
+	if cfg.HotKeyFactor > 0 {
+		opts = append(opts, WithHotKeyFactor(cfg.HotKeyFactor))
+	}
+	if cfg.HotCacheRatio > 0 {
+		opts = append(opts, WithHotCacheRatio(cfg.HotCacheRatio))
+	}
+	if cfg.NoHotCache {
+		opts = append(opts, WithoutHotCache())
+	}
+	if cfg.SingleNode {
+		opts = append(opts, WithSingleNode())
+	}
+	if cfg.PromotionThreshold > 0 {
+		opts = append(opts, WithPromotionThreshold(cfg.PromotionThreshold))
+	}
+	if cfg.PromotionWindow > 0 {
+		opts = append(opts, WithPromotionWindow(cfg.PromotionWindow))
+	}
+	if cfg.ReadReplicas > 0 {
+		opts = append(opts, WithReadReplicas(cfg.ReadReplicas))
+	}
+	if cfg.MaxKeyStats > 0 {
+		opts = append(opts, WithMaxKeyStats(cfg.MaxKeyStats))
+	}
+	if cfg.StaleOnPeerError > 0 {
+		opts = append(opts, WithStaleOnPeerError(cfg.StaleOnPeerError))
+	}
+	if cfg.MaxStaleness > 0 {
+		opts = append(opts, WithMaxStaleness(cfg.MaxStaleness))
+	}
+	if cfg.NoCopy {
+		opts = append(opts, WithNoCopy())
+	}
+	if cfg.Checksum {
+		opts = append(opts, WithChecksum())
+	}
+	return opts
+}
+
+// NewGroupFromConfig 校验配置后创建 Group，配置不合法时返回错误而不是像 NewGroup 一样 panic
+func NewGroupFromConfig(cfg GroupConfig) (*Group, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid group config: %w", err)
+	}
+	return NewGroup(cfg.Name, cfg.CacheBytes, cfg.Getter, cfg.Options()...), nil
+}
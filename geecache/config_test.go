@@ -0,0 +1,118 @@
+package geecache
+
+import (
+	"encoding/json"
+	"geecache/consistenthash"
+	"geecache/registry"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	cfg := Config{
+		Self:        "127.0.0.1:8001",
+		Replicas:    100,
+		Metadata:    registry.Metadata{Weight: 2, Zone: "a"},
+		Etcd:        []string{"etcd-1:2379"},
+		WarmGroups:  []string{"scores"},
+		EventsToken: "secret",
+		PickTrace:   true,
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Config
+	if err := json.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, cfg) {
+		t.Fatalf("round trip = %+v, %v, want %+v", got, err, cfg)
+	}
+
+	s, err := NewServerFromConfig(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.self != cfg.Self || s.metadata != cfg.Metadata || s.eventsToken != cfg.EventsToken || !s.tracePick {
+		t.Fatalf("server does not match config: %+v", s)
+	}
+	if s.etcdConfig.Endpoints[0] != "etcd-1:2379" || s.etcdConfig.DialTimeout == 0 {
+		t.Fatalf("etcd config = %+v", s.etcdConfig)
+	}
+	if _, ok := s.newPicker().(*consistenthash.Map); !ok {
+		t.Fatal("ring hash should be used by default")
+	}
+
+	gcfg := GroupConfig{
+		Name:          "config-group",
+		CacheBytes:    2 << 10,
+		TTL:           time.Hour,
+		SoftTTL:       time.Minute,
+		HotKeyFactor:  3,
+		HotCacheRatio: 4,
+		ReadReplicas:  2,
+		Getter: GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}),
+	}
+	data, err = json.Marshal(gcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotGroup GroupConfig
+	if err := json.Unmarshal(data, &gotGroup); err != nil {
+		t.Fatal(err)
+	}
+	gotGroup.Getter = gcfg.Getter
+	if data2, _ := json.Marshal(gotGroup); string(data2) != string(data) {
+		t.Fatalf("group round trip = %s, want %s", data2, data)
+	}
+	g, err := NewGroupFromConfig(gotGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.name != gcfg.Name || g.mainCache.ttl != gcfg.TTL || g.hotKeyFactor != 3 || g.hotCache.cacheBytes != gcfg.CacheBytes/4 || g.readReplicas != 2 {
+		t.Fatalf("group does not match config: %+v", g)
+	}
+	if v, err := g.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	serverCases := map[string]Config{
+		"self":         {},
+		"unknown hash": {Self: "127.0.0.1:8001", Hash: "md5"},
+		"replicas":     {Self: "127.0.0.1:8001", Replicas: -1},
+		"jump hash":    {Self: "127.0.0.1:8001", Hash: HashJump, Replicas: 10},
+	}
+	for want, cfg := range serverCases {
+		if _, err := NewServerFromConfig(cfg); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("NewServerFromConfig(%+v) = %v, want error about %q", cfg, err, want)
+		}
+	}
+	if _, err := NewServerFromConfig(Config{Self: "localhost"}); err == nil {
+		t.Error("address without port should be rejected")
+	}
+
+	getter := GetterFunc(func(key string) ([]byte, error) { return nil, nil })
+	groupCases := map[string]GroupConfig{
+		"name":                {Getter: getter},
+		"getter":              {Name: "invalid"},
+		"cache_bytes":         {Name: "invalid", Getter: getter, CacheBytes: -1},
+		"soft_ttl":            {Name: "invalid", Getter: getter, TTL: time.Minute, SoftTTL: time.Hour},
+		"hot_key_factor":      {Name: "invalid", Getter: getter, HotKeyFactor: -1},
+		"hot_cache_ratio":     {Name: "invalid", Getter: getter, HotCacheRatio: 2, NoHotCache: true},
+		"promotion_threshold": {Name: "invalid", Getter: getter, PromotionThreshold: -1},
+		"read_replicas":       {Name: "invalid", Getter: getter, ReadReplicas: -1},
+		"negative":            {Name: "invalid", Getter: getter, History: -time.Second},
+	}
+	for want, cfg := range groupCases {
+		if _, err := NewGroupFromConfig(cfg); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("NewGroupFromConfig(%+v) = %v, want error about %q", cfg, err, want)
+		}
+	}
+	if GetGroup("invalid") != nil {
+		t.Error("invalid config should not register a group")
+	}
+}
@@ -2,19 +2,27 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // 函数类型·hash，用依赖注入
 type Hash func(data []byte) uint32
 
-// Map包含所有哈希值
+// Map包含所有哈希值，可以在查找的同时并发地增删节点
 type Map struct {
+	mu       sync.RWMutex // 保护以下字段，查找持有读锁，修改环持有写锁
 	hash     Hash  // 哈希函数依赖，后续可自行更换哈希函数
 	replicas int   // 虚拟节点倍数
 	keys     []int // 哈希环
 	hashMap  map[int]string	// 虚拟节点hash到真实节点名称的映射
+	unavailable map[string]bool // 被标记为不可用的真实节点，仍保留在环上但查找时会被跳过
+	maxVirtual  int             // 环上虚拟节点总数的上限，为0表示不限制，见 NewCapped
+	weights     map[string]int  // 通过 AddWeighted 加入的节点的权重，未记录的节点权重为1
+	indexBits   int             // 分桶索引按哈希值的高 indexBits 位分桶，为0表示不使用索引，见 EnableIndex
+	index       []int           // index[b] 为环上第一个落在第 b 个桶或其后的虚拟节点下标，长度为桶数+1
 }
 
 // New 函数通过传入的虚拟节点倍数replicas和哈希函数fn
@@ -30,31 +38,387 @@ func New(replicas int, fn Hash) *Map {
 	return m
 }
 
+// NewCapped 创建一个虚拟节点总数约为 maxVirtual 的哈希环，每个真实节点的虚拟节点数为 maxVirtual/节点数(至少为1)，
+// 集群变大时每个节点的虚拟节点随之减少，使环占用的内存和查找开销不随节点数增长。
+// 节点数变化时所有节点的虚拟节点数都会改变，Add 会重建整个环；
+// 虚拟节点按编号从大到小增删，除新增节点外只有被删除的虚拟节点上的键会迁移
+func NewCapped(maxVirtual int, fn Hash) *Map {
+	m := New(0, fn)
+	m.maxVirtual = maxVirtual
+	return m
+}
+
+// weight 返回真实节点 key 的权重
+func (m *Map) weight(key string) int {
+	if w, ok := m.weights[key]; ok {
+		return w
+	}
+	return 1
+}
+
+// replicasFor 返回环上节点的总权重为 n 时每单位权重的虚拟节点数
+func (m *Map) replicasFor(n int) int {
+	if m.maxVirtual <= 0 {
+		return m.replicas
+	}
+	if n == 0 || m.maxVirtual < n {
+		return 1
+	}
+	return m.maxVirtual / n
+}
+
+// nodes 返回环上的所有真实节点
+func (m *Map) nodes() []string {
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, node := range m.hashMap {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// RecommendReplicas 根据节点数和可接受的负载倾斜返回建议的虚拟节点倍数。
+// targetSkew 为最繁忙节点的负载超出平均值的比例，如 0.1 表示最多比平均值高 10%。
+// 每个真实节点承担的负载是 replicas 段随机弧长之和，相对标准差约为 1/sqrt(replicas)，
+// 而 n 个节点中的最大值约比平均值高 sqrt(2ln(n)) 个标准差，再留出两个标准差的余量，
+// 得到 replicas ≈ (sqrt(2ln(n))+2)²/targetSkew²。
+// 估算假设哈希函数分布均匀，默认的 crc32 对编号相近的虚拟节点名分布较差，实际倾斜会明显偏大
+func RecommendReplicas(nodeCount int, targetSkew float64) int {
+	if nodeCount <= 1 || targetSkew <= 0 {
+		return 1
+	}
+	z := math.Sqrt(2*math.Log(float64(nodeCount))) + 2
+	return int(math.Ceil(z * z / (targetSkew * targetSkew)))
+}
+
 // 对每一个真实节点 key，对应创建 m.replicas 个虚拟节点，虚拟节点的名称是：strconv.Itoa(i) + key，即通过添加编号的方式区分不同虚拟节点
 // 使用 m.hash() 计算虚拟节点的哈希值，使用 append(m.keys, hash) 添加到环上。在 hashMap 中增加虚拟节点和真实节点的映射关系。
-// 最后一步，环上的哈希值排序。
+// 最后一步，环上的哈希值排序。重复加入已在环上的节点是幂等的
 func (m *Map) Add(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(keys...)
+}
+
+// add 是不加锁的 Add，调用方需持有写锁
+func (m *Map) add(keys ...string) {
+	if m.maxVirtual > 0 {
+		// 每个节点的虚拟节点数随节点数变化，需要重建整个环
+		m.set(append(m.nodes(), keys...)...)
+		return
+	}
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
+		// 已在环上的节点先移除原有的虚拟节点，重复加入不会产生重复的虚拟节点，并按新的权重重建
+		m.removeVirtual(key)
+		for i := 0; i < m.replicas*m.weight(key); i++ {
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
 			m.keys = append(m.keys, hash)
 			m.hashMap[hash] = key // 虚拟节点和真实节点的映射关系
 		}
 	}
 	sort.Ints(m.keys) // 哈希值排序
+	m.reindex()
+}
+
+// removeVirtual 从环上移除真实节点 key 的所有虚拟节点，过滤后的 keys 仍然有序，调用方需持有写锁
+func (m *Map) removeVirtual(key string) {
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if m.hashMap[hash] != key {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+	for hash, node := range m.hashMap {
+		if node == key {
+			delete(m.hashMap, hash)
+		}
+	}
+}
+
+// AddWeighted 加入一个权重为 weight 的真实节点，它的虚拟节点数是普通节点的 weight 倍，分到的键也按比例增加，
+// 用于容量不同的节点。weight 小于1时按1处理，权重会一直保留到节点被 Remove 或不在 Set 的列表中。
+// 节点已在环上时按新的权重替换它原有的虚拟节点
+func (m *Map) AddWeighted(key string, weight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if weight < 1 {
+		weight = 1
+	}
+	if m.weights == nil {
+		m.weights = make(map[string]int)
+	}
+	m.weights[key] = weight
+	m.add(key)
+}
+
+// Set 用 keys 替换环上的所有真实节点，一次性重建整个环并只排序一次，适用于全量更新节点列表。
+// 仍在环上的节点保留 SetAvailable 设置的可用状态
+func (m *Map) Set(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(keys...)
+}
+
+// SetWeighted 与 Set 相同，同时用 weights 替换所有节点的权重(见 AddWeighted)，weights 中没有的节点权重为1。
+// 节点和权重一起更新，整个环只重建一次
+func (m *Map) SetWeighted(keys []string, weights map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.weights = make(map[string]int, len(weights))
+	for key, w := range weights {
+		if w > 1 {
+			m.weights[key] = w
+		}
+	}
+	m.set(keys...)
+}
+
+// set 是不加锁的 Set，调用方需持有写锁
+func (m *Map) set(keys ...string) {
+	members := make(map[string]bool, len(keys))
+	total := 0
+	for _, key := range keys {
+		if !members[key] {
+			total += m.weight(key)
+		}
+		members[key] = true
+	}
+	replicas := m.replicasFor(total)
+	m.keys = make([]int, 0, total*replicas)
+	m.hashMap = make(map[int]string, total*replicas)
+	added := make(map[string]bool, len(members))
+	for _, key := range keys {
+		if added[key] {
+			continue // 重复的节点只加入一次
+		}
+		added[key] = true
+		for i := 0; i < replicas*m.weight(key); i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+			m.keys = append(m.keys, hash)
+			m.hashMap[hash] = key
+		}
+	}
+	sort.Ints(m.keys)
+	m.reindex()
+	for node := range m.unavailable {
+		if !members[node] {
+			delete(m.unavailable, node)
+		}
+	}
+	for node := range m.weights {
+		if !members[node] {
+			delete(m.weights, node)
+		}
+	}
+}
+
+// Remove 从环上移除真实节点 key 的所有虚拟节点，以及它的不可用标记和权重，原本落在它上面的键顺时针转移到下一个节点。
+// 过滤后的 keys 仍然有序，不需要重新排序。开启 NewCapped 时剩余节点的虚拟节点数会增加，需要重建整个环
+func (m *Map) Remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.unavailable, key)
+	delete(m.weights, key)
+	if m.maxVirtual > 0 {
+		var rest []string
+		for _, node := range m.nodes() {
+			if node != key {
+				rest = append(rest, node)
+			}
+		}
+		m.set(rest...)
+		return
+	}
+	m.removeVirtual(key)
+	m.reindex()
+}
+
+// EnableIndex 为哈希环建立按哈希值高 bits 位划分的分桶索引，bits 为0时关闭索引。
+// 查找时先按桶定位到环上的一小段，再在段内二分，代替在整个环上二分，结果与不使用索引时完全相同。
+// 索引占用 (2^bits+1) 个 int，每次修改环时重建，适合节点很多、查找远多于成员变更的场景。
+// bits 超过 24 时按 24 处理
+func (m *Map) EnableIndex(bits int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if bits > 24 {
+		bits = 24
+	}
+	if bits < 0 {
+		bits = 0
+	}
+	m.indexBits = bits
+	m.reindex()
+}
+
+// reindex 根据当前的环重建分桶索引，调用方需持有写锁
+func (m *Map) reindex() {
+	if m.indexBits == 0 {
+		m.index = nil
+		return
+	}
+	buckets := 1 << m.indexBits
+	shift := 32 - m.indexBits
+	m.index = make([]int, buckets+1)
+	i := 0
+	for b := 0; b < buckets; b++ {
+		for i < len(m.keys) && m.keys[i]>>shift < b {
+			i++
+		}
+		m.index[b] = i
+	}
+	m.index[buckets] = len(m.keys)
+}
+
+// search 返回环上第一个哈希值不小于 hash 的虚拟节点下标，都小于 hash 时返回 len(m.keys)
+func (m *Map) search(hash int) int {
+	lo, hi := 0, len(m.keys)
+	if m.index != nil {
+		// 第 b 个桶内的哈希值不超过第 b+1 个桶的起点，结果一定落在 [index[b], index[b+1]] 之间
+		b := hash >> (32 - m.indexBits)
+		lo, hi = m.index[b], m.index[b+1]
+	}
+	return lo + sort.Search(hi-lo, func(i int) bool {
+		return m.keys[lo+i] >= hash
+	})
 }
 
 // Get 函数主要是通过key获取真实节点
 func (m *Map) Get(key string) string {
-	if len(m.keys) == 0 {
-		return ""
+	return m.Explain(key).Node
+}
+
+// GetN 从 key 的哈希位置顺时针查找，返回最多 n 个不同的真实节点，第一个与 Get 的结果相同，
+// 同一节点的多个虚拟节点只计一次，不可用的节点会被跳过。环上可用节点不足 n 个时返回全部可用节点，
+// 用于为 key 选出主节点和副本
+func (m *Map) GetN(key string, n int) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
 	}
+	idx := m.search(int(m.hash([]byte(key))))
+	seen := make(map[string]bool, n)
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if seen[node] || m.unavailable[node] {
+			continue
+		}
+		seen[node] = true
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Explanation 记录一次节点选择的完整过程，用于排查路由问题
+type Explanation struct {
+	Hash    uint32   // key 的哈希值
+	Index   int      // 命中的位置：哈希环上的下标或 Jump 的桶编号，没有节点时为 -1
+	Skipped []string // 因不可用而被跳过的节点，按查找顺序排列
+	Node    string   // 最终选中的真实节点，没有可用节点时为空
+}
+
+// Explain 与 Get 的查找过程相同，但返回每一步的中间结果
+func (m *Map) Explain(key string) Explanation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.explain(key)
+}
+
+// explain 是不加锁的 Explain，调用方需持有读锁
+func (m *Map) explain(key string) Explanation {
 	// 1. 计算key哈希值
-	hash := int(m.hash([]byte(key)))
+	e := Explanation{Hash: m.hash([]byte(key)), Index: -1}
+	if len(m.keys) == 0 {
+		return e
+	}
+	hash := int(e.Hash)
 	// 2. 通过二分查找找到哈希环数组中第一个比它大的位置（即环的顺时针）
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
-	})
-	// 通过hashMap找到真实的节点
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	idx := m.search(hash)
+	// 通过hashMap找到真实的节点，跳过不可用的节点继续顺时针查找
+	for i := 0; i < len(m.keys); i++ {
+		e.Index = (idx + i) % len(m.keys)
+		node := m.hashMap[m.keys[e.Index]]
+		if !m.unavailable[node] {
+			e.Node = node
+			return e
+		}
+		e.Skipped = append(e.Skipped, node)
+	}
+	e.Index = -1
+	return e
+}
+
+// SetAvailable 标记真实节点 key 是否可用
+// 不可用的节点不会从环上移除，Get 会绕过它落到顺时针的下一个节点，恢复可用后键会重新路由回来
+func (m *Map) SetAvailable(key string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		delete(m.unavailable, key)
+		return
+	}
+	if m.unavailable == nil {
+		m.unavailable = make(map[string]bool)
+	}
+	m.unavailable[key] = true
+}
+
+// Move 描述一个键在成员变更前后的归属节点
+type Move struct {
+	From string
+	To   string
+}
+
+// SimulateChange 预演一次成员变更：加入 add、移除 remove 后，返回 sampleKeys 中归属节点发生变化的键。
+// 预演在环的副本上进行，不会修改当前的环，节点的可用状态会被保留
+func (m *Map) SimulateChange(add, remove []string, sampleKeys []string) map[string]Move {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	removed := make(map[string]bool, len(remove))
+	for _, node := range remove {
+		removed[node] = true
+	}
+	nodes := make(map[string]bool)
+	for _, node := range m.hashMap {
+		if !removed[node] {
+			nodes[node] = true
+		}
+	}
+	for _, node := range add {
+		nodes[node] = true
+	}
+
+	next := New(m.replicas, m.hash)
+	next.maxVirtual = m.maxVirtual
+	next.indexBits = m.indexBits
+	for node, w := range m.weights {
+		if nodes[node] {
+			if next.weights == nil {
+				next.weights = make(map[string]int)
+			}
+			next.weights[node] = w
+		}
+	}
+	members := make([]string, 0, len(nodes))
+	for node := range nodes {
+		members = append(members, node)
+	}
+	next.set(members...)
+	for node := range m.unavailable {
+		next.SetAvailable(node, false)
+	}
+
+	moves := make(map[string]Move)
+	for _, key := range sampleKeys {
+		from, to := m.explain(key).Node, next.Get(key)
+		if from != to {
+			moves[key] = Move{From: from, To: to}
+		}
+	}
+	return moves
 }
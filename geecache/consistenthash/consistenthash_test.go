@@ -1,7 +1,12 @@
 package consistenthash
 
 import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
 	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -40,4 +45,507 @@ func TestHashing(t *testing.T) {
 		}
 	}
 
-}
\ No newline at end of file
+}
+
+func TestSetAvailable(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	// 节点4不可用时，原本落在4上的键顺时针落到6
+	hash.SetAvailable("4", false)
+	testCases := map[string]string{
+		"3":  "6",
+		"23": "6",
+		"11": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	// 恢复后键重新回到节点4
+	hash.SetAvailable("4", true)
+	if hash.Get("3") != "4" || hash.Get("23") != "4" {
+		t.Errorf("keys should route back to 4 once it is available")
+	}
+
+	hash.SetAvailable("2", false)
+	hash.SetAvailable("4", false)
+	hash.SetAvailable("6", false)
+	if hash.Get("3") != "" {
+		t.Errorf("no node should be picked when all are unavailable")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	if e := hash.Explain("1"); e.Index != -1 || e.Node != "" {
+		t.Fatalf("explain on empty ring = %+v", e)
+	}
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	hash.SetAvailable("4", false)
+
+	e := hash.Explain("3")
+	if e.Hash != 3 || e.Index != 2 || e.Node != "6" || len(e.Skipped) != 1 || e.Skipped[0] != "4" {
+		t.Fatalf("Explain(3) = %+v", e)
+	}
+	// 超过环上最大值时回到下标0
+	e = hash.Explain("27")
+	if e.Index != 0 || e.Node != "2" || len(e.Skipped) != 0 {
+		t.Fatalf("Explain(27) = %+v", e)
+	}
+	for _, k := range []string{"1", "3", "11", "15", "23", "27"} {
+		if hash.Explain(k).Node != hash.Get(k) {
+			t.Errorf("Explain(%s) disagrees with Get", k)
+		}
+	}
+}
+
+func TestRecommendReplicas(t *testing.T) {
+	for _, tc := range []struct {
+		nodes int
+		skew  float64
+	}{{3, 0.2}, {5, 0.1}, {10, 0.2}, {20, 0.1}, {50, 0.15}} {
+		replicas := RecommendReplicas(tc.nodes, tc.skew)
+		nodes := make([]string, tc.nodes)
+		for i := range nodes {
+			nodes[i] = fmt.Sprintf("10.0.0.%d:8001", i)
+		}
+		ring := New(replicas, sha1Hash)
+		ring.Add(nodes...)
+		got := skew(ring, nodes, 100000) - 1
+		t.Logf("%d nodes, target %.2f: %d replicas, skew %.3f", tc.nodes, tc.skew, replicas, got)
+		if got > tc.skew {
+			t.Errorf("%d nodes with %d replicas: skew %.3f exceeds target %.2f", tc.nodes, replicas, got, tc.skew)
+		}
+	}
+
+	// 更严格的目标需要更多虚拟节点
+	if RecommendReplicas(10, 0.05) <= RecommendReplicas(10, 0.1) {
+		t.Error("a tighter skew target should recommend more replicas")
+	}
+	if RecommendReplicas(1, 0.1) != 1 {
+		t.Error("a single node needs no virtual nodes")
+	}
+}
+
+// sha1Hash 取 sha1 的前4个字节，分布比 crc32 更接近均匀
+func sha1Hash(data []byte) uint32 {
+	sum := sha1.Sum(data)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func TestSimulateChange(t *testing.T) {
+	ring := New(50, nil)
+	ring.Add("10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001")
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	before := make(map[string]string, len(keys))
+	for _, k := range keys {
+		before[k] = ring.Get(k)
+	}
+
+	moves := ring.SimulateChange([]string{"10.0.0.4:8001"}, []string{"10.0.0.2:8001"}, keys)
+	for _, k := range keys {
+		if ring.Get(k) != before[k] {
+			t.Fatalf("SimulateChange should not modify the ring, %s moved", k)
+		}
+	}
+
+	// 真正执行变更后与预演结果一致
+	applied := New(50, nil)
+	applied.Add("10.0.0.1:8001", "10.0.0.3:8001", "10.0.0.4:8001")
+	for _, k := range keys {
+		after := applied.Get(k)
+		move, moved := moves[k]
+		if moved != (after != before[k]) || (moved && (move.From != before[k] || move.To != after)) {
+			t.Fatalf("key %s: simulated %+v (%v), actual %s -> %s", k, move, moved, before[k], after)
+		}
+		if before[k] == "10.0.0.2:8001" && !moved {
+			t.Fatalf("key %s on removed node should move", k)
+		}
+	}
+	if len(moves) == 0 || len(moves) == len(keys) {
+		t.Fatalf("only part of the keys should move, got %d", len(moves))
+	}
+
+	if moves := ring.SimulateChange(nil, nil, keys); len(moves) != 0 {
+		t.Fatalf("no change should move no keys, got %d", len(moves))
+	}
+}
+
+func TestSet(t *testing.T) {
+	nodes := make([]string, 20)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.0.%d:8001", i)
+	}
+	incremental := New(50, nil)
+	for _, node := range nodes {
+		incremental.Add(node)
+	}
+	rebuilt := New(50, nil)
+	rebuilt.Add("10.0.1.1:8001", nodes[0])
+	rebuilt.SetAvailable("10.0.1.1:8001", false)
+	rebuilt.SetAvailable(nodes[0], false)
+	rebuilt.Set(nodes...)
+	rebuilt.SetAvailable(nodes[0], true)
+
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := rebuilt.Get(key), incremental.Get(key); got != want {
+			t.Fatalf("Get(%s) = %s after Set, %s after incremental Add", key, got, want)
+		}
+	}
+	if len(rebuilt.keys) != len(nodes)*50 || len(rebuilt.unavailable) != 0 {
+		t.Fatalf("ring has %d virtual nodes, %d unavailable", len(rebuilt.keys), len(rebuilt.unavailable))
+	}
+
+	// 仍在环上的节点保留不可用状态
+	rebuilt.SetAvailable(nodes[1], false)
+	rebuilt.Set(nodes[1:]...)
+	for i := 0; i < 1000; i++ {
+		if node := rebuilt.Get(strconv.Itoa(i)); node == nodes[0] || node == nodes[1] {
+			t.Fatalf("key %d routed to %s", i, node)
+		}
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	const replicas = 50 // 与 geecache 默认的虚拟节点倍数相同
+	nodes := make([]string, 100)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.%d.%d:8001", i/256, i%256)
+	}
+	b.Run("Set", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			New(replicas, nil).Set(nodes...)
+		}
+	})
+	b.Run("IncrementalAdd", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m := New(replicas, nil)
+			for _, node := range nodes {
+				m.Add(node)
+			}
+		}
+	})
+}
+
+func TestNewCapped(t *testing.T) {
+	const maxVirtual = 1000
+	m := NewCapped(maxVirtual, nil)
+	var nodes []string
+	for n := 1; n <= 100; n++ {
+		node := fmt.Sprintf("10.0.0.%d:8001", n)
+		nodes = append(nodes, node)
+		m.Add(node)
+		if total := len(m.keys); total > maxVirtual || total < maxVirtual-n {
+			t.Fatalf("%d nodes: %d virtual nodes, cap %d", n, total, maxVirtual)
+		}
+	}
+
+	// 与一次性 Set 得到的环相同
+	rebuilt := NewCapped(maxVirtual, nil)
+	rebuilt.Set(nodes...)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := m.Get(key), rebuilt.Get(key); got != want {
+			t.Fatalf("Get(%s) = %s after Add, %s after Set", key, got, want)
+		}
+	}
+
+	// 节点数超过上限时每个节点至少保留一个虚拟节点
+	small := NewCapped(10, nil)
+	small.Set(nodes[:20]...)
+	if len(small.keys) != 20 {
+		t.Fatalf("ring has %d virtual nodes for 20 nodes", len(small.keys))
+	}
+
+	// 预演移除节点后每个剩余节点的虚拟节点增多，与直接构建的环一致
+	after := NewCapped(maxVirtual, nil)
+	after.Set(nodes[:50]...)
+	sample := make([]string, 200)
+	for i := range sample {
+		sample[i] = strconv.Itoa(i)
+	}
+	moves := m.SimulateChange(nil, nodes[50:], sample)
+	for _, key := range sample {
+		want := after.Get(key)
+		if move, ok := moves[key]; (ok && move.To != want) || (!ok && m.Get(key) != want) {
+			t.Fatalf("SimulateChange moves %s to %+v, want %s", key, move, want)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	nodes := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+	m := New(50, nil)
+	m.Add(nodes...)
+	m.SetAvailable(nodes[1], false)
+	m.Remove(nodes[1])
+
+	want := New(50, nil)
+	want.Add(nodes[0], nodes[2])
+	if !sort.IntsAreSorted(m.keys) || len(m.keys) != len(want.keys) || len(m.hashMap) != len(want.hashMap) {
+		t.Fatalf("ring has %d virtual nodes and %d mappings after Remove", len(m.keys), len(m.hashMap))
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got := m.Get(key); got != want.Get(key) {
+			t.Fatalf("Get(%s) = %s after Remove, want %s", key, got, want.Get(key))
+		}
+	}
+	if len(m.unavailable) != 0 {
+		t.Fatal("removed node should not stay unavailable")
+	}
+
+	// 移除不存在的节点不做任何事
+	m.Remove("10.0.0.9:8001")
+	if len(m.keys) != len(want.keys) {
+		t.Fatal("removing an unknown node should not change the ring")
+	}
+
+	capped := NewCapped(90, nil)
+	capped.Set(nodes...)
+	capped.Remove(nodes[0])
+	if len(capped.keys) != 90 {
+		t.Fatalf("capped ring has %d virtual nodes after Remove", len(capped.keys))
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	m := New(20, nil)
+	m.Add("10.0.0.1:8001")
+
+	// 用 go test -race 运行时，未加锁的读写会被检测为数据竞争
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			node := "10.0.1." + strconv.Itoa(i) + ":8001"
+			m.Add(node)
+			m.SetAvailable(node, i%2 == 0)
+			if i%3 == 0 {
+				m.Remove(node)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			if m.Get(strconv.Itoa(i)) == "" {
+				t.Error("Get returned no node while nodes are being added")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestEnableIndex(t *testing.T) {
+	nodes := make([]string, 200)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.%d.%d:8001", i/256, i%256)
+	}
+	plain, indexed := New(50, nil), New(50, nil)
+	indexed.EnableIndex(12)
+	check := func(stage string) {
+		t.Helper()
+		for i := 0; i < 5000; i++ {
+			key := strconv.Itoa(i)
+			if got, want := indexed.Explain(key), plain.Explain(key); got.Index != want.Index || got.Node != want.Node {
+				t.Fatalf("%s: Explain(%s) = %+v with index, %+v without", stage, key, got, want)
+			}
+		}
+	}
+	check("empty")
+	for _, node := range nodes[:100] {
+		plain.Add(node)
+		indexed.Add(node)
+	}
+	check("Add")
+	plain.Set(nodes...)
+	indexed.Set(nodes...)
+	check("Set")
+	for _, node := range nodes[:50] {
+		plain.Remove(node)
+		indexed.Remove(node)
+	}
+	check("Remove")
+
+	// 环上的最小和最大哈希值处于桶的边界附近
+	for _, hash := range []int{plain.keys[0], plain.keys[len(plain.keys)-1], plain.keys[0] + 1, plain.keys[len(plain.keys)-1] + 1} {
+		if got, want := indexed.search(hash), plain.search(hash); got != want {
+			t.Fatalf("search(%d) = %d with index, %d without", hash, got, want)
+		}
+	}
+
+	indexed.EnableIndex(0)
+	if indexed.index != nil {
+		t.Fatal("EnableIndex(0) should drop the index")
+	}
+	check("disabled")
+}
+
+func BenchmarkGet(b *testing.B) {
+	nodes := make([]string, 1000)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.%d.%d:8001", i/256, i%256)
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, bits := range []int{0, 16} {
+		m := New(50, nil)
+		m.Set(nodes...)
+		m.EnableIndex(bits)
+		b.Run(fmt.Sprintf("IndexBits=%d", bits), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Get(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	m := New(100, nil)
+	m.Add("small")
+	m.AddWeighted("big", 3)
+	counts := make(map[string]int)
+	for _, node := range m.hashMap {
+		counts[node]++
+	}
+	if counts["small"] != 100 || counts["big"] != 300 {
+		t.Fatalf("virtual nodes = %v, want small:100 big:300", counts)
+	}
+
+	owned := make(map[string]int)
+	for i := 0; i < 20000; i++ {
+		owned[m.Get(strconv.Itoa(i))]++
+	}
+	if ratio := float64(owned["big"]) / float64(owned["small"]); ratio < 1.5 || ratio > 4.5 {
+		t.Fatalf("big owns %d keys, small owns %d", owned["big"], owned["small"])
+	}
+
+	m.Remove("big")
+	if len(m.keys) != 100 || len(m.hashMap) != 100 || len(m.weights) != 0 {
+		t.Fatalf("ring has %d virtual nodes, %d weights after Remove", len(m.keys), len(m.weights))
+	}
+
+	// 重新加入时不再沿用之前的权重
+	m.Add("big")
+	if len(m.keys) != 200 {
+		t.Fatalf("ring has %d virtual nodes after re-adding without weight", len(m.keys))
+	}
+
+	// 开启 NewCapped 时虚拟节点按权重分配总数
+	capped := NewCapped(120, nil)
+	capped.AddWeighted("big", 2)
+	capped.Add("small")
+	counts = make(map[string]int)
+	for _, node := range capped.hashMap {
+		counts[node]++
+	}
+	if counts["small"] != 40 || counts["big"] != 80 {
+		t.Fatalf("capped virtual nodes = %v, want small:40 big:80", counts)
+	}
+}
+
+func TestSetWeighted(t *testing.T) {
+	m := New(100, nil)
+	m.SetWeighted([]string{"a", "b", "c"}, map[string]int{"b": 3, "gone": 2})
+	counts := make(map[string]int)
+	for _, node := range m.hashMap {
+		counts[node]++
+	}
+	if counts["a"] != 100 || counts["b"] != 300 || counts["c"] != 100 || len(m.weights) != 1 {
+		t.Fatalf("virtual nodes = %v, weights = %v", counts, m.weights)
+	}
+
+	// 权重被整体替换，不在 weights 中的节点恢复为1
+	m.SetWeighted([]string{"a", "b"}, map[string]int{"a": 2})
+	counts = make(map[string]int)
+	for _, node := range m.hashMap {
+		counts[node]++
+	}
+	if counts["a"] != 200 || counts["b"] != 100 || counts["c"] != 0 {
+		t.Fatalf("virtual nodes after reweighting = %v", counts)
+	}
+}
+
+func TestReAdd(t *testing.T) {
+	for name, m := range map[string]*Map{"plain": New(100, nil), "capped": NewCapped(300, nil)} {
+		m.Add("a", "b", "c")
+		want := m.Get("key")
+		// 重复加入是幂等的
+		m.Add("a")
+		m.Add("b", "b")
+		if len(m.keys) != 300 || len(m.hashMap) != 300 {
+			t.Fatalf("%s: ring has %d virtual nodes after re-adding, want 300", name, len(m.keys))
+		}
+		if got := m.Get("key"); got != want {
+			t.Fatalf("%s: key moved from %s to %s after re-adding", name, want, got)
+		}
+
+		// 重新加入带权重的节点时更新权重，而不是叠加虚拟节点
+		m.AddWeighted("a", 2)
+		m.AddWeighted("a", 3)
+		counts := make(map[string]int)
+		for _, node := range m.hashMap {
+			counts[node]++
+		}
+		if len(m.keys) != len(m.hashMap) || counts["a"] != 3*counts["b"] || counts["b"] != counts["c"] {
+			t.Fatalf("%s: virtual nodes = %v over %d keys after re-weighting", name, counts, len(m.keys))
+		}
+		if !sort.IntsAreSorted(m.keys) {
+			t.Fatalf("%s: ring is not sorted", name)
+		}
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点: 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	cases := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"11", 2, []string{"2", "4"}},
+		{"23", 3, []string{"4", "6", "2"}},
+		{"27", 2, []string{"2", "4"}},
+		{"3", 5, []string{"4", "6", "2"}},
+		{"3", 0, nil},
+	}
+	for _, c := range cases {
+		if got := hash.GetN(c.key, c.n); fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("GetN(%s, %d) = %v, want %v", c.key, c.n, got, c.want)
+		}
+	}
+
+	hash.SetAvailable("4", false)
+	if got := hash.GetN("11", 2); fmt.Sprint(got) != "[2 6]" {
+		t.Errorf("GetN should skip unavailable nodes, got %v", got)
+	}
+	if got := New(3, nil).GetN("k", 2); len(got) != 0 {
+		t.Errorf("GetN on an empty ring = %v", got)
+	}
+}
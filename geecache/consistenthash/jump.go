@@ -0,0 +1,145 @@
+package consistenthash
+
+import "sort"
+
+// Picker 根据 key 选出负责它的真实节点，Map(哈希环) 和 Jump(跳跃一致性哈希) 都实现了该接口
+type Picker interface {
+	Add(keys ...string)
+	Set(keys ...string)
+	Get(key string) string
+	Explain(key string) Explanation
+	SetAvailable(key string, ok bool)
+}
+
+// WeightedPicker 是支持节点权重的 Picker，Map 实现了该接口
+type WeightedPicker interface {
+	Picker
+	SetWeighted(keys []string, weights map[string]int)
+}
+
+var (
+	_ Picker         = (*Map)(nil)
+	_ Picker         = (*Jump)(nil)
+	_ WeightedPicker = (*Map)(nil)
+)
+
+// Jump 基于 Jump Consistent Hash 选择节点
+// 与哈希环相比不需要虚拟节点，内存只与节点数成正比，且键的分布几乎完全均匀
+// 节点按 Add 的顺序编号，只有在末尾追加节点时迁移的键最少，因此所有节点需要以相同顺序 Add。
+// Set 与传入的顺序无关：已有的节点保留编号，离开的节点留下空位，新节点按地址排序后先填补空位再追加到末尾，
+// 经历相同成员变化的节点得到相同的编号
+type Jump struct {
+	hash        Hash
+	nodes       []string        // 按加入顺序排列的真实节点，下标即桶编号，离开的节点为空字符串
+	index       map[string]bool // 已加入的节点，用于去重
+	unavailable map[string]bool // 被标记为不可用的节点
+}
+
+// NewJump 创建一个 Jump，fn 为 nil 时使用 crc32
+func NewJump(fn Hash) *Jump {
+	m := New(0, fn)
+	return &Jump{
+		hash:  m.hash,
+		index: make(map[string]bool),
+	}
+}
+
+// Add 按顺序追加真实节点，已存在的节点会被忽略
+func (j *Jump) Add(keys ...string) {
+	for _, key := range keys {
+		if j.index[key] {
+			continue
+		}
+		j.index[key] = true
+		j.nodes = append(j.nodes, key)
+	}
+}
+
+// Set 将节点替换为 keys，结果与 keys 的顺序无关。仍然存在的节点保留编号和可用状态，
+// 离开的节点留下空位，落在空位上的键由下一个桶的节点负责，其他键的归属不变
+func (j *Jump) Set(keys ...string) {
+	index := make(map[string]bool, len(keys))
+	var added []string
+	for _, key := range keys {
+		if index[key] {
+			continue
+		}
+		index[key] = true
+		if !j.index[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for i, node := range j.nodes {
+		if node == "" || index[node] {
+			continue
+		}
+		j.nodes[i] = ""
+	}
+	// 新节点先填补空位，接替离开的节点负责的键
+	for i := 0; i < len(j.nodes) && len(added) > 0; i++ {
+		if j.nodes[i] == "" {
+			j.nodes[i], added = added[0], added[1:]
+		}
+	}
+	j.nodes = append(j.nodes, added...)
+	for len(j.nodes) > 0 && j.nodes[len(j.nodes)-1] == "" {
+		j.nodes = j.nodes[:len(j.nodes)-1]
+	}
+	j.index = index
+	for node := range j.unavailable {
+		if !j.index[node] {
+			delete(j.unavailable, node)
+		}
+	}
+}
+
+// Get 返回负责 key 的真实节点，选中的节点不可用时依次尝试下一个桶
+func (j *Jump) Get(key string) string {
+	return j.Explain(key).Node
+}
+
+// Explain 与 Get 的查找过程相同，Index 为最终选中的桶编号
+func (j *Jump) Explain(key string) Explanation {
+	e := Explanation{Hash: j.hash([]byte(key)), Index: -1}
+	if len(j.nodes) == 0 {
+		return e
+	}
+	b := jumpHash(uint64(e.Hash), len(j.nodes))
+	for i := 0; i < len(j.nodes); i++ {
+		idx := (b + i) % len(j.nodes)
+		node := j.nodes[idx]
+		if node == "" {
+			continue
+		}
+		if !j.unavailable[node] {
+			e.Index, e.Node = idx, node
+			return e
+		}
+		e.Skipped = append(e.Skipped, node)
+	}
+	return e
+}
+
+// SetAvailable 标记真实节点 key 是否可用
+func (j *Jump) SetAvailable(key string, ok bool) {
+	if ok {
+		delete(j.unavailable, key)
+		return
+	}
+	if j.unavailable == nil {
+		j.unavailable = make(map[string]bool)
+	}
+	j.unavailable[key] = true
+}
+
+// jumpHash 是 Lamping 和 Veach 提出的跳跃一致性哈希算法，返回 [0, buckets) 中的桶编号
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
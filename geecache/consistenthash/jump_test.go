@@ -0,0 +1,119 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestJumpStable(t *testing.T) {
+	jump := NewJump(nil)
+	jump.Add("a", "b", "c")
+
+	owners := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = jump.Get(key)
+	}
+
+	// 在末尾追加节点后，只有迁移到新节点的键会改变归属
+	jump.Add("d")
+	moved := 0
+	for key, owner := range owners {
+		if got := jump.Get(key); got != owner {
+			if got != "d" {
+				t.Fatalf("key %s moved from %s to %s, should only move to d", key, owner, got)
+			}
+			moved++
+		}
+	}
+	if moved < 2000 || moved > 3000 {
+		t.Fatalf("expected about 1/4 of keys to move, got %d", moved)
+	}
+}
+
+func TestJumpDistribution(t *testing.T) {
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.0.%d:8001", i)
+	}
+	ring := New(50, nil)
+	ring.Add(nodes...)
+	jump := NewJump(nil)
+	jump.Add(nodes...)
+
+	ringSkew := skew(ring, nodes, 100000)
+	jumpSkew := skew(jump, nodes, 100000)
+	t.Logf("max load / mean load: ring %.3f, jump %.3f", ringSkew, jumpSkew)
+	if jumpSkew > ringSkew || jumpSkew > 1.05 {
+		t.Fatalf("jump skew %.3f should be lower than ring skew %.3f", jumpSkew, ringSkew)
+	}
+}
+
+// skew 返回最繁忙节点承担的键数与平均值之比
+func skew(p Picker, nodes []string, n int) float64 {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[p.Get("key-"+strconv.Itoa(i))]++
+	}
+	max := 0
+	for _, c := range counts {
+		max = int(math.Max(float64(max), float64(c)))
+	}
+	return float64(max) / (float64(n) / float64(len(nodes)))
+}
+
+func TestJumpSet(t *testing.T) {
+	j := NewJump(nil)
+	j.Add("a", "b", "c")
+	j.SetAvailable("b", false)
+	j.SetAvailable("c", false)
+	j.Set("a", "b", "d")
+
+	want := NewJump(nil)
+	want.Add("a", "b", "d")
+	want.SetAvailable("b", false)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if j.Get(key) != want.Get(key) {
+			t.Fatalf("Get(%s) = %s after Set, want %s", key, j.Get(key), want.Get(key))
+		}
+	}
+	if j.unavailable["c"] {
+		t.Fatal("removed node should not keep its availability")
+	}
+}
+
+func TestJumpSetOrder(t *testing.T) {
+	a, b := NewJump(nil), NewJump(nil)
+	a.Set("n1", "n2", "n3", "n4")
+	b.Set("n4", "n2", "n1", "n3")
+	owners := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		if a.Get(key) != b.Get(key) {
+			t.Fatalf("Get(%s) = %s and %s, should not depend on the order of Set", key, a.Get(key), b.Get(key))
+		}
+		owners[key] = a.Get(key)
+	}
+
+	// 离开的节点留下空位，只有它负责的键改变归属
+	a.Set("n1", "n2", "n4")
+	for key, owner := range owners {
+		if got := a.Get(key); got != owner && owner != "n3" {
+			t.Fatalf("key %s moved from %s to %s after n3 left", key, owner, got)
+		}
+	}
+	// 新节点填补空位，接替离开的节点负责的键
+	a.Set("n0", "n1", "n2", "n4")
+	for key, owner := range owners {
+		want := owner
+		if owner == "n3" {
+			want = "n0"
+		}
+		if got := a.Get(key); got != want {
+			t.Fatalf("key %s = %s after n0 joined, want %s", key, got, want)
+		}
+	}
+}
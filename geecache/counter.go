@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "geecache/proto"
+	"strconv"
+)
+
+// Increment 原子地将 key 对应的计数器加上 delta 并返回新值，计数器以十进制字符串保存。
+// 请求会被转发给负责该key的节点执行，因此每个分片上的计数是权威的；
+// 计数器不在缓存中时以数据源中的值为初值，数据源返回 ErrNotFound 时从0开始。
+// 其他节点通过 Get 读到的可能是 hotCache 中的旧值
+func (g *Group) Increment(key string, delta int64) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+	if peers := g.getPeers(); peers != nil {
+		if peer, ok := peers.PickPeer(key); ok && !isNilPeer(peer) {
+			inc, ok := peer.(PeerIncrementer)
+			if !ok {
+				return 0, fmt.Errorf("peer %T does not support Increment", peer)
+			}
+			res := &pb.IncrementResponse{}
+			if err := inc.Increment(&pb.IncrementRequest{Group: g.name, Key: key, Delta: delta}, res); err != nil {
+				return 0, err
+			}
+			return res.GetValue(), nil
+		}
+	}
+	return g.incrementLocally(key, delta)
+}
+
+// incrementLocally 在本节点的 mainCache 上执行原子的读-改-写
+func (g *Group) incrementLocally(key string, delta int64) (int64, error) {
+	// 缓存中没有时先从数据源取初值，取数据源期间不持有缓存的锁
+	var base []byte
+	if _, ok := g.mainCache.get(key); !ok {
+		b, err := g.fetch(context.Background(), key)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return 0, err
+		}
+		base = b
+	}
+
+	var n int64
+	value, err := g.mainCache.update(key, func(old ByteView, ok bool) (ByteView, error) {
+		b := base
+		if ok {
+			// 期间已经有其他调用写入了计数器，以缓存中的值为准
+			b = old.ByteSlice()
+		}
+		var err error
+		if n, err = parseCounter(b); err != nil {
+			return ByteView{}, fmt.Errorf("value of %s is not a counter: %v", key, err)
+		}
+		n += delta
+		return ByteView{b: []byte(strconv.FormatInt(n, 10))}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	g.updateExperiments(key, value)
+	return n, nil
+}
+
+// parseCounter 将十进制字符串解析为计数，空值视为0
+func parseCounter(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
@@ -0,0 +1,107 @@
+package geecache
+
+import (
+	"context"
+	pb "geecache/proto"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// counterPeer 将计数请求直接转发给同进程中负责计数器的 Group
+type counterPeer struct {
+	groupPeer
+}
+
+func (p *counterPeer) Increment(in *pb.IncrementRequest, out *pb.IncrementResponse) error {
+	n, err := p.g.Increment(in.GetKey(), in.GetDelta())
+	out.Value = n
+	return err
+}
+
+func TestIncrement(t *testing.T) {
+	owner := NewGroup("counter-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "seeded":
+			return []byte("10"), nil
+		case "bad":
+			return []byte("abc"), nil
+		}
+		return nil, ErrNotFound
+	}), WithTTL(time.Hour))
+	client := NewGroup("counter-client", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be counted on the owner", key)
+		return nil, nil
+	}), WithTTL(time.Hour))
+	client.RegisterPeers(nilPeers{peer: &counterPeer{groupPeer{g: owner}}})
+
+	// 并发地从两个节点增加，最终在负责的节点上收敛
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := owner
+			if i%2 == 0 {
+				g = client
+			}
+			for j := 0; j < 50; j++ {
+				if _, err := g.Increment("hits", 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n, err := client.Increment("hits", 0); err != nil || n != 1000 {
+		t.Fatalf("hits = %d, %v, want 1000", n, err)
+	}
+	if v, _ := owner.mainCache.get("hits"); v.String() != "1000" {
+		t.Fatalf("owner should store the counter, got %q", v.String())
+	}
+	if _, ok := client.mainCache.get("hits"); ok {
+		t.Fatal("non-owner should not store the counter")
+	}
+
+	// 以数据源中的值为初值
+	if n, err := client.Increment("seeded", -3); err != nil || n != 7 {
+		t.Fatalf("seeded = %d, %v, want 7", n, err)
+	}
+	if _, err := owner.Increment("bad", 1); err == nil {
+		t.Fatal("non-numeric value should fail")
+	}
+}
+
+func TestIncrementRPC(t *testing.T) {
+	NewGroup("counter-rpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithTTL(time.Hour))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(s.self, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGroupCacheClient(conn)
+	for i, want := range []int64{2, 4, 6} {
+		res, err := client.Increment(context.Background(), &pb.IncrementRequest{Group: "counter-rpc", Key: "k", Delta: 2})
+		if err != nil || res.GetValue() != want {
+			t.Fatalf("increment %d = %v, %v, want %d", i, res, err, want)
+		}
+	}
+}
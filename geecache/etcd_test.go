@@ -0,0 +1,247 @@
+//go:build etcd
+
+// 依赖内嵌etcd服务端的测试，需要 go test -tags etcd 运行，避免核心测试引入 etcd/server 的依赖
+package geecache
+
+import (
+	"context"
+	"geecache/registry"
+	"net"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd 启动一个嵌入式的单节点etcd，返回连接它的客户端
+func startEtcd(t *testing.T) *clientv3.Client {
+	freeURL := func() url.URL {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		return url.URL{Scheme: "http", Host: lis.Addr().String()}
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	client, peer := freeURL(), freeURL()
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{client}, []url.URL{client}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.Name + "=" + peer.String()
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd took too long to start")
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{client.Host}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// sameGroupLock 使不同名字的 Group 竞争同一把加载锁，模拟不同节点上的同一个 Group
+type sameGroupLock struct {
+	*registry.LoadLock
+	group string
+}
+
+func (l sameGroupLock) LockLoad(ctx context.Context, _, key string) (string, func(bool), error) {
+	return l.LoadLock.LockLoad(ctx, l.group, key)
+}
+
+// lookupPeers 认为当前节点负责所有key(模拟成员变化期间的归属重叠)，但可以按地址找到其他节点
+type lookupPeers map[string]PeerGetter
+
+func (p lookupPeers) PickPeer(key string) (PeerGetter, bool) {
+	return nil, false
+}
+
+func (p lookupPeers) LookupPeer(addr string) (PeerGetter, bool) {
+	peer, ok := p[addr]
+	return peer, ok
+}
+
+func TestLoadLock(t *testing.T) {
+	cli := startEtcd(t)
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(100 * time.Millisecond)
+		return []byte(key + "-value"), nil
+	})
+	a := NewGroup("load-lock-a", 2<<10, getter, WithTTL(time.Hour),
+		WithLoadLock(sameGroupLock{registry.NewLoadLock(cli, "node-a", 5), "load-lock"}))
+	b := NewGroup("load-lock-b", 2<<10, getter, WithTTL(time.Hour),
+		WithLoadLock(sameGroupLock{registry.NewLoadLock(cli, "node-b", 5), "load-lock"}))
+	peers := lookupPeers{"node-a": &groupPeer{g: a}, "node-b": &groupPeer{g: b}}
+	a.RegisterPeers(peers)
+	b.RegisterPeers(peers)
+
+	// 两个节点都认为自己负责 cold，同时加载
+	var wg sync.WaitGroup
+	for _, g := range []*Group{a, b} {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if v, err := g.Get("cold"); err != nil || v.String() != "cold-value" {
+				t.Errorf("%s get = %q, %v", g.name, v.String(), err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("cold key loaded %d times across two nodes, want 1", loads)
+	}
+	for _, g := range []*Group{a, b} {
+		if _, ok := g.mainCache.get("cold"); !ok {
+			t.Fatalf("%s should cache the value", g.name)
+		}
+	}
+}
+
+func TestLoadLockReusesSession(t *testing.T) {
+	cli := startEtcd(t)
+	l := registry.NewLoadLock(cli, "node-a", 60)
+	defer l.Close()
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, unlock, err := l.LockLoad(context.Background(), "session-reuse", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlock(true)
+	}
+	// 一个会话租约加一个加载记录复用的租约
+	leases, err := cli.Leases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Leases) != 2 {
+		t.Fatalf("loads should share the session and record leases, got %d leases", len(leases.Leases))
+	}
+
+	// 同一个节点上同一个key的锁仍然互斥
+	_, unlock, err := l.LockLoad(context.Background(), "session-reuse", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := l.LockLoad(ctx, "session-reuse", "a"); err == nil {
+		t.Fatal("a second lock on the same key should wait for the first")
+	}
+	unlock(false)
+}
+
+// sameGroupBus 使不同名字的 Group 共享同一组失效通知，模拟不同节点上的同一个 Group
+type sameGroupBus struct {
+	*registry.InvalidationBus
+	group string
+}
+
+func (b sameGroupBus) Publish(ctx context.Context, _, key string) error {
+	return b.InvalidationBus.Publish(ctx, b.group, key)
+}
+
+func (b sameGroupBus) Subscribe(_ string, fn func(key string)) (func(), error) {
+	return b.InvalidationBus.Subscribe(b.group, fn)
+}
+
+func TestInvalidationBus(t *testing.T) {
+	cli := startEtcd(t)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	})
+	bus := sameGroupBus{registry.NewInvalidationBus(cli, 5), "invalidate"}
+	a := NewGroup("invalidate-a", 2<<10, getter, WithTTL(time.Hour), WithInvalidationBus(bus))
+	b := NewGroup("invalidate-b", 2<<10, getter, WithTTL(time.Hour), WithInvalidationBus(bus))
+	for _, g := range []*Group{a, b} {
+		g.Get("k")
+		g.Get("other")
+	}
+
+	// a 上的删除经etcd传播到 b
+	if err := a.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.mainCache.get("k"); ok {
+		t.Fatal("Delete should remove the key locally")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := b.mainCache.get("k"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("invalidation should propagate to the other node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := b.mainCache.get("other"); !ok {
+		t.Fatal("other keys should stay cached")
+	}
+}
+
+func TestInvalidationBusReusesLease(t *testing.T) {
+	cli := startEtcd(t)
+	bus := registry.NewInvalidationBus(cli, 60)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := bus.Publish(context.Background(), "lease-reuse", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leases, err := cli.Leases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Leases) != 1 {
+		t.Fatalf("publishes within ttl/2 should share one lease, got %d", len(leases.Leases))
+	}
+}
+
+func TestEtcdDiscovery(t *testing.T) {
+	d := registry.NewEtcdDiscovery(startEtcd(t), registry.Metadata{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Watch(ctx, "discovery-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// next 返回下一次变化后的节点列表
+	next := func() []string {
+		select {
+		case addrs := <-ch:
+			return addrs
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update from watch")
+			return nil
+		}
+	}
+	stop := make(chan error)
+	done := make(chan error, 1)
+	go func() { done <- d.Register("discovery-etcd", "127.0.0.1:9001", stop) }()
+	if addrs := next(); !reflect.DeepEqual(addrs, []string{"127.0.0.1:9001"}) {
+		t.Fatalf("peers after register = %v", addrs)
+	}
+	if addrs, err := d.Resolve("discovery-etcd"); err != nil || !reflect.DeepEqual(addrs, []string{"127.0.0.1:9001"}) {
+		t.Fatalf("Resolve = %v, %v", addrs, err)
+	}
+	close(stop)
+	<-done
+	if addrs := next(); len(addrs) != 0 {
+		t.Fatalf("peers after deregister = %v", addrs)
+	}
+}
@@ -0,0 +1,94 @@
+package geecache
+
+import "sync"
+
+// EventType 缓存生命周期事件的类型
+type EventType int
+
+const (
+	EventLoad   EventType = iota // 数据从远程节点或数据源加载
+	EventHit                     // 命中本地缓存
+	EventEvict                   // 数据被淘汰或过期
+	EventDelete                  // 数据被主动删除
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventLoad:
+		return "load"
+	case EventHit:
+		return "hit"
+	case EventEvict:
+		return "evict"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// CacheEvent 描述一次缓存生命周期事件
+type CacheEvent struct {
+	Type   EventType
+	Group  string // 缓存组的名字
+	Key    string
+	Source string // 事件来源：hot/main/stale 表示本地缓存(stale 为远程节点故障时返回的过期数据)，peer/replica/local 表示加载自远程节点/只读副本/数据源
+}
+
+// eventBufferSize 每个订阅者的事件缓冲大小，缓冲满时新事件会被丢弃
+const eventBufferSize = 64
+
+// eventBus 将事件非阻塞地分发给所有订阅者
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers []chan CacheEvent
+}
+
+// subscribe 注册一个新的订阅者
+func (b *eventBus) subscribe() <-chan CacheEvent {
+	ch := make(chan CacheEvent, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, ch)
+	b.mu.Unlock()
+	return ch
+}
+
+// unsubscribe 移除订阅者，之后不再向其发送事件
+func (b *eventBus) unsubscribe(ch <-chan CacheEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subscribers {
+		if sub == ch {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish 向所有订阅者发送事件，订阅者消费过慢导致缓冲已满时丢弃该事件，不会阻塞缓存
+func (b *eventBus) publish(ev CacheEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Events 订阅该 Group 的缓存生命周期事件(加载、命中、淘汰、删除)
+// 每次调用返回一个独立的带缓冲 channel，消费过慢时事件会被丢弃而不会拖慢缓存
+func (g *Group) Events() <-chan CacheEvent {
+	return g.events.subscribe()
+}
+
+// subscribeEvents 与 Events 相同，额外返回取消订阅的函数，用于生命周期有限的订阅者
+func (g *Group) subscribeEvents() (<-chan CacheEvent, func()) {
+	ch := g.events.subscribe()
+	return ch, func() { g.events.unsubscribe(ch) }
+}
+
+// emit 发布一条缓存事件
+func (g *Group) emit(typ EventType, key, source string) {
+	g.events.publish(CacheEvent{Type: typ, Group: g.name, Key: key, Source: source})
+}
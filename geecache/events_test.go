@@ -0,0 +1,67 @@
+package geecache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+
+	gee := NewGroup("events", 2<<10, getter, WithTTL(time.Hour))
+	events := gee.Events()
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	// 数据过期后再次 Get 会先淘汰再重新加载
+	expired := NewGroup("events-expired", 2<<10, getter, WithTTL(-time.Hour))
+	expiredEvents := expired.Events()
+	expired.Get("Tom")
+	expired.Get("Tom")
+
+	expect := []CacheEvent{
+		{Type: EventLoad, Group: "events", Key: "Tom", Source: "local"},
+		{Type: EventHit, Group: "events", Key: "Tom", Source: "main"},
+	}
+	if got := drainEvents(events); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("events = %v, expect %v", got, expect)
+	}
+	expect = []CacheEvent{
+		{Type: EventLoad, Group: "events-expired", Key: "Tom", Source: "local"},
+		{Type: EventEvict, Group: "events-expired", Key: "Tom", Source: "main"},
+		{Type: EventLoad, Group: "events-expired", Key: "Tom", Source: "local"},
+	}
+	if got := drainEvents(expiredEvents); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("events = %v, expect %v", got, expect)
+	}
+}
+
+func TestEventsSlowConsumer(t *testing.T) {
+	gee := NewGroup("events-slow", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithTTL(time.Hour))
+	events := gee.Events()
+
+	// 没有消费者读取时，Get 也不能被阻塞
+	for i := 0; i < eventBufferSize*2; i++ {
+		gee.Get("Tom")
+	}
+	if n := len(drainEvents(events)); n != eventBufferSize {
+		t.Fatalf("expected %d buffered events, got %d", eventBufferSize, n)
+	}
+}
+
+func drainEvents(ch <-chan CacheEvent) []CacheEvent {
+	var events []CacheEvent
+	for {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
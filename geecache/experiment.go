@@ -0,0 +1,139 @@
+package geecache
+
+import (
+	"context"
+	"geecache/lru"
+	"time"
+)
+
+// ExperimentConfig 是实验分桶使用的替代配置，用于在同一个集群中对比不同缓存参数的命中率，见 WithExperiment
+type ExperimentConfig struct {
+	TTL        time.Duration // 分桶中数据的过期时间，为0时与 mainCache 相同
+	CacheBytes int64         // 分桶缓存的容量，为0时与 mainCache 相同
+	LRUK       int           // 不小于2时分桶缓存使用 LRU-K 淘汰策略(见 lru.WithLRUK)，否则为普通 LRU
+}
+
+// ExperimentStats 是一个实验分桶的命中统计
+type ExperimentStats struct {
+	Hits   int64 // 命中分桶缓存的次数
+	Misses int64 // 未命中分桶缓存的次数
+}
+
+// HitRatio 返回分桶的命中率，没有请求时为0
+func (s ExperimentStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// experiment 是一个实验分桶，拥有独立的缓存和计数
+type experiment struct {
+	cfg    ExperimentConfig
+	cache  cache
+	hits   AtomicInt
+	misses AtomicInt
+}
+
+// experimentKey 是实验分桶名在 context 中的键
+type experimentKey struct{}
+
+// ContextWithExperiment 返回携带实验分桶名 bucket 的 ctx，GetContext 据此使用 WithExperiment 注册的替代配置
+func ContextWithExperiment(ctx context.Context, bucket string) context.Context {
+	return context.WithValue(ctx, experimentKey{}, bucket)
+}
+
+// ExperimentFromContext 返回 ctx 携带的实验分桶名，没有时为空
+func ExperimentFromContext(ctx context.Context) string {
+	bucket, _ := ctx.Value(experimentKey{}).(string)
+	return bucket
+}
+
+// WithExperiment 注册实验分桶 bucket：ctx 携带该分桶名(见 ContextWithExperiment)的 GetContext 使用一份独立的、
+// 按 cfg 配置的缓存，命中和未命中单独统计(见 ExperimentStats)，未命中时与其他请求一样经远程节点或数据源加载。
+// 未携带或携带未注册分桶名的请求不受影响
+func WithExperiment(bucket string, cfg ExperimentConfig) GroupOption {
+	return func(g *Group) {
+		if g.experiments == nil {
+			g.experiments = make(map[string]*experiment)
+		}
+		g.experiments[bucket] = &experiment{cfg: cfg}
+	}
+}
+
+// initExperiments 在所有 GroupOption 生效后创建分桶缓存，未配置的参数沿用 mainCache 的设置
+func (g *Group) initExperiments() {
+	for _, e := range g.experiments {
+		e.cache = cache{
+			cacheBytes: g.mainCache.cacheBytes,
+			ttl:        g.mainCache.ttl,
+			lruOpts:    append([]lru.Option(nil), g.mainCache.lruOpts...),
+			codec:      g.mainCache.codec,
+			cleanup:    g.mainCache.cleanup,
+		}
+		if e.cfg.TTL != 0 {
+			e.cache.ttl = e.cfg.TTL
+		}
+		if e.cfg.CacheBytes > 0 {
+			e.cache.cacheBytes = e.cfg.CacheBytes
+		}
+		if e.cfg.LRUK >= 2 {
+			e.cache.lruOpts = append(e.cache.lruOpts, lru.WithLRUK(e.cfg.LRUK))
+		}
+	}
+}
+
+// experimentFor 返回 ctx 携带的已注册实验分桶，没有时返回 nil
+func (g *Group) experimentFor(ctx context.Context) *experiment {
+	if len(g.experiments) == 0 {
+		return nil
+	}
+	return g.experiments[ExperimentFromContext(ctx)]
+}
+
+// getExperiment 从分桶缓存中获取 key。未命中时与未携带分桶名的请求一样处理负缓存，
+// 经远程节点或数据源加载(远程节点失败时可能返回过期数据，本地加载的数据同样保存到 mainCache)，
+// 再保存到分桶缓存中。分桶不查找 mainCache 和 hotCache，以免掩盖替代配置的效果
+func (g *Group) getExperiment(ctx context.Context, e *experiment, key string) (ByteView, error) {
+	if v, ok := e.cache.get(key); ok {
+		e.hits.Add(1)
+		g.counters.hits.Add(1)
+		g.emit(EventHit, key, "experiment")
+		return v, nil
+	}
+	e.misses.Add(1)
+	if g.cachedNotFound(key) {
+		return ByteView{}, ErrCachedNotFound
+	}
+	g.counters.misses.Add(1)
+	value, err := g.load(ctx, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	e.cache.add(key, value)
+	return value, nil
+}
+
+// ExperimentStats 返回每个实验分桶的命中统计
+func (g *Group) ExperimentStats() map[string]ExperimentStats {
+	stats := make(map[string]ExperimentStats, len(g.experiments))
+	for bucket, e := range g.experiments {
+		stats[bucket] = ExperimentStats{Hits: e.hits.Get(), Misses: e.misses.Get()}
+	}
+	return stats
+}
+
+// updateExperiments 用 value 替换各分桶缓存中 key 的旧值，Set、Increment 和重新加载经 mainCache 写入时调用。
+// 分桶中没有该 key 时不添加，分桶的命中率只由携带分桶名的请求决定
+func (g *Group) updateExperiments(key string, value ByteView) {
+	for _, e := range g.experiments {
+		e.cache.replace(key, value)
+	}
+}
+
+// removeFromExperiments 从所有分桶缓存中删除 key
+func (g *Group) removeFromExperiments(key string) {
+	for _, e := range g.experiments {
+		e.cache.remove(key)
+	}
+}
@@ -0,0 +1,120 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExperiment(t *testing.T) {
+	loads := 0
+	gee := NewGroup("experiment", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithTTL(time.Hour),
+		WithExperiment("short-ttl", ExperimentConfig{TTL: time.Minute}),
+		WithExperiment("tiny-ttl", ExperimentConfig{TTL: 30 * time.Millisecond}))
+
+	short := ContextWithExperiment(context.Background(), "short-ttl")
+	if v, err := gee.GetContext(short, "a"); err != nil || v.String() != "a" {
+		t.Fatalf("tagged Get = %q, %v", v.String(), err)
+	}
+	if _, err := gee.GetContext(short, "a"); err != nil || loads != 1 {
+		t.Fatalf("tagged Get should hit the bucket cache, loads = %d, err %v", loads, err)
+	}
+	// 分桶使用替代的 TTL，未携带分桶名的请求仍使用组的 TTL
+	expire, _, ok := gee.experiments["short-ttl"].cache.expiry("a")
+	if remaining := time.Until(expire); !ok || remaining > time.Minute || remaining < 50*time.Second {
+		t.Fatalf("bucket ttl = %v, %v, want about a minute", remaining, ok)
+	}
+	if _, ttl, err := gee.GetWithTTL("a"); err != nil || ttl < 59*time.Minute {
+		t.Fatalf("untagged ttl = %v, %v, want about an hour", ttl, err)
+	}
+	// 未注册的分桶名不受影响
+	if _, err := gee.GetContext(ContextWithExperiment(context.Background(), "unknown"), "a"); err != nil || loads != 1 {
+		t.Fatalf("unknown bucket should use the main cache, loads = %d, err %v", loads, err)
+	}
+
+	// 分桶中的数据按自己的 TTL 过期，需要重新加载
+	tiny := ContextWithExperiment(context.Background(), "tiny-ttl")
+	gee.GetContext(tiny, "a")
+	time.Sleep(50 * time.Millisecond)
+	gee.GetContext(tiny, "a")
+	if loads != 3 {
+		t.Fatalf("loads = %d, want 3", loads)
+	}
+
+	stats := gee.ExperimentStats()
+	if s := stats["short-ttl"]; s.Hits != 1 || s.Misses != 1 || s.HitRatio() != 0.5 {
+		t.Fatalf("short-ttl stats = %+v", s)
+	}
+	if s := stats["tiny-ttl"]; s.Hits != 0 || s.Misses != 2 || s.HitRatio() != 0 {
+		t.Fatalf("tiny-ttl stats = %+v", s)
+	}
+
+	// 删除同样作用于分桶缓存
+	gee.Remove("a")
+	gee.GetContext(short, "a")
+	if loads != 4 {
+		t.Fatalf("Remove should clear bucket caches, loads = %d", loads)
+	}
+}
+
+func TestExperimentSharesReadAndWritePaths(t *testing.T) {
+	version := 0
+	gee := NewGroup("experiment-paths", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		version++
+		return []byte(key + strconv.Itoa(version)), nil
+	}), WithTTL(time.Hour), WithNegativeTTL(time.Hour),
+		WithExperiment("bucket", ExperimentConfig{TTL: time.Minute}))
+	events := gee.Events()
+	ctx := ContextWithExperiment(context.Background(), "bucket")
+
+	// 未命中分桶时加载的数据同样保存到 mainCache
+	gee.GetContext(ctx, "a")
+	if v, ok := gee.mainCache.get("a"); !ok || v.String() != "a1" {
+		t.Fatalf("bucket miss should populate mainCache, got %q, %v", v.String(), ok)
+	}
+	if v, err := gee.GetContext(ctx, "a"); err != nil || v.String() != "a1" {
+		t.Fatalf("tagged Get = %q, %v", v.String(), err)
+	}
+	if got := drainEvents(events); len(got) != 2 || got[1].Type != EventHit || got[1].Source != "experiment" {
+		t.Fatalf("events = %v, want a load and an experiment hit", got)
+	}
+
+	// 负缓存对携带分桶名的请求同样生效
+	gee.Get("missing")
+	if _, err := gee.GetContext(ctx, "missing"); !errors.Is(err, ErrCachedNotFound) {
+		t.Fatalf("tagged Get of a tombstoned key = %v, want ErrCachedNotFound", err)
+	}
+
+	// Refresh、Set 和 Increment 更新分桶中已有的值
+	if _, err := gee.Refresh("a"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := gee.GetContext(ctx, "a"); v.String() != "a2" {
+		t.Fatalf("bucket after Refresh = %q, want a2", v.String())
+	}
+	gee.Set("n", []byte("1"), 0)
+	gee.GetContext(ctx, "n")
+	gee.Set("n", []byte("5"), 0)
+	if v, _ := gee.GetContext(ctx, "n"); v.String() != "5" {
+		t.Fatalf("bucket after Set = %q, want 5", v.String())
+	}
+	if _, err := gee.Increment("n", 2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := gee.GetContext(ctx, "n"); v.String() != "7" {
+		t.Fatalf("bucket after Increment = %q, want 7", v.String())
+	}
+	// 写入不会把分桶中没有的 key 加进去
+	gee.Set("untagged", []byte("x"), 0)
+	if _, ok := gee.experiments["bucket"].cache.get("untagged"); ok {
+		t.Fatal("writes should not add keys to the bucket")
+	}
+}
@@ -1,11 +1,17 @@
 package geecache
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"geecache/lru"
 	pb "geecache/proto"
 	"geecache/singleflight"
+	"hash/crc32"
 	"log"
 	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,20 +19,100 @@ import (
 
 const (
 	defaultHotCacheRatio      = 8
-	defaultMaxMinuteRemoteQPS = 10
+	defaultMaxMinuteRemoteQPS = 10 // 默认的热点提升阈值：每个统计窗口(默认1分钟)的远程访问次数，见 WithPromotionThreshold
+	defaultPromotionWindow    = time.Minute
+	defaultHotKeyFactor       = 5
+	defaultMaxKeyStats        = 10000
+	defaultTTL                = time.Minute // 未通过 WithTTL 设置时缓存数据的过期时间
+	publishTimeout            = 5 * time.Second // Delete 发布失效通知的超时时间
+)
+
+// ApproxMemoryBytes 使用的每条数据的额外内存估算，单位为字节，按 64 位平台估算，可根据实际的堆分析结果调整
+const (
+	// cacheEntryOverhead 缓存中每个节点除键和值内容以外的开销：链表节点(40)、lru 节点结构体(约200)、
+	// map 中的键和指针及桶的摊销(约48)、装箱在接口中的 ByteView 切片头(24)
+	cacheEntryOverhead = 312
+	// keyStatsOverhead keys 中每条统计除键内容以外的开销：KeyStats(40)、keyOrder 链表节点(40)、
+	// 装箱在链表中的键字符串头(16)、map 中的键和指针及桶的摊销(约32)
+	keyStatsOverhead = 128
 )
 
 // Group 是缓存命名空间 每个group都有一个名字
 type Group struct {
 	name      string               // 缓存空间的名字
-	getter    Getter               // 数据源获取数据
+	getter    GetterContext        // 数据源获取数据
 	mainCache cache            // 主缓存,用于存储本地节点作为主节点所拥有的数据
 	hotCache  cache            // hotCache 则是为了存储热门数据的缓存
 	peers     PeerPicker           // 用于获取远程节点请求客户端
+	peersMu   sync.RWMutex         // 保护 peers，RegisterPeers 可能与 Get 并发执行
 	loader    *singleflight.Group  // 避免被同一个key多次加载造成缓存击穿
+	keysMu    sync.Mutex           // 保护 keys 和 keyOrder
 	keys      map[string]*KeyStats // 根据键key获取对应key的统计信息
+	keyOrder  *list.List           // keys 按最近更新排列，队首为最近更新，超过 maxKeyStats 时淘汰队尾
+	maxKeyStats int                // keys 最多统计的key数量
+	events    eventBus             // 缓存生命周期事件的订阅者
+	sourceLatency histogram        // getter.Get 的耗时分布
+	counters      groupCounters    // 命中、加载和淘汰计数，见 Stats
+
+	middlewares []Middleware // Use 注册的加载中间件，按注册顺序由外到内
+	loadChain   LoadFunc     // 由 middlewares 包装 loadUncached 得到，为 nil 表示没有中间件
+
+	promotions []promotionPolicy // 热点数据提升策略，按注册顺序匹配
+	promoted   promotionLog      // 最近的热点提升记录，用于调整阈值
+	noCopy     bool              // getter 返回的切片可以直接保存，无需防御性拷贝
+	noSingleflight bool          // 加载时不经过 singleflight 合并并发请求
+	hotRatio   int               // hotCache 的容量为 mainCache 的 1/hotRatio，默认为 defaultHotCacheRatio
+	noHotCache bool              // 不使用 hotCache，热点key不再被提升
+	singleNode bool              // 单节点模式，不选择远程节点，也不统计远程key的访问频率
+	fetchKey   func(key string) string // 将 key 映射为跨 Group 共享的规范加载键，为 nil 表示不共享
+
+	hotKeyFactor float64 // 远程访问频率超过中位数的多少倍时视为热点key
+	promoteThreshold int64         // 每个 promoteWindow 内的远程访问次数达到该值时提升到 hotCache
+	promoteWindow    time.Duration // 计算远程访问频率的窗口长度
+
+	staleOnPeerError bool // 远程节点不可用时优先返回本地已过期的旧数据
+
+	readReplicas int // 主从读写分离时副本集合的大小(包括主节点)，小于2表示不开启
+
+	loadLock LoadLocker // 从数据源加载前获取的集群范围加载锁，为 nil 表示不协调
+
+	invalidation InvalidationBus // Delete 发布失效通知的总线，为 nil 时 Delete 只作用于本节点
+	unsubscribe  func()          // 停止监听失效通知，未开启 WithInvalidationBus 或订阅失败时为 nil
+	closeOnce    sync.Once       // 保证 Close 只调用一次 unsubscribe
+
+	minRefetch  time.Duration         // 同一个key两次从数据源加载的最小间隔，为0表示不限制
+	recentMu    sync.Mutex            // 保护 recentLoads
+	recentLoads map[string]recentLoad // 最近一次从数据源加载的结果
+
+	negativeTTL time.Duration // 数据源返回 ErrNotFound 后记住该结果的时长，为0表示不开启负缓存
+
+	experiments map[string]*experiment // 按分桶名索引的实验分桶，创建后只读，见 WithExperiment
 }
 
+// recentLoad 记录某个key最近一次从数据源加载的结果
+type recentLoad struct {
+	at    time.Time
+	value ByteView
+	err   error
+}
+
+// PromotionTarget 决定热点数据被提升时的去向
+type PromotionTarget int
+
+const (
+	PromoteLocal     PromotionTarget = iota // 只提升到本节点的 hotCache(默认)
+	PromoteBroadcast                        // 提升到本节点的 hotCache，并广播给所有节点
+)
+
+// promotionPolicy 为匹配 prefix 的 key 指定提升去向
+type promotionPolicy struct {
+	prefix string
+	target PromotionTarget
+}
+
+// maxRecentLoads recentLoads 超过该数量时清理已过最小间隔的记录
+const maxRecentLoads = 1024
+
 type AtomicInt int64 // 封装一个原子类，用于进行原子操作，保证并发安全.
 
 // Add 方法用于对 AtomicInt 中的值进行原子自增
@@ -42,40 +128,359 @@ func (i *AtomicInt) Get() int64 {
 type KeyStats struct { //Key的统计信息
 	firstGetTime time.Time //第一次请求的时间
 	remoteCnt    AtomicInt //请求的次数（利用atomic包封装的原子类）
+	elem         *list.Element // 在 keyOrder 中的位置
+}
+
+// GroupOption 用于在 NewGroup 时配置 Group 的可选行为
+type GroupOption func(*Group)
+
+// WithTTLJitter 设置缓存数据过期时间的随机延长上限：每条数据在 ttl 之外再存活 [0, max) 的随机时长，
+// 避免同时加载的大量数据同时过期。默认不延长，数据在 ttl 后准时过期
+func WithTTLJitter(max time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.lruOpts = append(g.mainCache.lruOpts, lru.WithJitter(max))
+		g.hotCache.lruOpts = append(g.hotCache.lruOpts, lru.WithJitter(max))
+	}
+}
+
+// WithHistory 让缓存在键被更新后，将旧值保留 retention 时长，可通过 GetPrevious 读取
+func WithHistory(retention time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.lruOpts = append(g.mainCache.lruOpts, lru.WithHistory(retention))
+		g.hotCache.lruOpts = append(g.hotCache.lruOpts, lru.WithHistory(retention))
+	}
+}
+
+// WithTTL 设置缓存数据的过期时间，过期后视为未命中，默认为 defaultTTL
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.ttl = ttl
+		g.hotCache.ttl = ttl
+	}
+}
+
+// WithPromotionPolicy 为以 prefix 开头的 key 指定成为热点后的提升去向，多条策略按注册顺序匹配，
+// 未匹配的 key 只提升到本地 hotCache。广播需要 RegisterPeers 注册的 PeerPicker 实现了 PeerBroadcaster
+func WithPromotionPolicy(prefix string, target PromotionTarget) GroupOption {
+	return func(g *Group) {
+		g.promotions = append(g.promotions, promotionPolicy{prefix: prefix, target: target})
+	}
+}
+
+// WithNoCopy 声明 getter 每次返回的都是新分配、之后不会再被任何人修改的切片，
+// getLocally 会直接保存该切片而不再做防御性拷贝，对较大的值可以省去一次分配和拷贝。
+// 如果 getter 复用或之后修改了返回的切片，缓存中的值也会随之改变，默认保持拷贝
+func WithNoCopy() GroupOption {
+	return func(g *Group) {
+		g.noCopy = true
+	}
+}
+
+// WithHotCacheRatio 将 hotCache 的容量设为 cacheBytes/ratio(至少为1)，默认为 1/defaultHotCacheRatio。
+// 热点集中的缓存组可以调小 ratio 获得更大的提升空间，ratio 不是正数时 panic
+func WithHotCacheRatio(ratio int) GroupOption {
+	if ratio <= 0 {
+		panic("non-positive hot cache ratio")
+	}
+	return func(g *Group) {
+		g.hotRatio = ratio
+	}
+}
+
+// WithoutHotCache 关闭 hotCache：Get 不再查找 hotCache，也不再统计远程key的访问频率和提升热点，
+// 适用于没有热点倾斜的缓存组，省下 hotCache 占用的内存
+func WithoutHotCache() GroupOption {
+	return func(g *Group) {
+		g.noHotCache = true
+	}
+}
+
+// WithSingleNode 开启单节点模式：RegisterPeers 被忽略，不再选择远程节点，也不统计远程key的访问频率，
+// Get 只经过 hotCache、mainCache 和本地数据源，省去访问 PeerPicker 的加锁和哈希开销。
+// 适用于只部署一个节点的服务，见 Server 的 WithSingleNodeCluster
+func WithSingleNode() GroupOption {
+	return func(g *Group) {
+		g.singleNode = true
+	}
+}
+
+// WithoutSingleflight 关闭 load 中的 singleflight，并发的未命中请求各自调用 getter 或远程节点。
+// 适用于 getter 非常廉价且幂等的场景(如查询内存中的 map)，此时合并请求的加锁开销比重复加载更大
+func WithoutSingleflight() GroupOption {
+	return func(g *Group) {
+		g.noSingleflight = true
+	}
+}
+
+// WithMaxLoadWaiters 限制同一个key进行中的加载上最多 n 个等待者，超过的请求立即返回 singleflight.ErrTooManyWaiters，
+// 调用方可以重试或降级，避免加载很慢时大量阻塞的 goroutine 占用内存。n 为0表示不限制(默认)
+func WithMaxLoadWaiters(n int) GroupOption {
+	return func(g *Group) {
+		g.loader.MaxWaiters = n
+	}
+}
+
+// WithSharedFetch 让 getter 的调用经过一个包级共享的 singleflight，以 fetchKey(key) 作为合并的键：
+// 不同 Group 中映射到同一个规范键的并发加载(例如以不同 key 读取同一份配置)只会访问数据源一次。
+// 规范键相同的 key 必须对应相同的底层数据
+func WithSharedFetch(fetchKey func(key string) string) GroupOption {
+	return func(g *Group) {
+		g.fetchKey = fetchKey
+	}
+}
+
+// WithMinRefetchInterval 限制同一个key从数据源加载的频率：interval 内的重复加载直接复用上一次的结果(包括错误)
+// 与 singleflight 只合并并发请求不同，它能在数据过期很快时保护数据源不被单个热点key反复击穿
+func WithMinRefetchInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.minRefetch = interval
+		g.recentLoads = make(map[string]recentLoad)
+	}
+}
+
+// WithCleanupInterval 在后台每隔 interval 主动清理 mainCache 和 hotCache 中已过期的数据，
+// 适用于键集合变化很快的长期运行进程，避免大量过期后不再被访问的数据一直占用内存。
+// 清理的数据计入 Stats.Evictions。默认不开启，过期数据只在被访问或容量不足时清理。
+// 不再使用 Group 时调用 Close 停止清理，否则后台的 goroutine 会一直运行
+func WithCleanupInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.cleanup = interval
+		g.hotCache.cleanup = interval
+	}
+}
+
+// WithNegativeTTL 开启负缓存：数据源返回 ErrNotFound(或包装它的错误)后，在 mainCache 中保存一个墓碑，
+// ttl 内对该key的 Get 直接返回 ErrCachedNotFound 而不再访问数据源，避免热点的不存在key反复击穿数据源。
+// 墓碑只保存在从数据源加载的节点上，写入该key的新值会覆盖它。默认不开启
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithSoftTTL 开启 stale-while-revalidate：mainCache 中的数据超过 softTTL 后仍会返回给调用方，
+// 同时在后台通过 Refresh 重新加载；超过 WithTTL 设置的硬过期时间后才视为未命中
+func WithSoftTTL(softTTL time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.softTTL = softTTL
+	}
+}
+
+// WithMaxStaleness 为返回陈旧数据设置硬上限：WithSoftTTL 的 stale-while-revalidate 和 WithStaleOnPeerError 的降级
+// 只返回写入后不超过 max 的数据，更旧的数据视为未命中，重新加载失败时返回错误而不是旧值。
+// 用于限制数据源或远程节点长时间故障时的正确性风险，默认不限制。
+// 上限只作用于 mainCache，hotCache 中的副本(包括热点广播收到的)按各自的过期时间淘汰，不受 max 约束
+func WithMaxStaleness(max time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.maxStale = max
+	}
+}
+
+// WithChecksum 为 mainCache 和 hotCache 中的每个值保存 CRC-32 校验和，并在每次读取时校验，
+// 发现值在内存中被破坏时移除该值并重新加载。每次读取都要遍历整个值，默认不开启
+func WithChecksum() GroupOption {
+	return func(g *Group) {
+		sum := lru.WithChecksum(func(v lru.Value) uint64 {
+			return uint64(crc32.ChecksumIEEE(v.(ByteView).b))
+		})
+		g.mainCache.lruOpts = append(g.mainCache.lruOpts, sum)
+		g.hotCache.lruOpts = append(g.hotCache.lruOpts, sum)
+	}
+}
+
+// WithMaxKeyStats 限制热点统计最多跟踪的key数量，默认为 defaultMaxKeyStats，
+// 超过后淘汰最久未更新的统计，使统计占用的内存不随远程访问的不同key数量无限增长
+func WithMaxKeyStats(n int) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.maxKeyStats = n
+		}
+	}
+}
+
+// WithPromotionThreshold 设置远程key被提升到 hotCache 的阈值：每个统计窗口(见 WithPromotionWindow)内
+// 平均的远程访问次数达到 n 时提升，默认为 defaultMaxMinuteRemoteQPS。流量小的部署可以调低使热点更早被提升，
+// 流量大的部署可以调高避免普通key挤占 hotCache。n 不是正数时忽略
+func WithPromotionThreshold(n int64) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.promoteThreshold = n
+		}
+	}
+}
+
+// WithPromotionWindow 设置计算远程访问频率的窗口长度，默认为 defaultPromotionWindow(1分钟)。
+// 频率为 key 的统计开始以来的远程访问次数除以经过的窗口数(四舍五入，至少为1)，
+// 因此在第一个窗口内访问次数一达到阈值就会被提升；统计在提升或被 WithMaxKeyStats 淘汰后重新开始。
+// window 不是正数时忽略
+func WithPromotionWindow(window time.Duration) GroupOption {
+	return func(g *Group) {
+		if window > 0 {
+			g.promoteWindow = window
+		}
+	}
+}
+
+// WithHotKeyFactor 设置 HotKeys 判定热点key的倍数，默认为 defaultHotKeyFactor
+func WithHotKeyFactor(factor float64) GroupOption {
+	return func(g *Group) {
+		g.hotKeyFactor = factor
+	}
+}
+
+// WithStaleOnPeerError 开启远程节点故障时的降级：mainCache 中过期的数据在 grace 时长内继续保留，
+// 从负责该key的远程节点获取失败时，如果本地还保留着旧数据(例如之前降级时从数据源加载的)就直接返回它，
+// 避免节点故障期间所有节点一起击穿数据源
+func WithStaleOnPeerError(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleOnPeerError = true
+		g.mainCache.lruOpts = append(g.mainCache.lruOpts, lru.WithGrace(grace))
+	}
+}
+
+// WithReadReplicas 开启主从读写分离：每个key由主节点加上 n-1 个只读副本负责，
+// 本地未命中时依次只查询各副本的缓存，全部未命中才经主节点加载，从而把热点读请求分散到副本上。
+// 当前节点属于副本集合时会保存从主节点取回的数据。需要 RegisterPeers 注册的 PeerPicker 实现了 ReplicaPicker
+func WithReadReplicas(n int) GroupOption {
+	return func(g *Group) {
+		g.readReplicas = n
+	}
+}
+
+// WithLoadLock 开启集群范围的加载协调：从数据源加载之前先获得 locker 中该key的加载锁，
+// 如果其他节点刚刚完成了加载就从该节点的缓存读取，使成员变化期间多个节点都认为自己负责该key时
+// 也只有一个节点访问数据源。每次加载都要访问 locker，适用于代价很高的加载。
+// 从其他节点读取需要 RegisterPeers 注册的 PeerPicker 实现了 PeerLookup
+func WithLoadLock(locker LoadLocker) GroupOption {
+	return func(g *Group) {
+		g.loadLock = locker
+	}
+}
+
+// WithInvalidationBus 通过 bus 保持各节点缓存一致：Delete 在删除本地数据后发布失效通知，
+// 每个节点收到 Group 的失效通知后在本地 Remove 该key，不依赖节点之间的直接连接
+func WithInvalidationBus(bus InvalidationBus) GroupOption {
+	return func(g *Group) {
+		g.invalidation = bus
+	}
 }
 
 var (
 	mu                 sync.RWMutex              // 读写锁
 	groups             = make(map[string]*Group) // 根据缓存组的名称，获取缓存组
+	sharedFetcher      = &singleflight.Group{}   // 跨 Group 合并相同底层加载的 singleflight
 )
 
 // 调用 RegisterPeers 函数，我们可以将实现了 PeerPicker 接口的对象注册到 Group 结构体中
+// Group 在 NewGroup 返回后即可通过 GetGroup 被其他 goroutine 使用，RegisterPeers 可以与 Get 并发调用：
+// 注册完成之前未命中缓存的 key 只会从本地数据源加载
 func (g *Group) RegisterPeers(peers PeerPicker) {
+	if g.singleNode {
+		log.Printf("[GeeCache] group %s is in single-node mode, ignoring RegisterPeers", g.name)
+		return
+	}
+	g.peersMu.Lock()
+	defer g.peersMu.Unlock()
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
 	}
 	g.peers = peers
 }
 
+// getPeers 返回 RegisterPeers 注册的 PeerPicker，尚未注册或处于单节点模式时为 nil
+func (g *Group) getPeers() PeerPicker {
+	if g.singleNode {
+		return nil
+	}
+	g.peersMu.RLock()
+	defer g.peersMu.RUnlock()
+	return g.peers
+}
+
 // NewGroup create a new instance of Group
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+// cacheBytes 为 mainCache 的容量上限，hotCache 的上限默认为其 1/defaultHotCacheRatio(至少为1)，见 WithHotCacheRatio；
+// cacheBytes 为0表示两者都不限制容量，为负数时 panic
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
-	mu.Lock()
-	defer mu.Unlock()
+	return NewGroupContext(name, cacheBytes, contextGetter{getter}, opts...)
+}
+
+// NewGroupContext 与 NewGroup 相同，但数据源接收 GetContext 调用方的 context，用于传递取消、截止时间和链路追踪信息
+func NewGroupContext(name string, cacheBytes int64, getter GetterContext, opts ...GroupOption) *Group {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	if cacheBytes < 0 {
+		panic("negative cacheBytes")
+	}
 	g := &Group{
 		name:      name,
 		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		hotCache:  cache{cacheBytes: cacheBytes / defaultHotCacheRatio},
+		mainCache: cache{cacheBytes: cacheBytes, ttl: defaultTTL},
+		hotCache:  cache{ttl: defaultTTL},
 		loader:    &singleflight.Group{},
 		keys:   make(map[string]*KeyStats),
+		keyOrder:    list.New(),
+		maxKeyStats: defaultMaxKeyStats,
+		hotKeyFactor: defaultHotKeyFactor,
+		promoteThreshold: defaultMaxMinuteRemoteQPS,
+		promoteWindow:    defaultPromotionWindow,
+		hotRatio:     defaultHotCacheRatio,
+	}
+	g.mainCache.onEvicted = func(key string, _ lru.Value) {
+		g.counters.evictions.Add(1)
+		g.emit(EventEvict, key, "main")
+	}
+	g.hotCache.onEvicted = func(key string, _ lru.Value) {
+		g.counters.evictions.Add(1)
+		g.emit(EventEvict, key, "hot")
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.hotCache.cacheBytes = cacheBytes / int64(g.hotRatio)
+	if cacheBytes > 0 && g.hotCache.cacheBytes == 0 {
+		// 容量很小时不能让 hotCache 变为不限制
+		g.hotCache.cacheBytes = 1
 	}
+	g.initExperiments()
+	if g.invalidation != nil {
+		// 订阅可能要访问etcd，不能持有全局的 mu
+		cancel, err := g.invalidation.Subscribe(name, func(key string) { g.Remove(key) })
+		if err != nil {
+			log.Printf("[GeeCache] Failed to subscribe invalidations of %s: %v", name, err)
+		}
+		g.unsubscribe = cancel
+	}
+	mu.Lock()
+	old := groups[name]
 	groups[name] = g
+	mu.Unlock()
+	if old != nil {
+		// 同名的旧 Group 不再能通过 GetGroup 访问，停止它的后台任务
+		old.Close()
+	}
 	return g
 }
 
+// Close 停止 Group 的后台任务：WithCleanupInterval 开启的清理(包括实验分桶)和失效通知的监听。
+// 之后 Group 仍可读写，过期数据只在被访问或容量不足时清理。重复调用是安全的，
+// 用同一个名字再次 NewGroup 时会自动关闭被替换的 Group
+func (g *Group) Close() {
+	g.mainCache.close()
+	g.hotCache.close()
+	for _, e := range g.experiments {
+		e.cache.close()
+	}
+	g.closeOnce.Do(func() {
+		if g.unsubscribe != nil {
+			g.unsubscribe()
+		}
+	})
+}
+
 // GetGroup 根据name获取对应的Group
 func GetGroup(name string) *Group {
 	mu.RLock()
@@ -84,108 +489,916 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// Name 返回缓存组的名称
+func (g *Group) Name() string {
+	return g.name
+}
+
 // Get 函数用于获取缓存数据，获取顺序为：热点缓存、主缓存、数据源
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同，未命中缓存时 ctx 会传递给远程节点和数据源(见 NewGroupContext)。
+// 同一个key的并发加载会合并为一次，使用最先发起加载的调用方 ctx 中的值，但不继承它的取消和截止时间；
+// 任何调用方(包括最先发起的)的 ctx 被取消时只是不再等待并返回 ctx.Err()，不会中断共享的加载。
+// ctx 携带已注册的实验分桶名时改为使用该分桶的缓存，见 WithExperiment
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
-	if v, ok := g.hotCache.get(key); ok {
+	if e := g.experimentFor(ctx); e != nil {
+		return g.getExperiment(ctx, e, key)
+	}
+	if v, hit, err := g.lookupLocal(key); hit {
+		return v, err
+	}
+	// 缓存不在就用回调函数查，然后加载到缓存
+	g.counters.misses.Add(1)
+	return g.load(ctx, key)
+}
+
+// lookupLocal 依次查找 hotCache、mainCache 和负缓存，命中负缓存时返回 ErrCachedNotFound，都未命中时 hit 为 false
+func (g *Group) lookupLocal(key string) (value ByteView, hit bool, err error) {
+	if v, ok := g.lookupCache(key); ok {
+		return v, true, nil
+	}
+	if g.cachedNotFound(key) {
+		return ByteView{}, true, ErrCachedNotFound
+	}
+	return ByteView{}, false, nil
+}
+
+// GetEncoded 与 Get 相同，但缓存中保存的值使用了 accept 中的某个内容编码(见 ContentEncoder)时，
+// 直接返回保存的编码后的字节和该编码，省去解码再编码，适用于将值原样转发给接受该编码的客户端。
+// 其他情况返回原始值，encoding 为空
+func (g *Group) GetEncoded(key string, accept ...string) (value ByteView, encoding string, err error) {
+	if key != "" && len(accept) > 0 {
+		if !g.noHotCache {
+			if v, enc, ok := g.hotCache.getEncoded(key, accept); ok {
+				g.counters.hits.Add(1)
+				g.counters.hotHits.Add(1)
+				g.emit(EventHit, key, "hot")
+				return v, enc, nil
+			}
+		}
+		if v, enc, ok := g.mainCache.getEncoded(key, accept); ok {
+			g.counters.hits.Add(1)
+			g.emit(EventHit, key, "main")
+			return v, enc, nil
+		}
+	}
+	value, err = g.Get(key)
+	return value, "", err
+}
+
+// lookupCache 依次查找 hotCache 和 mainCache 并记录命中，mainCache 中的数据已陈旧时在后台刷新
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.getHot(key); ok {
 		log.Println("[GeeCache] hit hotCache")
-		return v, nil
+		g.counters.hits.Add(1)
+		g.counters.hotHits.Add(1)
+		g.emit(EventHit, key, "hot")
+		return v, true
 	}
 	// 从maincache中查找缓存
-	if v, ok := g.mainCache.get(key); ok {
+	if v, stale, ok := g.mainCache.lookup(key); ok {
 		log.Println("[GeeCache] hit")
-		return v, nil
+		g.counters.hits.Add(1)
+		g.emit(EventHit, key, "main")
+		if stale {
+			// 数据已陈旧，先返回旧值，再在后台刷新
+			g.submitBackground(func() {
+				if _, err := g.Refresh(key); err != nil {
+					log.Println("[GeeCache] Failed to refresh stale key", key, err)
+				}
+			})
+		}
+		return v, true
 	}
-	// 缓存不在就用回调函数查，然后加载到缓存
-	return g.load(key)
+	return ByteView{}, false
+}
+
+// cachedNotFound 返回 key 是否命中了负缓存，命中时记为一次缓存命中
+func (g *Group) cachedNotFound(key string) bool {
+	if g.negativeTTL <= 0 || !g.mainCache.tombstone(key) {
+		return false
+	}
+	g.counters.hits.Add(1)
+	g.emit(EventHit, key, "negative")
+	return true
+}
+
+// GetBatch 批量获取多个 key，每个 key 与 Get 一样先查找缓存和负缓存，未命中的 key 会并发加载
+// 加载与 Get 共用同一个 singleflight，因此并发的批量请求(以及 Get)中重叠的 key 只会加载一次
+// 开启 WithMinRefetchInterval 时，未缓存且间隔内已确认加载失败(如不存在)的 key 直接返回上一次的错误，不会再访问远程节点和数据源
+// 返回所有成功获取的值，获取失败的 key 的错误会被聚合后返回
+func (g *Group) GetBatch(keys []string) (map[string]ByteView, error) {
+	values, errs := g.getEach(context.Background(), keys)
+	return values, joinKeyErrors(keys, errs)
+}
+
+// maxBatchLoads 是一次批量读取中同时进行的 Get 的上限
+const maxBatchLoads = 32
+
+// batchLoad 是 getEach 中一个需要加载的 key 及其加载方式
+type batchLoad struct {
+	key string
+	get func() (ByteView, error)
+}
+
+// getEach 对每个不重复的 key 执行 Get 的查找和加载，未命中缓存的 key 并发加载，同时进行的加载不超过 maxBatchLoads 个，
+// 分别返回获取成功的值和每个失败的 key 的错误。先在当前 goroutine 中处理不需要加载的 key，再启动加载，
+// 加载开始后 values 和 errs 只在持有 mu 时写入
+func (g *Group) getEach(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errs   = make(map[string]error)
+		values = make(map[string]ByteView, len(keys))
+		seen   = make(map[string]bool, len(keys))
+		loads  []batchLoad
+	)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" || g.experimentFor(ctx) != nil {
+			// 空key的错误和实验分桶由 GetContext 处理
+			loads = append(loads, batchLoad{key, func() (ByteView, error) { return g.GetContext(ctx, key) }})
+			continue
+		}
+		if v, hit, err := g.lookupLocal(key); hit {
+			if err != nil {
+				errs[key] = err
+			} else {
+				values[key] = v
+			}
+			continue
+		}
+		if err, ok := g.recentError(key); ok {
+			errs[key] = err
+			continue
+		}
+		g.counters.misses.Add(1)
+		loads = append(loads, batchLoad{key, func() (ByteView, error) { return g.load(ctx, key) }})
+	}
+
+	pool := newWorkerPool(maxBatchLoads)
+	for _, l := range loads {
+		key := l.key
+		wg.Add(1)
+		pool.submit(func() {
+			defer wg.Done()
+			v, err := l.get()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			values[key] = v
+		})
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// joinKeyErrors 按 keys 中的顺序聚合每个 key 的错误
+func joinKeyErrors(keys []string, errs map[string]error) error {
+	var joined []error
+	for _, key := range keys {
+		if err, ok := errs[key]; ok {
+			joined = append(joined, fmt.Errorf("%s: %w", key, err))
+			delete(errs, key)
+		}
+	}
+	return errors.Join(joined...)
+}
+
+// GetMulti 批量获取多个 key：已缓存的 key 直接返回，其余 key 按 PickPeer 选出的节点分组，
+// 每个远程节点只发送一次批量请求(节点需实现 PeerMultiGetter)，本节点负责的 key 与 GetBatch 一样并发加载。
+// 批量请求失败时，该节点的 key 退回逐个 Get。返回所有成功获取的值，失败的 key 的错误会被聚合后返回
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	remote := make(map[PeerMultiGetter][]string)
+	var local []string
+	peers := g.getPeers()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			errs[key] = fmt.Errorf("key is required")
+			continue
+		}
+		if v, ok := g.lookupCache(key); ok {
+			values[key] = v
+			continue
+		}
+		if g.cachedNotFound(key) {
+			errs[key] = ErrCachedNotFound
+			continue
+		}
+		if peers != nil {
+			if peer, ok := peers.PickPeer(key); ok && !isNilPeer(peer) {
+				if mg, ok := peer.(PeerMultiGetter); ok {
+					remote[mg] = append(remote[mg], key)
+					continue
+				}
+			}
+		}
+		local = append(local, key)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		pool = newWorkerPool(maxFanout)
+	)
+	for peer, peerKeys := range remote {
+		wg.Add(1)
+		pool.submit(func() {
+			defer wg.Done()
+			got, failed := g.getMultiFromPeer(peer, peerKeys)
+			mu.Lock()
+			defer mu.Unlock()
+			for key, v := range got {
+				values[key] = v
+			}
+			for key, err := range failed {
+				errs[key] = err
+			}
+		})
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		got, failed := g.getEach(context.Background(), local)
+		mu.Lock()
+		defer mu.Unlock()
+		for key, v := range got {
+			values[key] = v
+		}
+		for key, err := range failed {
+			errs[key] = err
+		}
+	}()
+	wg.Wait()
+	return values, joinKeyErrors(keys, errs)
+}
+
+// getMultiFromPeer 通过一次批量请求从远程节点获取 keys，请求失败时退回逐个 Get
+func (g *Group) getMultiFromPeer(peer PeerMultiGetter, keys []string) (map[string]ByteView, map[string]error) {
+	res := &pb.MultiResponse{}
+	if err := peer.GetMulti(&pb.MultiRequest{Group: g.name, Keys: keys}, res); err != nil {
+		log.Println("[GeeCache] Failed to get multi from peer", err)
+		return g.getEach(context.Background(), keys)
+	}
+	g.counters.misses.Add(int64(len(keys)))
+	values := make(map[string]ByteView, len(res.GetEntries()))
+	for _, e := range res.GetEntries() {
+		value := ByteView{b: e.GetValue()}
+		values[e.GetKey()] = value
+		g.counters.peerLoads.Add(1)
+		g.emit(EventLoad, e.GetKey(), "peer")
+		g.updateKeyStats(e.GetKey(), value)
+	}
+	errs := make(map[string]error, len(res.GetErrors())+len(res.GetNotFound()))
+	for key, msg := range res.GetErrors() {
+		errs[key] = errors.New(msg)
+	}
+	for _, key := range res.GetNotFound() {
+		errs[key] = ErrNotFound
+	}
+	return values, errs
+}
+
+// GetIfChanged 是条件读取：当前值的版本号与 knownVersion 相同时返回 changed=false 和空的 ByteView，
+// 否则返回新值和 changed=true，版本号见 ByteView.Version
+func (g *Group) GetIfChanged(key string, knownVersion uint64) (value ByteView, changed bool, err error) {
+	value, err = g.Get(key)
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	if value.Version() == knownVersion {
+		return ByteView{}, false, nil
+	}
+	return value, true, nil
+}
+
+// Remove 从 mainCache 和 hotCache 中删除 key，并清除它的访问统计和最近加载记录，
+// 之后的 Get 会重新加载。key 不存在时不做任何事，可以重复调用。
+// Remove 只作用于本节点，其他节点缓存的副本(如被广播的热点数据)仍会保留到过期，需要同步删除时使用 Delete
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.mainCache.remove(key) {
+		g.emit(EventDelete, key, "main")
+	}
+	if g.hotCache.remove(key) {
+		g.emit(EventDelete, key, "hot")
+	}
+	g.removeFromExperiments(key)
+	g.keysMu.Lock()
+	if stat, ok := g.keys[key]; ok {
+		delete(g.keys, key)
+		g.keyOrder.Remove(stat.elem)
+	}
+	g.keysMu.Unlock()
+	if g.minRefetch > 0 {
+		g.recentMu.Lock()
+		delete(g.recentLoads, key)
+		g.recentMu.Unlock()
+	}
+	return nil
+}
+
+// Delete 从本节点删除 key，开启 WithInvalidationBus 时还会发布失效通知，使其他节点也删除该key，
+// 发布最多等待 publishTimeout。未开启时与 Remove 相同
+func (g *Group) Delete(key string) error {
+	if err := g.Remove(key); err != nil {
+		return err
+	}
+	if g.invalidation == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return g.invalidation.Publish(ctx, g.name, key)
+}
+
+// Set 写入 key 的值，ttl 为过期时间，为0时使用 WithTTL 设置的默认值。
+// key 由远程节点负责时写入会被转发给该节点，成功后本节点的 hotCache 也保存一份，
+// 否则保存在本节点的 mainCache 中。其他节点 hotCache 中的旧值会保留到过期
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	view := ByteView{b: cloneBytes(value)}
+	if peers := g.getPeers(); peers != nil {
+		if peer, ok := peers.PickPeer(key); ok && !isNilPeer(peer) {
+			setter, ok := peer.(PeerSetter)
+			if !ok {
+				return fmt.Errorf("peer %T does not support Set", peer)
+			}
+			req := &pb.SetRequest{Group: g.name, Key: key, Value: view.b, TtlMs: ttl.Milliseconds()}
+			if err := setter.Set(req, &pb.SetResponse{}); err != nil {
+				return err
+			}
+			if !g.noHotCache {
+				g.hotCache.addWithTTL(key, view, ttl)
+			}
+			return nil
+		}
+	}
+	g.setLocally(key, view, ttl)
+	return nil
+}
+
+// setLocally 将 value 保存到 mainCache 中，删除 hotCache 中的旧值并更新分桶缓存
+func (g *Group) setLocally(key string, value ByteView, ttl time.Duration) {
+	g.mainCache.addWithTTL(key, value, ttl)
+	g.hotCache.remove(key)
+	g.updateExperiments(key, value)
+}
+
+// GetRange 返回 key 对应的值中 [start, end) 范围内的字节，不会拷贝整个值。
+// 值需要完整地加载到缓存中，范围越界时返回包装了 ErrInvalidRange 的错误
+func (g *Group) GetRange(key string, start, end int) (ByteView, error) {
+	value, err := g.Get(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return value.Slice(start, end)
+}
+
+// GetWithTTL 与 Get 相同，额外返回数据在本地缓存中的剩余有效期；
+// 数据由远程节点返回且未缓存在本地时剩余有效期为0
+func (g *Group) GetWithTTL(key string) (ByteView, time.Duration, error) {
+	value, err := g.Get(key)
+	if err != nil {
+		return ByteView{}, 0, err
+	}
+	expire, _, ok := g.expiry(key)
+	if !ok {
+		return value, 0, nil
+	}
+	return value, time.Until(expire), nil
+}
+
+// expiry 返回 key 在本地缓存中的过期时间和最近一次写入的时间，hotCache 优先
+func (g *Group) expiry(key string) (expire, updated time.Time, ok bool) {
+	if expire, updated, ok = g.hotCache.expiry(key); ok {
+		return
+	}
+	return g.mainCache.expiry(key)
+}
+
+// Refresh 绕过缓存重新加载 key，并覆盖已缓存的值后返回新值
+// 覆盖前旧值仍可被 Get 读到，不会像先删除再 Get 那样出现缺失窗口。
+// 加载与 Get 未命中时相同：转发给负责该key的节点，经过 Use 注册的中间件、最小加载间隔和加载锁，
+// 同一个 key 的并发 Refresh 会通过 singleflight 合并为一次加载，但不会与 Get 的加载合并
+func (g *Group) Refresh(key string) (ByteView, error) {
+	return g.refresh(context.Background(), key)
+}
+
+// refresh 是 Refresh 的实现，远程节点转发来的 Refresh 请求同样经过这里
+func (g *Group) refresh(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	value, err := g.load(withRefresh(ctx), key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	// 从远程节点加载的值不会写入本地缓存，本地已有的副本和已被提升为热点的数据同样需要更新
+	g.mainCache.replace(key, value)
+	if _, ok := g.getHot(key); ok {
+		g.populateHotCache(key, value)
+	}
+	return value, nil
+}
+
+// refreshKey 标记由 Refresh 发起的加载
+type refreshKey struct{}
+
+// withRefresh 返回标记为 Refresh 的 ctx，加载时不使用远程节点的过期数据和只读副本的缓存
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
 }
 
+// isRefresh 返回 ctx 是否由 Refresh 发起
+func isRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
+
+// GetPrevious 返回键最近一次被更新前的旧值，需要通过 WithHistory 开启
+func (g *Group) GetPrevious(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.getPrevious(key); ok {
+		return v, true
+	}
+	return g.hotCache.getPrevious(key)
+}
+
+// criticalFlightPrefix 是 PriorityCritical 请求在 loader 中合并时使用的键前缀
+const criticalFlightPrefix = "\x00critical\x00"
+
+// refreshFlightPrefix 是 Refresh 在 loader 中合并时使用的键前缀，Refresh 不复用已经开始的 Get 的加载
+const refreshFlightPrefix = "\x00refresh\x00"
+
 // load 方法的逻辑是首先尝试从远程节点获取数据，如果失败或者没有配置远程节点，则回退到本地获取
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	if err := g.checkTierCycle(ctx); err != nil {
+		return ByteView{}, err
+	}
+	fetch := func(ctx context.Context) (ByteView, error) {
+		if g.loadChain != nil {
+			return g.loadChain(ctx, key)
+		}
+		return g.loadUncached(ctx, key)
+	}
+	if g.noSingleflight {
+		return fetch(ctx)
+	}
 	// 每个key只被获取一次（本地或远程）
-	// 无论有多少并发调用
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err := g.getFromPeer(peer, key); err == nil {
+	// 无论有多少并发调用。限流中间件按发起者的优先级放行，不同优先级的请求分开合并，
+	// 避免关键请求排在被限流的普通加载之后
+	flight := key
+	if PriorityFromContext(ctx) >= PriorityCritical {
+		flight = criticalFlightPrefix + key
+	}
+	if isRefresh(ctx) {
+		flight = refreshFlightPrefix + flight
+	}
+	ch := g.loader.DoChan(flight, func() (interface{}, error) {
+		// 共享的加载不随发起者的取消而失败，否则所有等待者都会得到发起者的 ctx 错误
+		return fetch(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return ByteView{}, res.Err
+		}
+		return res.Val.(ByteView), nil
+	case <-ctx.Done():
+		return ByteView{}, ctx.Err()
+	}
+}
+
+// loadUncached 从远程节点或本地数据源加载 key，是 Use 注册的中间件链的最内层
+func (g *Group) loadUncached(ctx context.Context, key string) (ByteView, error) {
+	peers := g.getPeers()
+	if rp, ok := peers.(ReplicaPicker); ok && g.readReplicas > 1 && !isRefresh(ctx) {
+		return g.loadFromReplicas(ctx, rp, key)
+	}
+	if peers != nil {
+		if peer, ok := peers.PickPeer(key); ok {
+			if isNilPeer(peer) {
+				// PeerPicker 实现有误，退回本地加载而不是 panic
+				log.Printf("[GeeCache] PickPeer returned a nil PeerGetter for key %s, loading locally", key)
+			} else if value, err := g.getFromPeer(ctx, peer, key); err == nil {
+				return value, nil
+			} else if errors.Is(err, ErrGroupNotFound) {
+				// 负责该key的节点没有这个 Group，说明集群中各节点的 Group 配置不一致，
+				// 本地的数据源和旧数据都不一定可信，交给调用方处理
+				return ByteView{}, err
+			} else {
+				log.Println("[GeeCache] Failed to get from peer", err)
+				if value, ok := g.staleOnError(key); ok && !isRefresh(ctx) {
 					return value, nil
 				}
-				log.Println("[GeeCache] Failed to get from peer", err)
 			}
 		}
-		return g.getLocally(key) //从本地获取缓存数据
-	})
-
-	if err == nil {
-		return viewi.(ByteView), nil
 	}
-	return
+	return g.loadLocally(ctx, key) //从本地获取缓存数据
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
-		Group: g.name,
-		Key:   key,
+		Group:   g.name,
+		Key:     key,
+		Refresh: isRefresh(ctx),
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := peerGet(ctx, peer, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
 
 	value := ByteView{b: res.Value}
+	g.counters.peerLoads.Add(1)
+	g.emit(EventLoad, key, "peer")
 
 	g.updateKeyStats(key, value)
 
 	return value, nil
 }
 
+// loadFromReplicas 依次查询只读副本的缓存，全部未命中时经主节点加载
+func (g *Group) loadFromReplicas(ctx context.Context, rp ReplicaPicker, key string) (ByteView, error) {
+	set := rp.GetWithReplicas(key, g.readReplicas)
+	for _, replica := range set.Replicas {
+		if isNilPeer(replica) {
+			continue
+		}
+		if value, err := g.getFromReplica(ctx, replica, key); err == nil {
+			return value, nil
+		}
+	}
+	if isNilPeer(set.Primary) {
+		return g.loadLocally(ctx, key)
+	}
+	value, err := g.getFromPeer(ctx, set.Primary, key)
+	if errors.Is(err, ErrGroupNotFound) {
+		return ByteView{}, err
+	}
+	if err != nil {
+		log.Println("[GeeCache] Failed to get from primary", err)
+		if value, ok := g.staleOnError(key); ok {
+			return value, nil
+		}
+		return g.loadLocally(ctx, key)
+	}
+	if set.Self {
+		// 当前节点是该key的副本，保存主节点返回的数据以分担后续的读请求
+		g.populateCache(key, value)
+	}
+	return value, nil
+}
+
+// staleOnError 在远程节点获取失败后查找本地保留的过期数据
+func (g *Group) staleOnError(key string) (ByteView, bool) {
+	if !g.staleOnPeerError {
+		return ByteView{}, false
+	}
+	value, ok := g.mainCache.getExpired(key)
+	if ok {
+		log.Println("[GeeCache] serve stale copy of", key)
+		g.emit(EventHit, key, "stale")
+	}
+	return value, ok
+}
+
+// getFromReplica 只查询副本的缓存，副本未命中时返回错误而不会触发加载
+func (g *Group) getFromReplica(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
+	req := &pb.Request{
+		Group:     g.name,
+		Key:       key,
+		CacheOnly: true,
+	}
+	res := &pb.Response{}
+	if err := peerGet(ctx, peer, req, res); err != nil {
+		return ByteView{}, err
+	}
+	g.counters.peerLoads.Add(1)
+	g.emit(EventLoad, key, "replica")
+	return ByteView{b: res.Value}, nil
+}
+
+// getHot 从 hotCache 中查找 key，关闭 hotCache 时直接返回未命中
+func (g *Group) getHot(key string) (ByteView, bool) {
+	if g.noHotCache {
+		return ByteView{}, false
+	}
+	return g.hotCache.get(key)
+}
+
+// getCached 只从 hotCache 和 mainCache 中查找，不会触发加载
+func (g *Group) getCached(key string) (ByteView, bool) {
+	if v, ok := g.getHot(key); ok {
+		return v, true
+	}
+	return g.mainCache.get(key)
+}
+
 func (g *Group) updateKeyStats(key string, value ByteView) {
-	// mu.Lock()
-	// defer mu.Unlock()
+	if g.noHotCache || g.singleNode {
+		return // 不使用 hotCache 或单节点时无需统计
+	}
 	// 更新键的访问统计信息
-	if stat, ok := g.keys[key]; ok {
+	g.keysMu.Lock()
+	stat, ok := g.keys[key]
+	if ok {
+		g.keyOrder.MoveToFront(stat.elem)
+	}
+	g.keysMu.Unlock()
+	if ok {
 		stat.remoteCnt.Add(1)
-		interval := float64(time.Now().Unix()-stat.firstGetTime.Unix()) / 60
+		// 统计开始以来经过的窗口数，至少为1
+		interval := float64(time.Since(stat.firstGetTime)) / float64(g.promoteWindow)
 		qps := stat.remoteCnt.Get() / int64(math.Max(1, math.Round(interval)))
 		// 如果 QPS 超过阈值，将数据添加到热点缓存
-		if qps >= defaultMaxMinuteRemoteQPS {
+		if qps >= g.promoteThreshold {
 			g.populateHotCache(key, value)
-			mu.Lock()
-			delete(g.keys, key)
-			mu.Unlock()
+			target := g.promotionTarget(key)
+			g.promoted.record(PromotionEvent{Key: key, QPS: qps, Target: target, Time: time.Now()})
+			if target == PromoteBroadcast {
+				g.broadcast(key, value)
+			}
+			g.keysMu.Lock()
+			if g.keys[key] == stat {
+				delete(g.keys, key)
+				g.keyOrder.Remove(stat.elem)
+			}
+			g.keysMu.Unlock()
 		}
 	} else {
 		// 首次访问，初始化统计信息
-		g.keys[key] = &KeyStats{
+		g.keysMu.Lock()
+		defer g.keysMu.Unlock()
+		if _, ok := g.keys[key]; ok {
+			return
+		}
+		stat = &KeyStats{
 			firstGetTime: time.Now(),
 			remoteCnt:    1,
 		}
+		stat.elem = g.keyOrder.PushFront(key)
+		g.keys[key] = stat
+		// 超过上限时淘汰最久未更新的统计
+		for g.keyOrder.Len() > g.maxKeyStats {
+			oldest := g.keyOrder.Back()
+			g.keyOrder.Remove(oldest)
+			delete(g.keys, oldest.Value.(string))
+		}
+	}
+}
+
+
+// promotionTarget 返回 key 成为热点后的提升去向
+func (g *Group) promotionTarget(key string) PromotionTarget {
+	for _, p := range g.promotions {
+		if strings.HasPrefix(key, p.prefix) {
+			return p.target
+		}
+	}
+	return PromoteLocal
+}
+
+// broadcast 在后台将热点数据推送给所有节点，PeerPicker 不支持广播时只记录日志
+func (g *Group) broadcast(key string, value ByteView) {
+	b, ok := g.getPeers().(PeerBroadcaster)
+	if !ok {
+		log.Println("[GeeCache] peers do not support broadcast, keep hot key local:", key)
+		return
+	}
+	g.submitBackground(func() {
+		if err := b.Broadcast(&pb.Request{Group: g.name, Key: key}, value.ByteSlice()); err != nil {
+			log.Println("[GeeCache] Failed to broadcast hot key", key, err)
+		}
+	})
+}
+
+// submitBackground 在后台协程池中执行 fn，池已满时丢弃 fn 并计入 Stats.DroppedBackgroundTasks
+func (g *Group) submitBackground(fn func()) {
+	if !background.Load().trySubmit(fn) {
+		g.counters.dropped.Add(1)
+	}
+}
+
+// loadLocally 从数据源加载数据，开启 WithMinRefetchInterval 时间隔内复用上一次的结果
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	if g.minRefetch <= 0 {
+		return g.getLocallyLocked(ctx, key)
+	}
+	g.recentMu.Lock()
+	if r, ok := g.recentLoads[key]; ok && time.Since(r.at) < g.minRefetch {
+		g.recentMu.Unlock()
+		if r.err == nil {
+			g.populateCache(key, r.value)
+		}
+		return r.value, r.err
+	}
+	g.recentMu.Unlock()
+
+	value, err := g.getLocallyLocked(ctx, key)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// 调用方的 ctx 错误不代表数据源的状态，不在间隔内复用
+		return value, err
+	}
+
+	g.recentMu.Lock()
+	defer g.recentMu.Unlock()
+	if len(g.recentLoads) >= maxRecentLoads {
+		for k, r := range g.recentLoads {
+			if time.Since(r.at) >= g.minRefetch {
+				delete(g.recentLoads, k)
+			}
+		}
+	}
+	g.recentLoads[key] = recentLoad{at: time.Now(), value: value, err: err}
+	return value, err
+}
+
+// recentError 返回 key 在最小加载间隔内最近一次从数据源加载失败的错误
+func (g *Group) recentError(key string) (error, bool) {
+	if g.minRefetch <= 0 {
+		return nil, false
+	}
+	g.recentMu.Lock()
+	defer g.recentMu.Unlock()
+	r, ok := g.recentLoads[key]
+	if !ok || r.err == nil || time.Since(r.at) >= g.minRefetch {
+		return nil, false
+	}
+	return r.err, true
+}
+
+// getLocallyLocked 开启 WithLoadLock 时在加载锁的保护下从数据源获取数据，
+// 获得锁后如果其他节点刚刚完成了加载，则从该节点的缓存读取而不再访问数据源
+func (g *Group) getLocallyLocked(ctx context.Context, key string) (ByteView, error) {
+	if g.loadLock == nil {
+		return g.getLocally(ctx, key)
+	}
+	loadedBy, unlock, err := g.loadLock.LockLoad(ctx, g.name, key)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ByteView{}, ctx.Err()
+		}
+		log.Println("[GeeCache] Failed to acquire load lock, loading without it:", err)
+		return g.getLocally(ctx, key)
+	}
+	if value, ok := g.getFromLoader(ctx, loadedBy, key); ok {
+		unlock(false)
+		return value, nil
 	}
+	value, err := g.getLocally(ctx, key)
+	unlock(err == nil)
+	return value, err
 }
 
+// getFromLoader 只查询刚完成 key 加载的节点 addr 的缓存，成功时保存到mainCache中
+func (g *Group) getFromLoader(ctx context.Context, addr, key string) (ByteView, bool) {
+	if addr == "" {
+		return ByteView{}, false
+	}
+	lookup, ok := g.getPeers().(PeerLookup)
+	if !ok {
+		return ByteView{}, false
+	}
+	peer, ok := lookup.LookupPeer(addr)
+	if !ok || isNilPeer(peer) {
+		return ByteView{}, false
+	}
+	res := &pb.Response{}
+	if err := peerGet(ctx, peer, &pb.Request{Group: g.name, Key: key, CacheOnly: true}, res); err != nil {
+		log.Println("[GeeCache] Failed to get from loader", addr, err)
+		return ByteView{}, false
+	}
+	value := ByteView{b: res.Value}
+	g.populateCache(key, value)
+	g.counters.peerLoads.Add(1)
+	g.emit(EventLoad, key, "peer")
+	return value, true
+}
 
 // getLocally 从数据源获取数据，然后将数据添加到mainCache中
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	bytes, err := g.fetch(g.withTier(ctx), key)
 	if err != nil {
+		if g.negativeTTL > 0 && errors.Is(err, ErrNotFound) {
+			g.mainCache.addWithTTL(key, ByteView{miss: true}, g.negativeTTL)
+		}
 		return ByteView{}, err
-
 	}
-	value := ByteView{b: cloneBytes(bytes)}
+	if !g.noCopy {
+		bytes = cloneBytes(bytes)
+	}
+	value := ByteView{b: bytes}
 	g.populateCache(key, value)
+	g.counters.localLoads.Add(1)
+	g.emit(EventLoad, key, "local")
 	return value, nil
 }
 
-// populateCache 将数据添加到mainCache中
+// fetch 调用 getter 从数据源获取数据，开启 WithSharedFetch 时与其他 Group 合并相同的底层加载
+func (g *Group) fetch(ctx context.Context, key string) ([]byte, error) {
+	if g.fetchKey == nil {
+		return g.timedGet(ctx, key)
+	}
+	v, err := sharedFetcher.Do(g.fetchKey(key), func() (interface{}, error) {
+		return g.timedGet(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// timedGet 调用 getter 并记录耗时
+func (g *Group) timedGet(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	defer func() { g.sourceLatency.observe(time.Since(start)) }()
+	return g.getter.Get(ctx, key)
+}
+
+// populateCache 将数据添加到mainCache中，并更新分桶缓存中已有的旧值
 func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
+	g.updateExperiments(key, value)
 }
 
-// populateHotCache 将数据添加到hotCache中
+// ApproxMemoryBytes 估算 Group 占用的内存：在 CacheBytes 统计的键和值长度之上，
+// 加上两个缓存中每个节点的链表、map 和结构体开销，以及热点统计中每个key的开销，用于容量规划。
+// 结果只是近似值，不包括 singleflight 中进行中的加载等临时占用
+func (g *Group) ApproxMemoryBytes() int64 {
+	mainBytes, hotBytes := g.CacheBytes()
+	total := mainBytes + hotBytes + int64(g.mainCache.len()+g.hotCache.len())*cacheEntryOverhead
+	g.keysMu.Lock()
+	for key := range g.keys {
+		total += int64(len(key)) + keyStatsOverhead
+	}
+	g.keysMu.Unlock()
+	return total
+}
+
+// LoadWaiters 返回 key 上进行中的加载当前的等待者数量(不含发起加载的请求)，没有进行中的加载时返回0。
+// PriorityCritical 请求单独合并，它们的等待者也计算在内
+func (g *Group) LoadWaiters(key string) int {
+	return g.loader.Waiters(key) + g.loader.Waiters(criticalFlightPrefix+key)
+}
+
+// Keys 返回 hotCache 和 mainCache 中所有未过期的键，用于调试和导出。hotCache 的键在前，
+// 两部分各自按最近使用到最久未使用的顺序排列，同时存在于两个缓存中的键只出现一次。
+// 两个缓存分别加锁遍历，因此只在各自内部是一致的快照
+func (g *Group) Keys() []string {
+	keys := g.hotCache.keys()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range g.mainCache.keys() {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// CacheBytes 分别返回 mainCache 和 hotCache 已占用的容量
+// 两者是相互独立的预算：mainCache 的上限为 cacheBytes，hotCache 默认为 cacheBytes/defaultHotCacheRatio(见 WithHotCacheRatio)。
+// 同一个值可能同时存在于两个缓存中(共享底层字节)，此时会在两边各计一次，总占用为两者之和
+func (g *Group) CacheBytes() (mainBytes, hotBytes int64) {
+	return g.mainCache.bytes(), g.hotCache.bytes()
+}
+
+// CacheItems 分别返回 mainCache 和 hotCache 中当前的数据条数，包括尚未被清理的过期数据
+func (g *Group) CacheItems() (mainItems, hotItems int64) {
+	return int64(g.mainCache.len()), int64(g.hotCache.len())
+}
+
+// populateHotCache 将数据添加到hotCache中，hotCache 独立计算容量，不会从 mainCache 中扣除。关闭 hotCache 时不做任何事
 func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.noHotCache {
+		return
+	}
 	g.hotCache.add(key, value)
 }
 
@@ -198,3 +1411,24 @@ type GetterFunc func(key string) ([]byte, error)
 func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
+
+// GetterContext 与 Getter 相同，额外接收调用方的 context，数据源可以在调用方放弃时中止加载，见 NewGroupContext
+type GetterContext interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterContextFunc 用函数实现 GetterContext
+type GetterContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterContextFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// contextGetter 将 Getter 适配为 GetterContext，忽略 ctx
+type contextGetter struct {
+	getter Getter
+}
+
+func (c contextGetter) Get(_ context.Context, key string) ([]byte, error) {
+	return c.getter.Get(key)
+}
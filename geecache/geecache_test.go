@@ -1,10 +1,19 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "geecache/proto"
+	"geecache/singleflight"
 	"log"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -52,3 +61,1095 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGetPrevious(t *testing.T) {
+	gee := NewGroup("history", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithHistory(time.Minute))
+
+	gee.populateCache("Tom", ByteView{b: []byte("630")})
+	if _, ok := gee.GetPrevious("Tom"); ok {
+		t.Fatal("Tom has no previous value yet")
+	}
+	gee.populateCache("Tom", ByteView{b: []byte("631")})
+	if v, ok := gee.GetPrevious("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("GetPrevious Tom = %q, want 630", v.String())
+	}
+	if v, _ := gee.Get("Tom"); v.String() != "631" {
+		t.Fatalf("Get Tom = %q, want 631", v.String())
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	source := map[string]string{"Tom": "630"}
+	loads := 0
+	gee := NewGroup("refresh", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(source[key]), nil
+		}))
+
+	if v, _ := gee.Get("Tom"); v.String() != "630" || loads != 1 {
+		t.Fatalf("Get Tom = %q after %d loads", v.String(), loads)
+	}
+	source["Tom"] = "631"
+	if v, _ := gee.Get("Tom"); v.String() != "630" {
+		t.Fatalf("Get Tom should still hit the cached 630, got %q", v.String())
+	}
+	if v, err := gee.Refresh("Tom"); err != nil || v.String() != "631" || loads != 2 {
+		t.Fatalf("Refresh Tom = %q, %v after %d loads", v.String(), err, loads)
+	}
+	if v, _ := gee.Get("Tom"); v.String() != "631" || loads != 2 {
+		t.Fatalf("Get Tom after Refresh = %q after %d loads", v.String(), loads)
+	}
+}
+
+func TestRefreshLoadsLikeGet(t *testing.T) {
+	source := map[string]string{"r1": "1", "l1": "1"}
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads[key]++
+		return []byte(source[key]), nil
+	})
+	owner := NewGroup("refresh-owner", 2<<10, getter, WithTTL(time.Hour))
+	gee := NewGroup("refresh-client", 2<<10, getter, WithTTL(time.Hour), WithMinRefetchInterval(time.Hour))
+	gee.RegisterPeers(prefixPeers{peer: &groupPeer{g: owner}})
+	var through int32
+	gee.Use(func(next LoadFunc) LoadFunc {
+		return func(ctx context.Context, key string) (ByteView, error) {
+			atomic.AddInt32(&through, 1)
+			return next(ctx, key)
+		}
+	})
+
+	gee.Get("r1")
+	gee.Get("l1")
+	mu.Lock()
+	source["r1"], source["l1"] = "2", "2"
+	mu.Unlock()
+
+	// 远程的 key 由负责的节点重新加载，该节点的缓存同样被更新
+	if v, err := gee.Refresh("r1"); err != nil || v.String() != "2" {
+		t.Fatalf("Refresh r1 = %q, %v", v.String(), err)
+	}
+	if v, _ := owner.Get("r1"); v.String() != "2" || loads["r1"] != 2 {
+		t.Fatalf("owner r1 = %q after %d loads, want the refreshed value", v.String(), loads["r1"])
+	}
+	// 本地的 key 在最小加载间隔内复用上一次的结果，不访问数据源
+	if v, err := gee.Refresh("l1"); err != nil || v.String() != "1" || loads["l1"] != 1 {
+		t.Fatalf("Refresh l1 = %q, %v after %d loads", v.String(), err, loads["l1"])
+	}
+	if n := atomic.LoadInt32(&through); n != 4 {
+		t.Fatalf("%d loads went through the middleware, want 4", n)
+	}
+}
+
+func TestGetStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	newGetter := func() Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			n := atomic.AddInt32(&loads, 1)
+			return []byte(fmt.Sprintf("%s-%d", key, n)), nil
+		})
+	}
+
+	gee := NewGroup("swr", 2<<10, newGetter(), WithTTL(time.Hour), WithSoftTTL(20*time.Millisecond))
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-1" {
+		t.Fatalf("Get Tom = %q, want Tom-1", v.String())
+	}
+	// 未超过软过期时间，直接命中
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-1" || atomic.LoadInt32(&loads) != 1 {
+		t.Fatalf("fresh Get Tom = %q after %d loads", v.String(), loads)
+	}
+
+	// 超过软过期时间，返回旧值并在后台刷新
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-1" {
+		t.Fatalf("stale Get Tom = %q, want Tom-1", v.String())
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&loads) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-2" {
+		t.Fatalf("refreshed Get Tom = %q, want Tom-2", v.String())
+	}
+
+	// 超过硬过期时间，视为未命中
+	atomic.StoreInt32(&loads, 0)
+	expired := NewGroup("swr-expired", 2<<10, newGetter(), WithTTL(-time.Hour), WithSoftTTL(time.Millisecond))
+	expired.Get("Tom")
+	if v, _ := expired.Get("Tom"); v.String() != "Tom-2" {
+		t.Fatalf("hard-expired Get Tom = %q, want Tom-2", v.String())
+	}
+}
+
+func TestMinRefetchInterval(t *testing.T) {
+	loads := 0
+	gee := NewGroup("refetch", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}), WithMinRefetchInterval(50*time.Millisecond))
+
+	// 模拟数据很快过期导致的重复加载
+	for i := 0; i < 5; i++ {
+		if v, err := gee.load(context.Background(), "Tom"); err != nil || v.String() != "630" {
+			t.Fatalf("load Tom = %q, %v", v.String(), err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loads within interval should be throttled to 1, got %d", loads)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	gee.load(context.Background(), "Tom")
+	if loads != 2 {
+		t.Fatalf("load after interval should hit the source, got %d loads", loads)
+	}
+}
+
+func TestCacheBytes(t *testing.T) {
+	gee := NewGroup("bytes", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	gee.Get("Tom")
+	size := int64(len("Tom") + len(db["Tom"]))
+	if main, hot := gee.CacheBytes(); main != size || hot != 0 {
+		t.Fatalf("CacheBytes = %d, %d, want %d, 0", main, hot, size)
+	}
+
+	// 提升到 hotCache 的值在两个缓存中分别计算
+	v, _ := gee.Get("Tom")
+	gee.populateHotCache("Tom", v)
+	if main, hot := gee.CacheBytes(); main != size || hot != size {
+		t.Fatalf("CacheBytes = %d, %d, want %d, %d", main, hot, size, size)
+	}
+}
+
+func TestHotCacheRatio(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	if gee := NewGroup("hot-ratio-default", 800, getter); gee.hotCache.cacheBytes != 100 {
+		t.Fatalf("default hotCache bytes = %d, want 100", gee.hotCache.cacheBytes)
+	}
+	if gee := NewGroup("hot-ratio-2", 800, getter, WithHotCacheRatio(2)); gee.hotCache.cacheBytes != 400 {
+		t.Fatalf("hotCache bytes with ratio 2 = %d, want 400", gee.hotCache.cacheBytes)
+	}
+	if gee := NewGroup("hot-ratio-small", 3, getter, WithHotCacheRatio(4)); gee.hotCache.cacheBytes != 1 {
+		t.Fatalf("hotCache bytes for a tiny group = %d, want 1", gee.hotCache.cacheBytes)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("WithHotCacheRatio(0) should panic")
+		}
+	}()
+	WithHotCacheRatio(0)
+}
+
+func TestWithoutHotCache(t *testing.T) {
+	gee := NewGroup("no-hot-cache", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithoutHotCache())
+	gee.RegisterPeers(&fakePeers{broadcasts: make(chan string, 10)})
+
+	// 远程访问达到阈值后也不会被统计或提升
+	for i := 0; i < defaultMaxMinuteRemoteQPS; i++ {
+		if _, err := gee.Get("user:1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(gee.keys) != 0 {
+		t.Fatalf("key stats should not be recorded, got %d", len(gee.keys))
+	}
+	gee.populateHotCache("user:1", ByteView{b: []byte("v")})
+	if _, hot := gee.CacheBytes(); hot != 0 {
+		t.Fatalf("hotCache bytes = %d, want 0", hot)
+	}
+	if st := gee.Stats(); st.HotCacheHits != 0 {
+		t.Fatalf("HotCacheHits = %d, want 0", st.HotCacheHits)
+	}
+}
+
+func TestKeyStatsWithoutGlobalLock(t *testing.T) {
+	gee := NewGroup("key-stats-lock", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	// 远程命中的统计只使用 Group 自己的锁，不会被注册 Group 的全局锁阻塞
+	mu.Lock()
+	defer mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		gee.updateKeyStats("Tom", ByteView{b: []byte("630")})
+		gee.updateKeyStats("Tom", ByteView{b: []byte("630")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("updateKeyStats blocked on the global lock")
+	}
+}
+
+func TestGetIfChanged(t *testing.T) {
+	gee := NewGroup("ifchanged", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	v, changed, err := gee.GetIfChanged("Tom", 0)
+	if err != nil || !changed || v.String() != "630" {
+		t.Fatalf("GetIfChanged Tom = %q, %v, %v", v.String(), changed, err)
+	}
+	version := v.Version()
+	if v, changed, err := gee.GetIfChanged("Tom", version); err != nil || changed || v.Len() != 0 {
+		t.Fatalf("GetIfChanged Tom with current version = %q, %v, %v", v.String(), changed, err)
+	}
+
+	gee.populateCache("Tom", ByteView{b: []byte("631")})
+	if v, changed, _ := gee.GetIfChanged("Tom", version); !changed || v.String() != "631" {
+		t.Fatalf("GetIfChanged Tom after update = %q, %v", v.String(), changed)
+	}
+	if _, _, err := gee.GetIfChanged("unknown", version); err == nil {
+		t.Fatal("GetIfChanged unknown should fail")
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	var mu sync.Mutex
+	loadCounts := make(map[string]int)
+	gee := NewGroup("batch", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loadCounts[key]++
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}), WithTTL(time.Hour))
+
+	// 两个重叠的批量请求并发执行，共同的 key 只加载一次
+	var wg sync.WaitGroup
+	for _, keys := range [][]string{{"Tom", "Jack", "Jack"}, {"Jack", "Sam"}} {
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			values, err := gee.GetBatch(keys)
+			if err != nil {
+				t.Errorf("GetBatch %v failed: %v", keys, err)
+			}
+			for _, k := range keys {
+				if values[k].String() != db[k] {
+					t.Errorf("GetBatch %s = %q, want %s", k, values[k].String(), db[k])
+				}
+			}
+		}(keys)
+	}
+	wg.Wait()
+	for k, n := range loadCounts {
+		if n != 1 {
+			t.Fatalf("key %s loaded %d times", k, n)
+		}
+	}
+
+	values, err := gee.GetBatch([]string{"Tom", "unknown"})
+	if err == nil || len(values) != 1 || values["Tom"].String() != "630" {
+		t.Fatalf("GetBatch with missing key = %v, %v", values, err)
+	}
+}
+
+func TestGetBatchLooksUpLikeGet(t *testing.T) {
+	var loads atomic.Int64
+	gee := NewGroup("batch-lookup", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			if strings.HasPrefix(key, "missing") {
+				return nil, ErrNotFound
+			}
+			return []byte(key), nil
+		}), WithTTL(time.Hour), WithNegativeTTL(time.Hour), WithMinRefetchInterval(time.Hour))
+
+	gee.GetBatch([]string{"a", "missing"})
+	before := gee.Stats()
+	values, err := gee.GetBatch([]string{"a", "missing"})
+	if values["a"].String() != "a" || !errors.Is(err, ErrCachedNotFound) {
+		t.Fatalf("GetBatch = %v, %v, want the cached value and the tombstone", values, err)
+	}
+	if s := gee.Stats(); s.Hits-before.Hits != 2 || s.Misses != before.Misses || loads.Load() != 2 {
+		t.Fatalf("cached keys should count as hits without loading, stats %+v, %d loads", s, loads.Load())
+	}
+
+	// 加载失败后写入的值优先于间隔内记录的错误
+	gee.Remove("missing")
+	gee.Set("missing", []byte("now present"), 0)
+	values, err = gee.GetBatch([]string{"missing"})
+	if err != nil || values["missing"].String() != "now present" {
+		t.Fatalf("GetBatch after Set = %v, %v", values, err)
+	}
+}
+
+// 在 -race 下运行：缓存命中、缓存的不存在和未命中的 key 混在一批中，加载与结果写入同时进行
+func TestGetBatchMixedLookups(t *testing.T) {
+	gee := NewGroup("batch-mixed", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			time.Sleep(time.Millisecond)
+			if strings.HasPrefix(key, "gone") {
+				return nil, ErrNotFound
+			}
+			return []byte(key), nil
+		}), WithTTL(time.Hour), WithNegativeTTL(time.Hour))
+
+	var keys, warm []string
+	for i := 0; i < 2*maxBatchLoads; i++ {
+		n := strconv.Itoa(i)
+		warm = append(warm, "hit"+n, "gone"+n)
+		keys = append(keys, "hit"+n, "gone"+n, "miss"+n)
+	}
+	gee.GetBatch(warm)
+
+	check := func(name string, values map[string]ByteView, err error) {
+		if !errors.Is(err, ErrCachedNotFound) || len(values) != 4*maxBatchLoads {
+			t.Fatalf("%s = %d values, %v", name, len(values), err)
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, "gone") && values[key].String() != key {
+				t.Fatalf("%s[%s] = %q", name, key, values[key].String())
+			}
+		}
+	}
+	values, err := gee.GetBatch(keys)
+	check("GetBatch", values, err)
+
+	for i := range keys {
+		if strings.HasPrefix(keys[i], "miss") {
+			keys[i] = "multi-" + keys[i]
+		}
+	}
+	values, err = gee.GetMulti(keys)
+	check("GetMulti", values, err)
+}
+
+func TestGetBatchBoundedConcurrency(t *testing.T) {
+	var active, peak atomic.Int64
+	gee := NewGroup("batch-bounded", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return []byte(key), nil
+		}), WithTTL(time.Hour))
+
+	keys := make([]string, 4*maxBatchLoads)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+	}
+	values, err := gee.GetBatch(keys)
+	if err != nil || len(values) != len(keys) {
+		t.Fatalf("GetBatch = %d values, %v", len(values), err)
+	}
+	if p := peak.Load(); p > maxBatchLoads {
+		t.Fatalf("%d concurrent loads, want at most %d", p, maxBatchLoads)
+	}
+}
+
+func TestGetBatchMissingKeys(t *testing.T) {
+	var loads atomic.Int64
+	gee := NewGroup("batch-missing", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), WithTTL(time.Hour), WithMinRefetchInterval(time.Minute))
+
+	keys := []string{"Tom"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, "missing-"+strconv.Itoa(i))
+	}
+	// 并发的批量请求中相同的缺失 key 只访问一次数据源
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values, err := gee.GetBatch(keys)
+			if !errors.Is(err, ErrNotFound) || len(values) != 1 || values["Tom"].String() != "630" {
+				t.Errorf("GetBatch = %v, %v", values, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != int64(len(keys)) {
+		t.Fatalf("first batches loaded %d times, want %d", n, len(keys))
+	}
+
+	// 已知缺失的 key 不再访问数据源
+	values, err := gee.GetBatch(keys)
+	if !errors.Is(err, ErrNotFound) || len(values) != 1 {
+		t.Fatalf("GetBatch again = %v, %v", values, err)
+	}
+	if n := loads.Load(); n != int64(len(keys)) {
+		t.Fatalf("known-missing keys should not hit the source, got %d loads", n)
+	}
+}
+
+func TestNoCopy(t *testing.T) {
+	buf := []byte("630")
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return buf, nil
+	})
+	copied := NewGroup("copy", 2<<10, getter, WithTTL(time.Hour))
+	shared := NewGroup("nocopy", 2<<10, getter, WithTTL(time.Hour), WithNoCopy())
+	copied.Get("Tom")
+	shared.Get("Tom")
+
+	// 违反约定修改 getter 返回的切片：默认拷贝的缓存不受影响，WithNoCopy 的缓存会随之改变
+	buf[0] = '9'
+	if v, _ := copied.Get("Tom"); v.String() != "630" {
+		t.Fatalf("copied value = %q, want 630", v.String())
+	}
+	if v, _ := shared.Get("Tom"); v.String() != "930" {
+		t.Fatalf("no-copy value = %q, want 930", v.String())
+	}
+}
+
+func BenchmarkGetLocally(b *testing.B) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return make([]byte, 1<<20), nil
+	})
+	for _, bc := range []struct {
+		name string
+		opts []GroupOption
+	}{
+		{"copy", nil},
+		{"nocopy", []GroupOption{WithNoCopy()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			gee := NewGroup("bench-"+bc.name, 0, getter, bc.opts...)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				gee.getLocally(context.Background(), "key")
+			}
+		})
+	}
+}
+
+func TestWithoutSingleflight(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	gee := NewGroup("no-singleflight", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return []byte(key + "-value"), nil
+	}), WithoutSingleflight())
+
+	// 并发的未命中请求各自调用 getter，而不是合并为一次
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom-value" {
+				t.Errorf("Get = %q, %v", v.String(), err)
+			}
+		}()
+	}
+	for loads.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom-value" || loads.Load() != 3 {
+		t.Fatalf("cached Get = %q, %v after %d loads", v.String(), err, loads.Load())
+	}
+}
+
+func TestMaxLoadWaiters(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	gee := NewGroup("max-load-waiters", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte(key + "-value"), nil
+	}), WithMaxLoadWaiters(1))
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := gee.Get("Tom")
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err := gee.Get("Tom")
+		errs <- err
+	}()
+	for gee.LoadWaiters("Tom") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// 等待者已满，新的请求立即失败
+	if _, err := gee.Get("Tom"); !errors.Is(err, singleflight.ErrTooManyWaiters) {
+		t.Fatalf("Get beyond the cap = %v, want ErrTooManyWaiters", err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Get = %v", err)
+		}
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	var loads atomic.Int32
+	gee := NewGroup("single-node", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key + "-value"), nil
+	}), WithSingleNode())
+	// 单节点模式忽略 PeerPicker，所有key都在本地加载
+	gee.RegisterPeers(&fakePeers{broadcasts: make(chan string, 10)})
+	if gee.getPeers() != nil {
+		t.Fatal("single-node group should not keep peers")
+	}
+	for i := 0; i < defaultMaxMinuteRemoteQPS; i++ {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom-value" {
+			t.Fatalf("Get = %q, %v", v.String(), err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("%d loads, want 1", n)
+	}
+	if len(gee.keys) != 0 {
+		t.Fatalf("key stats should not be recorded, got %d", len(gee.keys))
+	}
+	if err := gee.Set("Jack", []byte("589"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "589" {
+		t.Fatalf("Get after Set = %q, %v", v.String(), err)
+	}
+}
+
+// BenchmarkGetMissSingleNode 比较只有当前节点时经过 Server 选择节点与单节点模式下未命中的开销
+func BenchmarkGetMissSingleNode(b *testing.B) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	s, _ := NewServer("127.0.0.1:1")
+	s.SetPeers(s.self)
+	for _, bc := range []struct {
+		name   string
+		opts   []GroupOption
+		picker PeerPicker
+	}{
+		{"peers", nil, s},
+		{"singlenode", []GroupOption{WithSingleNode()}, nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			gee := NewGroup("bench-single-node-"+bc.name, 2<<10, getter, append(bc.opts, WithNoCopy())...)
+			if bc.picker != nil {
+				gee.RegisterPeers(bc.picker)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				gee.Remove("key")
+				gee.Get("key")
+			}
+		})
+	}
+}
+
+func BenchmarkLoadTrivialGetter(b *testing.B) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	for _, bc := range []struct {
+		name string
+		opts []GroupOption
+	}{
+		{"singleflight", nil},
+		{"nosingleflight", []GroupOption{WithoutSingleflight()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			gee := NewGroup("bench-load-"+bc.name, 2<<10, getter, append(bc.opts, WithNoCopy())...)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					gee.load(context.Background(), "key")
+				}
+			})
+		})
+	}
+}
+
+func TestSharedFetch(t *testing.T) {
+	var fetches int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return []byte("shared-config"), nil
+	})
+	canonical := func(key string) string { return "blob:config" }
+	a := NewGroup("shared-a", 2<<10, getter, WithSharedFetch(canonical))
+	b := NewGroup("shared-b", 2<<10, getter, WithSharedFetch(canonical))
+
+	var wg sync.WaitGroup
+	for _, get := range []func() (ByteView, error){
+		func() (ByteView, error) { return a.Get("config") },
+		func() (ByteView, error) { return b.Get("app/config.json") },
+	} {
+		wg.Add(1)
+		go func(get func() (ByteView, error)) {
+			defer wg.Done()
+			if v, err := get(); err != nil || v.String() != "shared-config" {
+				t.Errorf("Get = %q, %v", v.String(), err)
+			}
+		}(get)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("identical underlying fetches should dedupe across groups, got %d", n)
+	}
+}
+
+func TestNewGroupCacheBytes(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	})
+
+	// 0 表示不限制容量
+	unlimited := NewGroup("cachebytes-zero", 0, getter, WithTTL(time.Hour))
+	for i := 0; i < 1000; i++ {
+		unlimited.populateCache(fmt.Sprint(i), ByteView{b: make([]byte, 1024)})
+		unlimited.populateHotCache(fmt.Sprint(i), ByteView{b: make([]byte, 1024)})
+	}
+	if main, hot := unlimited.CacheBytes(); main < 1000*1024 || hot < 1000*1024 {
+		t.Fatalf("zero cacheBytes should be unlimited, got main=%d hot=%d", main, hot)
+	}
+
+	// 容量很小时 hotCache 仍然有上限
+	small := NewGroup("cachebytes-small", 4, getter, WithTTL(time.Hour))
+	small.populateHotCache("k", ByteView{b: []byte("value")})
+	if _, hot := small.CacheBytes(); hot != 0 {
+		t.Fatalf("hotCache of a tiny group should stay bounded, got %d bytes", hot)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("negative cacheBytes should panic")
+		}
+	}()
+	NewGroup("cachebytes-negative", -1, getter)
+}
+
+func TestRemove(t *testing.T) {
+	loads := 0
+	gee := NewGroup("remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key + "-" + strconv.Itoa(loads)), nil
+	}), WithTTL(time.Hour), WithMinRefetchInterval(time.Hour))
+	events := gee.Events()
+
+	gee.Get("Tom")
+	gee.populateHotCache("Tom", ByteView{b: []byte("Tom-1")})
+	gee.updateKeyStats("Tom", ByteView{})
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.getCached("Tom"); ok {
+		t.Fatal("Tom should be removed from both caches")
+	}
+	gee.keysMu.Lock()
+	_, tracked := gee.keys["Tom"]
+	gee.keysMu.Unlock()
+	if tracked {
+		t.Fatal("Tom's key stats should be cleared")
+	}
+
+	// 删除后重新从数据源加载，不受最小加载间隔影响
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom-2" {
+		t.Fatalf("Get after Remove = %q, %v", v.String(), err)
+	}
+	// 可以重复删除不存在的 key
+	if err := gee.Remove("missing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gee.Remove(""); err == nil {
+		t.Fatal("empty key should fail")
+	}
+
+	expect := []CacheEvent{
+		{Type: EventLoad, Group: "remove", Key: "Tom", Source: "local"},
+		{Type: EventDelete, Group: "remove", Key: "Tom", Source: "main"},
+		{Type: EventDelete, Group: "remove", Key: "Tom", Source: "hot"},
+		{Type: EventLoad, Group: "remove", Key: "Tom", Source: "local"},
+	}
+	if got := drainEvents(events); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("events = %v, expect %v", got, expect)
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	gee := NewGroup("range", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	}), WithTTL(time.Hour))
+
+	if v, err := gee.GetRange("Tom", 1, 3); err != nil || v.String() != "30" {
+		t.Fatalf("GetRange Tom [1:3] = %q, %v", v.String(), err)
+	}
+	if _, err := gee.GetRange("Tom", 2, 4); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("GetRange out of bounds = %v", err)
+	}
+	if _, err := gee.GetRange("unknown", 0, 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetRange unknown = %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	loads := 0
+	var buf []byte
+	gee := NewGroup("checksum", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		buf = []byte(db[key])
+		return buf, nil
+	}), WithTTL(time.Hour), WithNoCopy(), WithChecksum())
+
+	gee.Get("Tom")
+	if v, _ := gee.Get("Tom"); v.String() != "630" || loads != 1 {
+		t.Fatalf("intact value = %q after %d loads", v.String(), loads)
+	}
+
+	// WithNoCopy 时缓存直接引用 getter 返回的切片，修改它相当于破坏缓存中的数据
+	buf[0] = '9'
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" || loads != 2 {
+		t.Fatalf("corrupted value should be reloaded, got %q, %v after %d loads", v.String(), err, loads)
+	}
+}
+
+// setterPeer 将写入请求直接转发给同进程中负责该key的 Group
+type setterPeer struct {
+	groupPeer
+}
+
+func (p *setterPeer) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	return p.g.Set(in.GetKey(), in.GetValue(), time.Duration(in.GetTtlMs())*time.Millisecond)
+}
+
+// multiPeer 用同进程中负责该key的 Group 响应批量请求，并记录批量请求次数
+type multiPeer struct {
+	groupPeer
+	multiGets int32
+}
+
+func (p *multiPeer) GetMulti(in *pb.MultiRequest, out *pb.MultiResponse) error {
+	atomic.AddInt32(&p.multiGets, 1)
+	values, errs := p.g.getEach(context.Background(), in.GetKeys())
+	for key, v := range values {
+		out.Entries = append(out.Entries, &pb.Entry{Key: key, Value: v.ByteSlice()})
+	}
+	out.Errors = make(map[string]string)
+	for key, err := range errs {
+		if errors.Is(err, ErrNotFound) {
+			out.NotFound = append(out.NotFound, key)
+			continue
+		}
+		out.Errors[key] = err.Error()
+	}
+	return nil
+}
+
+// prefixPeers 将以 r 开头的 key 路由到 peer，其余 key 由本节点负责
+type prefixPeers struct {
+	peer PeerGetter
+}
+
+func (p prefixPeers) PickPeer(key string) (PeerGetter, bool) {
+	return p.peer, strings.HasPrefix(key, "r")
+}
+
+func TestGetMulti(t *testing.T) {
+	source := func(loads *int32) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(loads, 1)
+			if strings.HasSuffix(key, "missing") {
+				return nil, ErrNotFound
+			}
+			return []byte(key + "-value"), nil
+		})
+	}
+	var ownerLoads, localLoads int32
+	owner := NewGroup("multi-owner", 2<<10, source(&ownerLoads), WithTTL(time.Hour))
+	peer := &multiPeer{groupPeer: groupPeer{g: owner}}
+	gee := NewGroup("multi-client", 2<<10, source(&localLoads), WithTTL(time.Hour))
+	gee.RegisterPeers(prefixPeers{peer: peer})
+	gee.populateHotCache("r-hot", ByteView{b: []byte("r-hot-cached")})
+
+	keys := []string{"r1", "r2", "r-missing", "r-hot", "l1", "l2", "r1"}
+	values, err := gee.GetMulti(keys)
+	if err == nil || strings.Count(err.Error(), "missing") != 1 || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetMulti error = %v, want only r-missing to fail with ErrNotFound", err)
+	}
+	want := map[string]string{"r1": "r1-value", "r2": "r2-value", "r-hot": "r-hot-cached", "l1": "l1-value", "l2": "l2-value"}
+	if len(values) != len(want) {
+		t.Fatalf("GetMulti returned %d values, want %d", len(values), len(want))
+	}
+	for key, v := range want {
+		if values[key].String() != v {
+			t.Fatalf("%s = %q, want %q", key, values[key].String(), v)
+		}
+	}
+	// 远程的 key 合并为一次批量请求，已缓存的 key 不再请求
+	if n := atomic.LoadInt32(&peer.multiGets); n != 1 {
+		t.Fatalf("peer got %d batched requests, want 1", n)
+	}
+	if ownerLoads != 3 || localLoads != 2 {
+		t.Fatalf("owner loaded %d keys, client loaded %d, want 3 and 2", ownerLoads, localLoads)
+	}
+}
+
+func TestSet(t *testing.T) {
+	noLoad := GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should not be loaded", key)
+		return nil, nil
+	})
+	owner := NewGroup("set-owner", 2<<10, noLoad, WithTTL(time.Hour))
+	client := NewGroup("set-client", 2<<10, noLoad, WithTTL(time.Hour))
+	client.RegisterPeers(nilPeers{peer: &setterPeer{groupPeer{g: owner}}})
+
+	value := []byte("v1")
+	if err := client.Set("k", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'x' // Set 保存的是拷贝
+	if v, ok := owner.mainCache.get("k"); !ok || v.String() != "v1" {
+		t.Fatalf("owner mainCache = %q, %v", v.String(), ok)
+	}
+	if v, ok := client.hotCache.get("k"); !ok || v.String() != "v1" {
+		t.Fatalf("client hotCache = %q, %v", v.String(), ok)
+	}
+	if _, ok := client.mainCache.get("k"); ok {
+		t.Fatal("non-owner should not store the value in mainCache")
+	}
+
+	// 负责的节点写入新值时删除 hotCache 中的旧值
+	owner.hotCache.add("k", ByteView{b: []byte("old")})
+	if err := owner.Set("k", []byte("v2"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := owner.Get("k"); err != nil || v.String() != "v2" {
+		t.Fatalf("owner get = %q, %v", v.String(), err)
+	}
+
+	if err := client.Set("", nil, 0); err == nil {
+		t.Fatal("empty key should fail")
+	}
+	readOnly := NewGroup("set-read-only", 2<<10, noLoad)
+	readOnly.RegisterPeers(nilPeers{peer: fakePeer{}})
+	if err := readOnly.Set("k", []byte("v"), 0); err == nil {
+		t.Fatal("peer without Set should fail")
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	// 默认不延长，剩余有效期不超过 ttl；开启后在 ttl 之外随机延长 [0, jitter)
+	exact := NewGroup("ttl-exact", 2<<10, getter, WithTTL(time.Minute))
+	jittered := NewGroup("ttl-jittered", 2<<10, getter, WithTTL(time.Minute), WithTTLJitter(time.Minute))
+	var extended int
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		if _, ttl, _ := exact.GetWithTTL(key); ttl > time.Minute {
+			t.Fatalf("ttl of %s = %v without jitter", key, ttl)
+		}
+		_, ttl, _ := jittered.GetWithTTL(key)
+		if ttl < time.Minute-time.Second || ttl >= 2*time.Minute {
+			t.Fatalf("ttl of %s = %v, want within [1m, 2m)", key, ttl)
+		}
+		if ttl > time.Minute+time.Second {
+			extended++
+		}
+	}
+	if extended == 0 {
+		t.Fatal("jitter was never applied")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	// 最先发起加载的调用方超时后，共享的加载继续进行，其他等待者仍然得到结果
+	leaderStarted, leaderRelease := make(chan struct{}), make(chan struct{})
+	slow := NewGroupContext("ctx-slow", 2<<10, GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		close(leaderStarted)
+		<-leaderRelease
+		return []byte("630"), ctx.Err()
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := slow.GetContext(ctx, "Tom")
+		leaderErr <- err
+	}()
+	<-leaderStarted
+	waiterDone := make(chan error, 1)
+	go func() {
+		v, err := slow.GetContext(context.Background(), "Tom")
+		if err == nil && v.String() != "630" {
+			err = fmt.Errorf("value %q", v.String())
+		}
+		waiterDone <- err
+	}()
+	if err := <-leaderErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext error = %v, want deadline exceeded", err)
+	}
+	close(leaderRelease)
+	if err := <-waiterDone; err != nil {
+		t.Fatalf("waiter should get the shared result after the leader timed out, got %v", err)
+	}
+
+	// 等待合并加载的调用方放弃时不会中断共享的加载
+	started, release := make(chan struct{}), make(chan struct{})
+	shared := NewGroupContext("ctx-shared", 2<<10, GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("630"), ctx.Err()
+	}))
+	done := make(chan error)
+	go func() {
+		_, err := shared.GetContext(context.Background(), "Tom")
+		done <- err
+	}()
+	<-started
+	waiter, cancelWaiter := context.WithCancel(context.Background())
+	cancelWaiter()
+	if _, err := shared.GetContext(waiter, "Tom"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("waiter error = %v, want canceled", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("leader error = %v", err)
+	}
+	if v, err := shared.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+}
+
+func TestMinRefetchIgnoresContextErrors(t *testing.T) {
+	var loads atomic.Int32
+	gee := NewGroupContext("refetch-ctx-error", 2<<10, GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return []byte("630"), nil
+	}), WithMinRefetchInterval(time.Hour), WithoutSingleflight())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gee.GetContext(ctx, "Tom"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetContext with a canceled ctx = %v", err)
+	}
+	// 调用方的取消不会在间隔内被其他调用方复用
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" || loads.Load() != 2 {
+		t.Fatalf("Get after a canceled load = %q, %v with %d loads", v.String(), err, loads.Load())
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	loads := make(map[string]int)
+	var mu sync.Mutex
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		loads[key]++
+		mu.Unlock()
+		switch key {
+		case "missing":
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		case "broken":
+			return nil, errors.New("database is down")
+		}
+		return []byte(key), nil
+	})
+
+	// 默认不开启，不存在的key每次都访问数据源
+	plain := NewGroup("negative-off", 2<<10, getter)
+	plain.Get("missing")
+	if _, err := plain.Get("missing"); errors.Is(err, ErrCachedNotFound) || loads["missing"] != 2 {
+		t.Fatalf("negative caching should be off by default, err %v, loads %d", err, loads["missing"])
+	}
+
+	loads = make(map[string]int)
+	gee := NewGroup("negative", 2<<10, getter, WithNegativeTTL(50*time.Millisecond))
+	if _, err := gee.Get("missing"); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrCachedNotFound) {
+		t.Fatalf("first load error = %v", err)
+	}
+	if _, err := gee.Get("missing"); !errors.Is(err, ErrCachedNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Get error = %v, want cached miss", err)
+	}
+	if _, err := gee.GetBatch([]string{"missing", "a"}); !errors.Is(err, ErrCachedNotFound) {
+		t.Fatalf("GetBatch error = %v, want cached miss", err)
+	}
+	// 其他错误不会被缓存
+	gee.Get("broken")
+	gee.Get("broken")
+	if loads["missing"] != 1 || loads["broken"] != 2 {
+		t.Fatalf("loads = %v", loads)
+	}
+	if s := gee.Stats(); s.Hits != 2 {
+		t.Fatalf("cached misses should count as hits, Stats = %+v", s)
+	}
+
+	// 写入新值覆盖墓碑
+	gee.Set("missing", []byte("now exists"), time.Hour)
+	if v, err := gee.Get("missing"); err != nil || v.String() != "now exists" {
+		t.Fatalf("Get after Set = %v, %v", v, err)
+	}
+
+	// 墓碑过期后重新访问数据源(随机延长不超过 ttl)
+	gee.Remove("missing")
+	gee.Get("missing")
+	time.Sleep(110 * time.Millisecond)
+	if _, err := gee.Get("missing"); errors.Is(err, ErrCachedNotFound) || loads["missing"] != 3 {
+		t.Fatalf("expired tombstone: err %v, loads %d", err, loads["missing"])
+	}
+}
+
+func TestGroupKeys(t *testing.T) {
+	gee := NewGroup("keys", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	}), WithTTL(time.Hour), WithNegativeTTL(time.Hour))
+	for _, key := range []string{"a", "b", "c", "missing"} {
+		gee.Get(key)
+	}
+	gee.Get("a")
+	gee.populateHotCache("b", ByteView{b: []byte("b")})
+	gee.populateHotCache("hot", ByteView{b: []byte("hot")})
+
+	// hotCache 在前，各自按最近使用排序，b 只出现一次，墓碑不算
+	want := []string{"hot", "b", "a", "c"}
+	if keys := gee.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Keys = %v, want %v", keys, want)
+	}
+}
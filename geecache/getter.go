@@ -0,0 +1,38 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound 表示数据源中不存在该 key，Getter 可以返回它(或包装它)来与其他错误区分
+var ErrNotFound = errors.New("geecache: key not found")
+
+// ErrCachedNotFound 表示命中了负缓存：该key最近一次从数据源加载时不存在，这次没有访问数据源(见 WithNegativeTTL)。
+// 它包装了 ErrNotFound，errors.Is(err, ErrNotFound) 同样成立
+var ErrCachedNotFound = fmt.Errorf("%w (cached)", ErrNotFound)
+
+// multiGetter 按顺序依次尝试多个数据源
+type multiGetter []Getter
+
+// MultiGetter 返回一个按顺序尝试 getters 的 Getter(例如主库、从库、归档)
+// 返回第一个成功的结果；全部失败时返回聚合了每个数据源错误的 error，
+// 若所有数据源都返回 ErrNotFound，errors.Is(err, ErrNotFound) 仍然成立
+func MultiGetter(getters ...Getter) Getter {
+	return multiGetter(getters)
+}
+
+func (m multiGetter) Get(key string) ([]byte, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no getter for key %s", key)
+	}
+	errs := make([]error, 0, len(m))
+	for i, getter := range m {
+		bytes, err := getter.Get(key)
+		if err == nil {
+			return bytes, nil
+		}
+		errs = append(errs, fmt.Errorf("getter %d: %w", i, err))
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMultiGetter(t *testing.T) {
+	primary := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("primary down")
+	})
+	replica := GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	})
+	archive := GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+
+	g := MultiGetter(primary, replica, archive)
+	if v, err := g.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("MultiGetter Get Tom = %q, %v", v, err)
+	}
+
+	_, err := g.Get("unknown")
+	if err == nil {
+		t.Fatal("MultiGetter should fail when all getters fail")
+	}
+	for _, want := range []string{"getter 0: primary down", "getter 1: unknown", "getter 2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("combined error %q should contain %q", err, want)
+		}
+	}
+
+	_, err = MultiGetter(replica, archive).Get("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("all not found should be ErrNotFound, got %v", err)
+	}
+}
@@ -2,35 +2,182 @@ package geecache
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"geecache/consistenthash"
+	"geecache/lru"
 	pb "geecache/proto"
 	"geecache/registry"
+	"io"
 	"log"
 	"net"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
-const defaultReplicas = 50 // 默认虚拟节点数量
+const (
+	defaultReplicas    = 50               // 默认虚拟节点数量
+	exportTimeout      = time.Minute      // 从远程节点导出数据的超时时间
+	defaultRPCTimeout  = 10 * time.Second // 单次请求远程节点的默认超时时间，见 Client.RPCTimeout
+	etcdDialTimeout    = 5 * time.Second  // etcd配置未设置 DialTimeout 时连接etcd的超时时间
+	maxEventsPerSecond = 100              // Events 流每秒最多推送的事件数
+	responseCacheBytes = 1 << 20          // 客户端响应缓存的容量，见 Client.ResponseTTL
+)
 
 // server 模块为geecache之间提供通信能力
 // 这样部署在其他机器上的cache可以通过访问server获取缓存
 
-var (
-	defaultEtcdConfig = clientv3.Config{
-		Endpoints:   []string{"localhost:2379"}, // etcd服务器的地址，这里使用本地地址和默认端口
-		DialTimeout: 5 * time.Second,            // 建立连接的超时时间为5秒
+// errCacheMiss 只查询缓存的请求未命中
+var errCacheMiss = errors.New("cache miss")
+
+// ErrGroupNotFound 表示远程节点上没有请求的缓存组，Client 的各个方法返回包装了它的错误，
+// 调用方可以用 errors.Is 区分节点之间缓存组配置不一致和其他失败。负责 key 的节点返回它时，
+// Group.Get 直接返回该错误，不会退回本地数据源或旧数据
+var ErrGroupNotFound = errors.New("geecache: group not found")
+
+// GroupFactory 在收到本节点不存在的缓存组的请求时创建该组，不认识 name 时返回 nil，见 WithGroupFactory
+type GroupFactory func(name string) *Group
+
+// errDraining 服务正在停止，不再接受新的远程请求
+var errDraining = status.Error(codes.Unavailable, "server is draining")
+
+// defaultDiscovery 返回未设置 WithDiscovery 时使用的节点注册与发现：连接 cfg 的 registry.EtcdDiscovery，
+// 注册的节点携带元数据 md。不再使用时调用 release 释放共享的etcd客户端，测试时可以替换
+var defaultDiscovery = func(cfg clientv3.Config, md registry.Metadata) (d Discovery, release func(), err error) {
+	cli, release, err := sharedEtcdClient(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
-)
+	return registry.NewEtcdDiscovery(cli, md), release, nil
+}
+
+// dialService 通过etcd发现服务 service 并使用凭证 creds 建立连接，不再使用时调用 release 释放，测试时可以替换
+// creds 为 nil 时使用不加密的连接，相同etcd配置的连接共享同一个etcd客户端
+var dialService = func(cfg clientv3.Config, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (conn *grpc.ClientConn, release func(), err error) {
+	cli, release, err := sharedEtcdClient(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err = registry.EtcdDial(cli, service, creds, opts...)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return conn, release, nil
+}
+
+// dialStatic 不经过etcd，直接使用凭证 creds 连接地址 addr(ip:port)，creds 为 nil 时使用不加密的连接
+func dialStatic(addr string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+	return grpc.NewClient(addr, opts...)
+}
+
+// etcdClients 按etcd地址、认证和TLS配置共享的etcd客户端，引用计数归零时关闭
+var etcdClients = struct {
+	sync.Mutex
+	m map[etcdClientKey]*refEtcdClient
+}{m: make(map[etcdClientKey]*refEtcdClient)}
+
+// etcdClientKey 区分共享的etcd客户端，地址相同但认证或TLS配置不同的配置使用不同的客户端
+type etcdClientKey struct {
+	endpoints string
+	username  string
+	password  string
+	tls       *tls.Config
+}
+
+type refEtcdClient struct {
+	cli  *clientv3.Client
+	refs int
+}
+
+// sharedEtcdClient 返回连接 cfg 的共享etcd客户端，用完后调用 release 释放引用
+func sharedEtcdClient(cfg clientv3.Config) (cli *clientv3.Client, release func(), err error) {
+	key := etcdClientKey{
+		endpoints: strings.Join(cfg.Endpoints, ","),
+		username:  cfg.Username,
+		password:  cfg.Password,
+		tls:       cfg.TLS,
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = etcdDialTimeout // 限制连接etcd的时间，避免不可达的etcd阻塞请求
+	}
+	etcdClients.Lock()
+	defer etcdClients.Unlock()
+	rc, ok := etcdClients.m[key]
+	if !ok {
+		c, err := clientv3.New(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		rc = &refEtcdClient{cli: c}
+		etcdClients.m[key] = rc
+	}
+	rc.refs++
+	var once sync.Once
+	return rc.cli, func() {
+		once.Do(func() {
+			etcdClients.Lock()
+			defer etcdClients.Unlock()
+			if rc.refs--; rc.refs == 0 {
+				delete(etcdClients.m, key)
+				rc.cli.Close()
+			}
+		})
+	}, nil
+}
+
+// etcdConfigOr 返回 cfg，未设置 Endpoints 时返回 registry.SetEtcdConfig 设置的配置(默认为 localhost:2379)
+func etcdConfigOr(cfg clientv3.Config) clientv3.Config {
+	if len(cfg.Endpoints) == 0 {
+		return registry.EtcdConfig()
+	}
+	return cfg
+}
 
 type Client struct {
-	baseURL string // 服务名称 geecache/ip:addr
+	baseURL    string            // 服务名称 geecache/ip:addr
+	etcdConfig clientv3.Config   // 发现服务使用的etcd配置，为空时使用 registry.EtcdConfig
+	tlsConfig  *tls.Config       // 连接远程节点使用的TLS配置，为 nil 时不加密
+	dialOpts   []grpc.DialOption // 建立连接时附加的选项，见 WithDialOptions
+	static     bool              // 为 true 时直接连接 addr，不通过etcd发现
+	// RPCTimeout 是单次请求(Get、Set、Increment、GetMulti)的超时时间，为0时使用 defaultRPCTimeout。
+	// 调用方通过 GetContext 传入的 ctx 截止时间更早时以 ctx 为准
+	RPCTimeout time.Duration
+	// ResponseTTL 大于0时开启客户端响应缓存：Get 的响应按 group/key 在本地保存 ResponseTTL，
+	// 期间重复读取同一个key不再发送请求。以最多 ResponseTTL 的陈旧换取更少的RPC，
+	// 通过本客户端的 Set 和 Increment 会丢弃对应key的缓存，CacheOnly 请求不使用该缓存
+	ResponseTTL time.Duration
+	state       atomic.Int32 // 最近一次观察到的连接状态(connectivity.State)，从未连接时为 Idle
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn    // 首次请求时建立，之后的请求复用，Close 时释放
+	release func()              // 释放 conn 使用的etcd客户端
+	stub    pb.GroupCacheClient // 基于 conn 的gRPC客户端
+
+	respMu    sync.Mutex
+	responses *lru.Cache // 客户端响应缓存，首次保存响应时创建
 }
 
 // NewClient 创建一个远程节点客户端
@@ -38,23 +185,207 @@ func NewClient(service string) *Client {
 	return &Client{baseURL: service}
 }
 
+// NewStaticClient 创建一个直接连接 addr(ip:port) 的远程节点客户端，不依赖etcd，
+// 用于不运行etcd的静态集群，见 WithStaticCluster
+func NewStaticClient(addr string) *Client {
+	return &Client{baseURL: fmt.Sprintf("geecache-%s", addr), static: true}
+}
+
 // server 和group是解耦的，所以server要自己做并发控制
 type Server struct {
 	pb.UnimplementedGroupCacheServer
-	self       string     // 当前服务器地址,ip:port
-	status     bool       // 服务器运行状态
-	stopSignal chan error // 用于接收通知，通知服务器停止运行
-	mu         sync.Mutex
-	peers      *consistenthash.Map // 一致性哈希，用于确定缓存数据在集群中的分布
-	clients    map[string]*Client  //  用于存储其他节点的客户端连接
+	self         string     // 当前服务器地址,ip:port
+	status       bool       // 服务器运行状态
+	stopSignal   chan error // 用于接收通知，通知服务器停止运行
+	mu           sync.Mutex
+	peers        consistenthash.Picker        // 一致性哈希，用于确定缓存数据在集群中的分布
+	newPicker    func() consistenthash.Picker // 创建 peers 的方法，默认为带虚拟节点的哈希环
+	clients      map[string]*Client           //  用于存储其他节点的客户端连接
+	addrs        []string                     // SetPeers 传入的所有节点地址，已排序，用于确定只读副本
+	metadata     registry.Metadata            // 注册至etcd的本节点元数据
+	etcdConfig   clientv3.Config              // 注册和发现使用的etcd配置，为空时使用 registry.EtcdConfig
+	tlsConfig    *tls.Config                  // 节点间通信使用的TLS配置，为 nil 时不加密
+	dialOpts     []grpc.DialOption            // 连接其他节点时附加的选项，见 WithDialOptions
+	groupFactory GroupFactory                 // 收到不存在的缓存组的请求时用于创建该组，为 nil 时返回 NotFound
+	factoryMu    sync.Mutex                   // 保证同一个缓存组只被 groupFactory 创建一次
+	peerMeta     map[string]registry.Metadata // 从etcd同步的其他节点元数据
+	warmGroups   []string                     // 启动时需要从其他节点预热的缓存组
+	ready        chan struct{}                // 预热完成后关闭
+	readyOnce    sync.Once
+	warmup       WarmupProgress     // 预热进度，由 mu 保护
+	tracePick    bool               // 为 true 时 PickPeer 记录每次选择的完整过程
+	eventsToken  string             // 订阅 Events 需要携带的令牌，为空表示不开放订阅
+	static       bool               // 静态集群模式，不注册至etcd，直接连接 SetPeers 传入的地址
+	singleNode   bool               // 单节点模式，不注册、不发现其他节点，所有key都在本地加载
+	discovery    Discovery          // 自定义的注册与发现后端，为 nil 时使用 defaultDiscovery
+	stopWatch    context.CancelFunc // 停止监听 discovery 的节点变化
+	rpcTimeout   time.Duration      // 请求其他节点的超时时间，为0时使用 defaultRPCTimeout
+	responseTTL  time.Duration      // 客户端缓存其他节点响应的时长，为0表示不缓存
+
+	grpcServer   *grpc.Server
+	health       *health.Server // gRPC 健康检查服务，停止时置为 NOT_SERVING
+	draining     bool           // 正在优雅停止，拒绝新的远程请求
+	deregistered chan struct{}  // 从etcd注销并关闭监听后关闭
+}
+
+// ServerOption 用于在 NewServer 时配置 Server 的可选行为
+type ServerOption func(*Server)
+
+// WithMetadata 设置本节点注册至etcd时携带的元数据(可用区、权重、版本)
+func WithMetadata(md registry.Metadata) ServerOption {
+	return func(s *Server) {
+		s.metadata = md
+	}
+}
+
+// WithEtcdConfig 设置本节点注册、发现其他节点时连接etcd的配置，
+// 未设置时使用 registry.SetEtcdConfig 设置的全局配置(默认为 localhost:2379)
+func WithEtcdConfig(cfg clientv3.Config) ServerOption {
+	return func(s *Server) {
+		s.etcdConfig = cfg
+	}
+}
+
+// WithTLSConfig 设置节点间gRPC通信使用的TLS配置，服务端和连接其他节点的客户端都会使用
+// cfg 需要包含本节点的证书和用于校验其他节点证书的根证书，未设置时节点间通信不加密
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithDialOptions 为连接其他节点的客户端附加gRPC连接选项，例如通过 grpc.WithChainUnaryInterceptor
+// 添加记录远程调用耗时的拦截器(见 metrics 包)
+func WithDialOptions(opts ...grpc.DialOption) ServerOption {
+	return func(s *Server) {
+		s.dialOpts = append(s.dialOpts, opts...)
+	}
+}
+
+// WithStaticCluster 以静态集群模式运行，不依赖etcd：Start 不将本节点注册至etcd，Stop 也无需注销，
+// 其他节点完全由 SetPeers 配置并直接按地址连接。适用于节点固定的小集群和本地开发
+func WithStaticCluster() ServerOption {
+	return func(s *Server) {
+		s.static = true
+	}
+}
+
+// WithSingleNodeCluster 开启单节点模式：不注册至etcd(或 WithDiscovery 设置的后端)，也不监听节点变化，
+// 集群中始终只有当前节点，SetPeers 传入的其他节点会被忽略，PickPeer 不加锁直接返回 false。
+// 配合 Group 的 WithSingleNode 使用，适用于只部署一个节点的小型服务
+func WithSingleNodeCluster() ServerOption {
+	return func(s *Server) {
+		s.singleNode = true
+	}
+}
+
+// WithRPCTimeout 设置请求其他节点的单次超时时间，默认为 defaultRPCTimeout(10s)，对延迟敏感的服务可以调小以尽快失败
+func WithRPCTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.rpcTimeout = timeout
+	}
+}
+
+// WithClientResponseTTL 让连接其他节点的客户端在本地缓存 Get 的响应 ttl，期间重复读取同一个key不再请求远程节点。
+// 与 hotCache 不同，它不受 Group 配置影响，适合读多写少且能容忍短暂陈旧的场景，见 Client.ResponseTTL。
+// 负责该key的节点上的 Delete 以及失效通知(见 WithInvalidationBus)都无法清除客户端中的响应缓存，
+// 删除或失效后最多 ttl 内仍可能读到旧值
+func WithClientResponseTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.responseTTL = ttl
+	}
 }
 
-func NewServer(self string) (*Server, error) {
-	return &Server{
+// WithDiscovery 使用 d 代替默认的 registry.EtcdDiscovery 注册本节点并驱动集群成员：Start 时通过 Resolve 获取初始节点，
+// 之后通过 Watch 监听节点变化并自动调用 SetPeers。d 返回的地址直接用于连接其他节点，
+// 默认的 EtcdDiscovery 发现的节点仍通过etcd建立连接
+func WithDiscovery(d Discovery) ServerOption {
+	return func(s *Server) {
+		s.discovery = d
+	}
+}
+
+// WithGroupFactory 使节点收到本地不存在的缓存组的请求时调用 factory 自动创建并注册该组，
+// 默认返回 NotFound 状态码，调用方的 Client 将其转换为包装了 ErrGroupNotFound 的错误
+func WithGroupFactory(factory GroupFactory) ServerOption {
+	return func(s *Server) {
+		s.groupFactory = factory
+	}
+}
+
+// WithJoinWarming 开启加入集群时的预热：Start 在注册至etcd之前，先从 Set 配置的其他节点
+// 拉取 groups 中本节点将要负责的数据，避免新节点冷启动时大量请求打到数据源。预热完成后 Ready 被关闭
+func WithJoinWarming(groups ...string) ServerOption {
+	return func(s *Server) {
+		s.warmGroups = groups
+	}
+}
+
+// WithJumpHash 使用跳跃一致性哈希代替默认的哈希环选择节点，
+// 键分布更均匀且不需要虚拟节点，适合节点数很多的集群。节点的编号与 SetPeers 传入的顺序无关，
+// 见 consistenthash.Jump.Set
+func WithJumpHash() ServerOption {
+	return func(s *Server) {
+		s.newPicker = func() consistenthash.Picker { return consistenthash.NewJump(nil) }
+	}
+}
+
+// WithReplicas 设置哈希环上每个节点的虚拟节点数，默认为 defaultReplicas，
+// 可以用 consistenthash.RecommendReplicas 根据节点数估算
+func WithReplicas(replicas int) ServerOption {
+	return func(s *Server) {
+		s.newPicker = func() consistenthash.Picker { return consistenthash.New(replicas, nil) }
+	}
+}
+
+// WithMaxVirtualNodes 将哈希环上的虚拟节点总数限制在 n 左右，节点越多每个节点的虚拟节点越少，
+// 使环占用的内存不随集群规模增长，见 consistenthash.NewCapped
+func WithMaxVirtualNodes(n int) ServerOption {
+	return func(s *Server) {
+		s.newPicker = func() consistenthash.Picker { return consistenthash.NewCapped(n, nil) }
+	}
+}
+
+// WithPickTrace 开启节点选择的跟踪日志，PickPeer 每次都会输出 key 的哈希值、命中位置和最终节点，
+// 用于排查路由不符合预期的问题。不开启时也可以通过 ExplainPick 查询单个 key
+func WithPickTrace() ServerOption {
+	return func(s *Server) {
+		s.tracePick = true
+	}
+}
+
+// WithEventsToken 开放 Events RPC 用于远程调试，客户端需要在 gRPC metadata 中携带
+// authorization: Bearer <token>。未设置时 Events RPC 拒绝所有订阅
+func WithEventsToken(token string) ServerOption {
+	return func(s *Server) {
+		s.eventsToken = token
+	}
+}
+
+// NewServer 创建一个缓存服务，self 为当前节点地址 ip:port，IPv6 地址需写成 [::1]:port 的形式
+func NewServer(self string, opts ...ServerOption) (*Server, error) {
+	host, port, err := net.SplitHostPort(self)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address %q: %v", self, err)
+	}
+	// 统一为带方括号的规范形式，注册至 etcd 后可以直接用于拨号
+	self = net.JoinHostPort(host, port)
+	s := &Server{
 		self:    self,
-		peers:   consistenthash.New(defaultReplicas, nil),
 		clients: make(map[string]*Client),
-	}, nil
+		ready:   make(chan struct{}),
+		newPicker: func() consistenthash.Picker {
+			return consistenthash.New(defaultReplicas, nil)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.peers = s.newPicker()
+	if s.singleNode {
+		s.SetPeers(s.self)
+	}
+	return s, nil
 }
 
 // Get 实现了 Server 结构体用于处理 gRPC 客户端的请求
@@ -62,19 +393,40 @@ func (s *Server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	group, key := in.GetGroup(), in.GetKey()
 	resp := &pb.Response{}
 	log.Printf("[Geecache_svr %s] Recv RPC request %s/%s", s.self, group, key)
+	if s.isDraining() {
+		return resp, errDraining
+	}
 	if key == "" {
 		return resp, fmt.Errorf("key is required")
 	}
-	g := GetGroup(group)
-	if g == nil {
-		return resp, fmt.Errorf("group not found")
-	}
-	view, err := g.Get(key)
+	g, err := s.lookupGroup(group)
 	if err != nil {
 		return resp, err
 	}
+	var view ByteView
+	if in.GetCacheOnly() {
+		// 只读副本查询，未命中时不加载
+		v, ok := g.getCached(key)
+		if !ok {
+			return resp, errCacheMiss
+		}
+		view = v
+	} else if in.GetRefresh() {
+		// 其他节点转发的 Refresh，绕过缓存重新加载
+		v, err := g.refresh(ctx, key)
+		if err != nil {
+			return resp, err
+		}
+		view = v
+	} else {
+		v, err := g.GetContext(ctx, key)
+		if err != nil {
+			return resp, err
+		}
+		view = v
+	}
 	// 将获取到的缓存数据序列化为 protobuf 格式，并存储在响应对象的 Value 字段中
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Version: view.Version(), ServedBy: s.self})
 	if err != nil {
 		return resp, err
 	}
@@ -82,6 +434,96 @@ func (s *Server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	return resp, nil
 }
 
+// lookupGroup 返回远程请求的缓存组，不存在时尝试用 groupFactory 创建，仍然没有时返回 NotFound 状态码
+func (s *Server) lookupGroup(name string) (*Group, error) {
+	if g := GetGroup(name); g != nil {
+		return g, nil
+	}
+	if s.groupFactory != nil {
+		s.factoryMu.Lock()
+		defer s.factoryMu.Unlock()
+		if g := GetGroup(name); g != nil { // 等待锁期间已被其他请求创建
+			return g, nil
+		}
+		if g := s.groupFactory(name); g != nil {
+			log.Printf("[Geecache_svr %s] auto-registered group %s", s.self, name)
+			return g, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "group %s not found", name)
+}
+
+// Increment 实现了 GroupCache 的计数器接口，在本节点上原子地增加计数器
+func (s *Server) Increment(ctx context.Context, in *pb.IncrementRequest) (*pb.IncrementResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	log.Printf("[Geecache_svr %s] Recv Increment request %s/%s", s.self, group, key)
+	if s.isDraining() {
+		return nil, errDraining
+	}
+	g, err := s.lookupGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	n, err := g.Increment(key, in.GetDelta())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.IncrementResponse{Value: n}, nil
+}
+
+// Set 实现了 GroupCache 的写入接口，将数据保存在本节点上
+func (s *Server) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	log.Printf("[Geecache_svr %s] Recv Set request %s/%s", s.self, group, key)
+	if s.isDraining() {
+		return nil, errDraining
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	g, err := s.lookupGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	if in.GetHot() {
+		// 其他节点广播的热点副本，不属于本节点负责的数据
+		g.populateHotCache(key, ByteView{b: in.GetValue()})
+		return &pb.SetResponse{}, nil
+	}
+	g.setLocally(key, ByteView{b: in.GetValue()}, time.Duration(in.GetTtlMs())*time.Millisecond)
+	return &pb.SetResponse{}, nil
+}
+
+// GetMulti 实现了 GroupCache 的批量读取接口，并发获取请求中的每个key(并发数见 getEach)，
+// 数据源中不存在的key记录在响应的 not_found 中，其他错误记录在 errors 中
+func (s *Server) GetMulti(ctx context.Context, in *pb.MultiRequest) (*pb.MultiResponse, error) {
+	group := in.GetGroup()
+	log.Printf("[Geecache_svr %s] Recv GetMulti request %s, %d keys", s.self, group, len(in.GetKeys()))
+	if s.isDraining() {
+		return nil, errDraining
+	}
+	g, err := s.lookupGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	values, errs := g.getEach(ctx, in.GetKeys())
+	resp := &pb.MultiResponse{}
+	for key, v := range values {
+		resp.Entries = append(resp.Entries, &pb.Entry{Key: key, Value: v.ByteSlice()})
+	}
+	for key, err := range errs {
+		if errors.Is(err, ErrNotFound) {
+			resp.NotFound = append(resp.NotFound, key)
+			continue
+		}
+		if resp.Errors == nil {
+			resp.Errors = make(map[string]string, len(errs))
+		}
+		resp.Errors[key] = err.Error()
+	}
+	return resp, nil
+}
+
 // Start 方法负责启动缓存服务，监听指定端口，注册 gRPC 服务至服务器，并在接收到停止信号后关闭服务
 func (s *Server) Start() error {
 	s.mu.Lock()
@@ -96,67 +538,508 @@ func (s *Server) Start() error {
 	// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
 	// 5. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
 	//    获取服务Host地址 从而进行通信。这样的好处是client只需知道服务名
-	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中
+	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中，静态集群模式跳过这一步
 	// ----------------------------------------------
+	addr, err := listenAddr(s.self)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	lis, err := net.Listen("tcp", addr) //监听指定的 TCP 端口，用于接受客户端的 gRPC 请求
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to listen: %v", err)
+	}
+	d, releaseDiscovery, err := s.peerDiscovery()
+	if err != nil {
+		s.mu.Unlock()
+		lis.Close()
+		return fmt.Errorf("failed to create discovery: %v", err)
+	}
 	s.status = true
+	s.draining = false
+	s.grpcServer = nil
 	s.stopSignal = make(chan error)
+	s.deregistered = make(chan struct{})
 
-	port := strings.Split(s.self, ":")[1]
-	lis, err := net.Listen("tcp", ":"+port) //监听指定的 TCP 端口，用于接受客户端的 gRPC 请求
-	if err != nil {
-		return fmt.Errorf("failed to listen: %v", err)
+	s.mu.Unlock()
+	if d != nil {
+		s.resolvePeers(d)
+	}
+	// 预热完成之前不注册服务，其他节点不会把请求路由过来
+	s.warmUp()
+	s.mu.Lock()
+	if !s.status {
+		// 预热期间已被停止
+		deregistered := s.deregistered
+		s.mu.Unlock()
+		lis.Close()
+		releaseDiscovery()
+		close(deregistered)
+		return nil
 	}
-	grpcServer := grpc.NewServer()
+
+	grpcServer := s.newGRPCServer()
 	pb.RegisterGroupCacheServer(grpcServer, s)
+	s.health = health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, s.health)
+	s.grpcServer = grpcServer
 	//创建一个新的 gRPC 服务器 grpcServer，然后将当前的 Server 对象 s 注册为 gRPC 服务。
 	//这样，gRPC 服务器就能够处理来自客户端的请求。
 
+	// 在持有锁时取出，Stop 会关闭并清空 s.stopSignal
+	stopSignal, deregistered := s.stopSignal, s.deregistered
 	go func() {
-		// 注册服务至 etcd。该操作会一直阻塞，直到停止信号被接收。
-		//当停止信号被接收后，关闭通知通道 s.stopSignal，关闭 TCP 监听端口，并输出日志表示服务已经停止。
-		err := registry.Register("geecache", s.self, s.stopSignal)
+		// 注册服务至 etcd(或 WithDiscovery 设置的后端)。该操作会一直阻塞，直到 Stop 关闭 stopSignal。
+		//停止后关闭 TCP 监听端口，并输出日志表示服务已经停止。
+		var err error
+		if d == nil {
+			<-stopSignal // 静态集群和单节点无需注册，等待停止
+		} else {
+			err = d.Register("geecache", s.self, stopSignal)
+		}
+		releaseDiscovery()
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
-		// Close channel
-		close(s.stopSignal)
-		// Close tcp listen
+		// Close tcp listen，StopWithTimeout 超时后 grpc 可能已经关闭了监听
 		err = lis.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Fatalf(err.Error())
 		}
 		log.Printf("[%s] Revoke service and close tcp socket ok.", s.self)
+		close(deregistered)
 	}()
+	if d != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopWatch = cancel
+		go s.watchPeers(ctx, d)
+	}
 
 	s.mu.Unlock()
 
 	//启动 gRPC 服务器。grpcServer.Serve(lis) 会阻塞，处理客户端的 gRPC 请求，直到服务器关闭或发生错误。
 	//如果服务器状态为运行状态（s.status 为 true），并且发生了错误，则返回相应的错误。
-	if err := grpcServer.Serve(lis); s.status && err != nil {
-		return fmt.Errorf("failed to serve: %v", err)
+	if err := grpcServer.Serve(lis); err != nil {
+		s.mu.Lock()
+		running := s.status
+		s.mu.Unlock()
+		if running {
+			return fmt.Errorf("failed to serve: %v", err)
+		}
 	}
 	return nil
 }
 
-// Set 方法用于设置其他缓存节点的地址信息，并为每个节点创建相应的客户端连接
-func (s *Server) Set(peers ...string) {
+// peerDiscovery 返回本节点使用的节点注册与发现：静态集群和单节点模式为 nil，
+// 否则为 WithDiscovery 设置的后端，未设置时为 defaultDiscovery。用完后调用 release 释放
+func (s *Server) peerDiscovery() (d Discovery, release func(), err error) {
+	switch {
+	case s.static, s.singleNode:
+		return nil, func() {}, nil
+	case s.discovery != nil:
+		return s.discovery, func() {}, nil
+	}
+	return defaultDiscovery(etcdConfigOr(s.etcdConfig), s.metadata)
+}
+
+// resolvePeers 通过 d 获取当前的所有节点作为初始的集群成员，失败时保留原有的成员
+func (s *Server) resolvePeers(d Discovery) {
+	addrs, err := d.Resolve("geecache")
+	if err != nil {
+		log.Printf("[%s] resolve peers failed: %v", s.self, err)
+		return
+	}
+	s.setDiscoveredPeers(d, addrs)
+}
+
+// watchPeers 监听 d 的节点变化并更新集群成员，直到 ctx 被取消
+func (s *Server) watchPeers(ctx context.Context, d Discovery) {
+	ch, err := d.Watch(ctx, "geecache")
+	if err != nil {
+		log.Printf("[%s] watch peers failed: %v", s.self, err)
+		return
+	}
+	for addrs := range ch {
+		if ctx.Err() != nil {
+			return
+		}
+		s.setDiscoveredPeers(d, addrs)
+	}
+}
+
+// setDiscoveredPeers 将发现的节点设置为集群成员，尚未注册完成时发现的节点中可能没有本节点，需要补上。
+// d 能提供元数据时先同步元数据，使新的哈希环按节点的权重分配
+func (s *Server) setDiscoveredPeers(d Discovery, addrs []string) {
+	if md, ok := d.(MetadataDiscovery); ok {
+		if peerMeta, err := md.Metadata("geecache"); err != nil {
+			log.Printf("[%s] sync peer metadata failed: %v", s.self, err)
+		} else {
+			s.mu.Lock()
+			s.peerMeta = peerMeta
+			s.mu.Unlock()
+		}
+	}
+	peers := append([]string(nil), addrs...)
+	if !slices.Contains(peers, s.self) {
+		peers = append(peers, s.self)
+	}
+	s.SetPeers(peers...)
+}
+
+// newGRPCServer 创建 gRPC 服务器，设置了 TLS 配置时使用 TLS 加密
+func (s *Server) newGRPCServer() *grpc.Server {
+	if s.tlsConfig == nil {
+		return grpc.NewServer()
+	}
+	return grpc.NewServer(grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+}
+
+// Export 实现了 GroupCache 的流式导出接口，将 group 的 mainCache 中所有未过期数据发送给调用方
+func (s *Server) Export(in *pb.ExportRequest, stream pb.GroupCache_ExportServer) error {
+	log.Printf("[Geecache_svr %s] Recv Export request %s", s.self, in.GetGroup())
+	if s.isDraining() {
+		return errDraining
+	}
+	g, err := s.lookupGroup(in.GetGroup())
+	if err != nil {
+		return err
+	}
+	for _, e := range g.mainCache.entries() {
+		err := stream.Send(&pb.Entry{Key: e.Key, Value: e.Value.(ByteView).ByteSlice()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Events 实现了 GroupCache 的事件订阅接口，将 group 的缓存生命周期事件流式推送给调用方，直到调用方断开。
+// 调用方需要通过 WithEventsToken 设置的令牌认证；推送速率超过限制时多余的事件被丢弃，
+// 丢弃的数量记录在下一条推送的 dropped 中
+func (s *Server) Events(in *pb.EventsRequest, stream pb.GroupCache_EventsServer) error {
+	log.Printf("[Geecache_svr %s] Recv Events request %s", s.self, in.GetGroup())
+	if s.isDraining() {
+		return errDraining
+	}
+	if err := s.authorizeEvents(stream.Context()); err != nil {
+		return err
+	}
+	g, err := s.lookupGroup(in.GetGroup())
+	if err != nil {
+		return err
+	}
+	limit := uint64(in.GetMaxPerSecond())
+	if limit == 0 || limit > maxEventsPerSecond {
+		limit = maxEventsPerSecond
+	}
+	events, cancel := g.subscribeEvents()
+	defer cancel()
+
+	var sent, dropped uint64
+	window := time.Now()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-events:
+			if now := time.Now(); now.Sub(window) >= time.Second {
+				window, sent = now, 0
+			}
+			if sent >= limit {
+				dropped++
+				continue
+			}
+			sent++
+			err := stream.Send(&pb.Event{
+				Type:    ev.Type.String(),
+				Group:   ev.Group,
+				Key:     ev.Key,
+				Source:  ev.Source,
+				Dropped: dropped,
+			})
+			if err != nil {
+				return err
+			}
+			dropped = 0
+		}
+	}
+}
+
+// authorizeEvents 校验 Events 请求携带的令牌
+func (s *Server) authorizeEvents(ctx context.Context) error {
+	if s.eventsToken == "" {
+		return status.Error(codes.PermissionDenied, "events streaming is disabled")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+s.eventsToken)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid events token")
+}
+
+// Ready 返回一个在加入集群的预热完成后关闭的 channel，未开启 WithJoinWarming 时 Start 后立即关闭
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// IsReady 返回预热是否已经完成，可用于就绪探针，在完成之前不把节点加入负载均衡
+func (s *Server) IsReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WarmupProgress 描述加入集群时的预热进度，每个缓存组从每个其他节点的一次导出为一个任务
+type WarmupProgress struct {
+	Total  int // 任务总数，预热开始前为0
+	Done   int // 已结束的任务数，包括失败的任务
+	Errors int // 失败的任务数
+	Keys   int // 已导入的key数量
+}
+
+// Percent 返回已结束任务的百分比，没有任务时为100
+func (p WarmupProgress) Percent() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+// Warmup 返回当前的预热进度
+func (s *Server) Warmup() WarmupProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warmup
+}
+
+// finishWarmTask 记录一个预热任务结束，导入了 keys 个key，err 不为 nil 表示任务失败
+func (s *Server) finishWarmTask(keys int, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.peers = consistenthash.New(defaultReplicas, nil)
-	s.peers.Add(peers...)
+	s.warmup.Done++
+	s.warmup.Keys += keys
+	if err != nil {
+		s.warmup.Errors++
+	}
+}
+
+// warmUp 从其他节点拉取本节点负责的数据，单个节点失败只记录日志，不影响启动
+func (s *Server) warmUp() {
+	defer s.readyOnce.Do(func() { close(s.ready) })
+	s.mu.Lock()
+	clients := make(map[string]*Client, len(s.clients))
+	for addr, c := range s.clients {
+		if addr != s.self {
+			clients[addr] = c
+		}
+	}
+	s.warmup = WarmupProgress{Total: len(s.warmGroups) * len(clients)}
+	s.mu.Unlock()
+
+	for _, name := range s.warmGroups {
+		g := GetGroup(name)
+		if g == nil {
+			log.Printf("[%s] warm up: group %s not found", s.self, name)
+			for range clients {
+				s.finishWarmTask(0, ErrGroupNotFound)
+			}
+			continue
+		}
+		for addr, c := range clients {
+			n := 0
+			err := c.Export(name, func(e *pb.Entry) {
+				if s.importEntry(g, e) {
+					n++
+				}
+			})
+			s.finishWarmTask(n, err)
+			if err != nil {
+				log.Printf("[%s] warm up %s from %s failed: %v", s.self, name, addr, err)
+				continue
+			}
+			log.Printf("[%s] warm up %s: %d keys from %s", s.self, name, n, addr)
+		}
+	}
+}
+
+// importEntry 当 e 的 key 归本节点负责时将其写入 g 的 mainCache
+func (s *Server) importEntry(g *Group, e *pb.Entry) bool {
+	s.mu.Lock()
+	owner := s.peers.Get(e.GetKey())
+	s.mu.Unlock()
+	if owner != s.self {
+		return false
+	}
+	g.populateCache(e.GetKey(), ByteView{b: cloneBytes(e.GetValue())})
+	return true
+}
+
+// MigrateKey 将本节点 mainCache 中的 key 连同剩余的有效期通过 Set 推送给节点 toAddr，用于在调整集群成员之前
+// 把单个key提前交给新的负责节点，缩短成员变化后的未命中窗口。所有在本地缓存了 key 的缓存组都会迁移，
+// toAddr 可以尚未加入集群，本节点保留原有的副本。
+// 没有任何缓存组在本地缓存了 key 时返回包装了 ErrNotFound 的错误。
+// 开启 WithInvalidationBus 的缓存组迁移后不能用 Delete 删除本地副本：Delete 发布的失效通知会让 toAddr
+// 也删除刚迁移过去的数据，需要删除时使用 MigrateKeyAndEvict
+func (s *Server) MigrateKey(key, toAddr string) error {
+	return s.migrateKey(key, toAddr, false)
+}
+
+// MigrateKeyAndEvict 与 MigrateKey 相同，推送成功后用 Remove 从本节点删除该key，不会发布失效通知
+func (s *Server) MigrateKeyAndEvict(key, toAddr string) error {
+	return s.migrateKey(key, toAddr, true)
+}
+
+// migrateKey 实现 MigrateKey 和 MigrateKeyAndEvict，evict 为 true 时推送成功后从本节点删除该key
+func (s *Server) migrateKey(key, toAddr string, evict bool) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if toAddr == s.self {
+		return fmt.Errorf("cannot migrate %s to self", key)
+	}
+	s.mu.Lock()
+	client, ok := s.clients[toAddr]
+	if !ok {
+		// 目标节点还不在集群中，使用临时的连接
+		client = s.newClient(toAddr)
+		defer client.Close()
+	}
+	s.mu.Unlock()
+
+	mu.RLock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	mu.RUnlock()
+
+	migrated := 0
+	for _, g := range gs {
+		value, ok := g.mainCache.get(key)
+		if !ok {
+			continue
+		}
+		expire, _, ok := g.mainCache.expiry(key)
+		if !ok {
+			continue
+		}
+		ttl := time.Until(expire)
+		if ttl < time.Millisecond { // 即将过期，无需迁移
+			continue
+		}
+		req := &pb.SetRequest{Group: g.name, Key: key, Value: value.b, TtlMs: ttl.Milliseconds()}
+		if err := client.Set(req, &pb.SetResponse{}); err != nil {
+			return fmt.Errorf("migrate %s/%s to %s: %w", g.name, key, toAddr, err)
+		}
+		log.Printf("[%s] migrated %s/%s to %s, ttl %v", s.self, g.name, key, toAddr, ttl)
+		if evict {
+			g.Remove(key)
+		}
+		migrated++
+	}
+	if migrated == 0 {
+		return fmt.Errorf("migrate %s: %w", key, ErrNotFound)
+	}
+	return nil
+}
+
+// listenAddr 根据节点地址 self 得到监听地址，在所有网卡上监听 self 的端口
+// 使用 net.SplitHostPort 解析，因此 [::1]:8001 这样的 IPv6 地址同样适用
+func listenAddr(self string) (string, error) {
+	_, port, err := net.SplitHostPort(self)
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %q: %v", self, err)
+	}
+	return net.JoinHostPort("", port), nil
+}
+
+// SetPeers 方法用于设置其他缓存节点的地址信息，并为每个节点创建相应的客户端连接
+// 重复调用时一次性重建哈希环，仍在集群中的节点保留 SetPeerAvailable 设置的可用状态
+func (s *Server) SetPeers(peers ...string) {
+	if s.singleNode {
+		peers = []string{s.self} // 单节点模式忽略其他节点
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setRing(peers)
+	old := s.clients
 	s.clients = make(map[string]*Client, len(peers))
+	s.addrs = append([]string(nil), peers...)
+	sort.Strings(s.addrs)
 	for _, peerAddr := range peers {
-		service := fmt.Sprintf("geecache-%s", peerAddr)
-		s.clients[peerAddr] = NewClient(service) // 使用 NewClient(service) 函数创建一个新的客户端连接，并将连接对象存储在 s.clients 映射中，以便后续通过节点地址进行查找和通信
+		if client, ok := old[peerAddr]; ok { // 仍在集群中的节点复用已有的连接
+			s.clients[peerAddr] = client
+			delete(old, peerAddr)
+			continue
+		}
+		s.clients[peerAddr] = s.newClient(peerAddr) // 创建一个新的客户端连接，并将连接对象存储在 s.clients 映射中，以便后续通过节点地址进行查找和通信
+	}
+	closeClients(old) // 关闭已离开集群的节点的连接
+}
+
+// setRing 用 peers 重建哈希环，哈希环支持权重时按 peerMeta 中节点的 Weight 分配虚拟节点，调用方需持有 s.mu
+func (s *Server) setRing(peers []string) {
+	if s.peers == nil {
+		s.peers = s.newPicker()
+	}
+	wp, ok := s.peers.(consistenthash.WeightedPicker)
+	if !ok {
+		s.peers.Set(peers...)
+		return
+	}
+	weights := make(map[string]int)
+	for _, addr := range peers {
+		if md, ok := s.peerMeta[addr]; ok && md.Weight > 1 {
+			weights[addr] = md.Weight
+		}
+	}
+	wp.SetWeighted(peers, weights)
+}
+
+// newClient 创建连接节点 addr 的客户端，使用与本节点相同的etcd、TLS和连接配置
+func (s *Server) newClient(addr string) *Client {
+	client := NewClient(fmt.Sprintf("geecache-%s", addr))
+	client.etcdConfig = s.etcdConfig
+	client.tlsConfig = s.tlsConfig
+	client.dialOpts = s.dialOpts
+	client.static = s.static || s.discovery != nil
+	client.RPCTimeout = s.rpcTimeout
+	client.ResponseTTL = s.responseTTL
+	return client
+}
+
+// closeClients 关闭 clients 中所有客户端的连接
+func closeClients(clients map[string]*Client) {
+	for _, client := range clients {
+		client.Close()
 	}
 }
 
 // PickPeer 方法，用于根据给定的键选择相应的对等节点
 func (s *Server) PickPeer(key string) (PeerGetter, bool) {
+	if s.singleNode {
+		return nil, false
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.peers == nil { // 未 SetPeers 节点或服务已停止
+		return nil, false
+	}
 	peerAddr := s.peers.Get(key) //根据给定的键 key 选择相应的对等节点的地址 peerAddr
-	if peerAddr == s.self {      //如果选择的节点地址与当前服务器的地址相同，说明该节点就是当前服务器本身
+	if s.tracePick {
+		e := s.peers.Explain(key)
+		log.Printf("[cache %s] pick %q: hash=%d index=%d skipped=%v node=%q\n", s.self, key, e.Hash, e.Index, e.Skipped, e.Node)
+	}
+	if peerAddr == "" { //环上没有可用节点
+		return nil, false
+	}
+	if peerAddr == s.self { //如果选择的节点地址与当前服务器的地址相同，说明该节点就是当前服务器本身
 		log.Printf("ooh! pick myself, I am %s\n", s.self)
 		return nil, false
 	}
@@ -164,52 +1047,607 @@ func (s *Server) PickPeer(key string) (PeerGetter, bool) {
 	return s.clients[peerAddr], true //如果选择的节点不是当前服务器本身，日志会记录当前服务器选择了远程对等节点，并且函数会返回选择的对等节点的客户端连接（s.clients[peerAddr]）和 true，表示选择成功
 }
 
+// Broadcast 实现了 PeerBroadcaster，并发地(不超过 maxFanout 个)通过 Set 请求把热点数据推送给集群中除当前节点外的所有节点，
+// 接收方将其保存在 hotCache 中。返回所有推送失败的节点的错误
+func (s *Server) Broadcast(in *pb.Request, value []byte) error {
+	s.mu.Lock()
+	clients := make(map[string]*Client, len(s.clients))
+	for addr, c := range s.clients {
+		if addr != s.self {
+			clients[addr] = c
+		}
+	}
+	s.mu.Unlock()
+
+	req := &pb.SetRequest{Group: in.GetGroup(), Key: in.GetKey(), Value: value, Hot: true}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		pool = newWorkerPool(maxFanout)
+	)
+	for addr, c := range clients {
+		wg.Add(1)
+		pool.submit(func() {
+			defer wg.Done()
+			if err := c.Set(req, &pb.SetResponse{}); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("broadcast to %s: %w", addr, err))
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// LookupPeer 返回节点 addr 的客户端，addr 为当前节点或不在集群中时返回 false
+func (s *Server) LookupPeer(addr string) (PeerGetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[addr]
+	if !ok || addr == s.self {
+		return nil, false
+	}
+	return client, true
+}
+
+// PickPeerOrSelf 返回负责 key 的节点地址，与 PickPeer 不同，选中当前节点时不会隐藏，
+// 而是返回 s.self 和 true，适用于管理和副本工具。没有可用节点时返回空地址
+func (s *Server) PickPeerOrSelf(key string) (addr string, isSelf bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peers == nil {
+		return "", false
+	}
+	addr = s.peers.Get(key)
+	return addr, addr != "" && addr == s.self
+}
+
+// OwnershipMismatch 记录 Server 与另一个 PeerPicker 对某个key由哪个节点负责的不同判断，
+// 节点地址为当前节点时表示在本地加载
+type OwnershipMismatch struct {
+	Key    string
+	Server string // Server 选中的节点地址
+	Picker string // PeerPicker 选中的节点地址，无法对应到任何节点时为 PeerGetter 的类型
+}
+
+// CheckPicker 对 keys 中的每个key比较 picker 与 Server 选中的节点，返回所有不一致的key，
+// 用于在测试或启动时发现自定义 PeerPicker 与 Server 的节点配置不一致导致的错误路由
+func (s *Server) CheckPicker(picker PeerPicker, keys []string) []OwnershipMismatch {
+	var mismatches []OwnershipMismatch
+	for _, key := range keys {
+		want, _ := s.PickPeerOrSelf(key)
+		if want == "" { // 没有可用节点时在本地加载
+			want = s.self
+		}
+		got := s.self
+		if peer, ok := picker.PickPeer(key); ok {
+			got = peerAddr(peer)
+		}
+		if got != want {
+			mismatches = append(mismatches, OwnershipMismatch{Key: key, Server: want, Picker: got})
+		}
+	}
+	return mismatches
+}
+
+// peerAddr 返回 peer 对应的节点地址，peer 不是节点客户端 *Client 时返回其类型
+func peerAddr(peer PeerGetter) string {
+	if c, ok := peer.(*Client); ok && c != nil {
+		return c.addr()
+	}
+	return fmt.Sprintf("%T", peer)
+}
+
+// GetWithOwner 读取 group 中 key 的值，同时返回一致性哈希认定的负责节点地址，
+// 用于核对客户端的路由是否与服务端一致。值的获取与 Group.GetContext 相同(本地缓存、远程节点或数据源)。
+// 一个 Server 承载进程中所有的缓存组，因此需要指定 group，与远程请求一样通过 lookupGroup 查找或创建
+func (s *Server) GetWithOwner(ctx context.Context, group, key string) (ByteView, string, error) {
+	g, err := s.lookupGroup(group)
+	if err != nil {
+		return ByteView{}, "", err
+	}
+	owner, _ := s.PickPeerOrSelf(key)
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, owner, err
+	}
+	value, err := g.GetContext(ctx, key)
+	return value, owner, err
+}
+
+// GetWithReplicas 返回 key 的副本集合：哈希环选出的主节点，以及排序后的节点列表中紧随其后的 n-1 个节点。
+// 所有节点 Set 相同的节点列表时得到的副本集合一致
+func (s *Server) GetWithReplicas(key string, n int) ReplicaSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var set ReplicaSet
+	if s.peers == nil {
+		return set
+	}
+	owner := s.peers.Get(key)
+	if owner == "" {
+		return set
+	}
+	start := sort.SearchStrings(s.addrs, owner)
+	if n > len(s.addrs) {
+		n = len(s.addrs)
+	}
+	for i := 0; i < n; i++ {
+		addr := s.addrs[(start+i)%len(s.addrs)]
+		switch {
+		case addr == s.self:
+			set.Self = true
+		case addr == owner:
+			set.Primary = s.clients[addr]
+		default:
+			set.Replicas = append(set.Replicas, s.clients[addr])
+		}
+	}
+	return set
+}
+
+// PickExplanation 描述 Server 为某个 key 选择节点的过程
+type PickExplanation struct {
+	Key string
+	consistenthash.Explanation
+	Self bool // 选中的是否为当前节点，此时 PickPeer 返回 false 由本地加载
+}
+
+// ExplainPick 返回 PickPeer 选择 key 时的完整过程：key 的哈希值、命中的环下标(或桶编号)、
+// 被跳过的不可用节点以及最终节点，不会产生任何副作用
+func (s *Server) ExplainPick(key string) PickExplanation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := PickExplanation{Key: key, Explanation: consistenthash.Explanation{Index: -1}}
+	if s.peers == nil {
+		return e
+	}
+	e.Explanation = s.peers.Explain(key)
+	e.Self = e.Node == s.self
+	return e
+}
+
+// SetPeerAvailable 标记节点 addr 是否可用，不可用的节点仍保留在一致性哈希环上，
+// 但 PickPeer 会绕过它，适用于节点下线前的排空阶段。SetPeers 重建哈希环时仍在集群中的节点保留标记，
+// 节点离开集群后标记随之清除
+func (s *Server) SetPeerAvailable(addr string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers.SetAvailable(addr, ok)
+}
+
+// SyncPeerMetadata 从节点注册与发现后端读取所有已注册节点的元数据，之后可以通过 PeerMetadata 查询，
+// 并按其中的 Weight 重建哈希环。后端(默认的 registry.EtcdDiscovery 或 WithDiscovery 设置的)实现了
+// MetadataDiscovery 时，节点变化后会自动同步，无需手动调用
+func (s *Server) SyncPeerMetadata() error {
+	peerMeta, err := s.listPeerMetadata()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerMeta = peerMeta
+	if s.addrs != nil {
+		s.setRing(s.addrs)
+	}
+	return nil
+}
+
+// listPeerMetadata 从 peerDiscovery 读取所有已注册节点的元数据
+func (s *Server) listPeerMetadata() (map[string]registry.Metadata, error) {
+	d, release, err := s.peerDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	md, ok := d.(MetadataDiscovery)
+	if !ok {
+		return nil, fmt.Errorf("discovery %T does not provide peer metadata", d)
+	}
+	return md.Metadata("geecache")
+}
+
+// PeerMetadata 返回最近一次同步(见 SyncPeerMetadata)得到的节点 addr 的元数据
+func (s *Server) PeerMetadata(addr string) (registry.Metadata, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, ok := s.peerMeta[addr]
+	return md, ok
+}
+
 // Stop 停止server运行 如果server没有运行 这将是一个no-op
+// 从etcd注销后等待处理中的请求完成再返回。哈希环和客户端保留，其他 goroutine 仍可安全地调用 PickPeer，
+// 与其他节点的连接被关闭，之后的请求会重新建立连接；可以再次调用 Start 重新启动
 func (s *Server) Stop() {
 	s.mu.Lock()
 	if s.status == false {
 		s.mu.Unlock()
 		return
 	}
-	s.stopSignal <- nil // 发送停止keepalive信号
-	s.status = false    // 设置server运行状态为stop
-	s.clients = nil     // 清空一致性哈希信息 有助于垃圾回收
-	s.peers = nil       // 清空一致性哈希映射
+	s.status = false // 设置server运行状态为stop
+	s.signalStop()   // 停止keepalive，撤销租约
+	grpcServer, clients := s.grpcServer, s.clients
 	s.mu.Unlock()
+	if grpcServer != nil { // Start 仍在预热时还没有创建 grpc 服务器
+		grpcServer.GracefulStop()
+	}
+	closeClients(clients) // 释放与其他节点的连接
 }
 
-// Get 方法允许 Client 结构体实例向远程节点发送请求，获取缓存数据，并将响应解码为 pb.Response 结构体。
-func (c *Client) Get(in *pb.Request, out *pb.Response) error {
-	// 创建一个 etcd 客户端
-	cli, err := clientv3.New(defaultEtcdConfig)
-	if err != nil {
-		return err
+// signalStop 通知注册服务的 goroutine 停止keepalive，并停止监听节点变化，重复调用是安全的，调用方需持有 s.mu
+func (s *Server) signalStop() {
+	if s.stopSignal != nil {
+		close(s.stopSignal)
+		s.stopSignal = nil
+	}
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+}
+
+// StopWithTimeout 优雅地停止服务：先将健康状态置为 NOT_SERVING 并从etcd注销，此后拒绝新的远程请求，
+// 但本地的 Group.Get 仍然可以读取缓存，已经在处理中的请求和加载会继续完成。
+// 注销和排空最多等待 timeout，超时后强制关闭所有连接并返回错误
+func (s *Server) StopWithTimeout(timeout time.Duration) error {
+	s.mu.Lock()
+	if s.status == false {
+		s.mu.Unlock()
+		return nil
+	}
+	s.draining = true
+	if s.health != nil {
+		s.health.Shutdown() // 所有服务的健康状态置为 NOT_SERVING
+	}
+	grpcServer, deregistered := s.grpcServer, s.deregistered
+	s.signalStop() // 停止keepalive，撤销租约
+	s.status = false
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	var err error
+	select {
+	case <-deregistered:
+	case <-time.After(timeout):
+		err = fmt.Errorf("deregister timed out after %v", timeout)
+	}
+	// 等待处理中的请求完成，GracefulStop 不再接受新的连接和请求；预热期间停止时 gRPC 服务还未创建
+	if grpcServer != nil {
+		drained := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(drained)
+		}()
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-drained:
+		case <-timer.C:
+			// 注销用完了超时时间时计时器会立即触发，已经排空的不算超时
+			select {
+			case <-drained:
+			default:
+				grpcServer.Stop()
+				<-drained
+				err = fmt.Errorf("drain timed out after %v", timeout)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	clients := s.clients
+	s.mu.Unlock()
+	closeClients(clients)
+	return err
+}
+
+// PeerStates 返回每个远程节点最近一次请求时的连接状态，用于监控，不包括当前节点
+func (s *Server) PeerStates() map[string]connectivity.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]connectivity.State, len(s.clients))
+	for addr, client := range s.clients {
+		if addr != s.self {
+			states[addr] = client.State()
+		}
+	}
+	return states
+}
+
+// isDraining 返回服务是否正在优雅停止
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// dial 返回与远程节点的连接，首次调用时通过etcd发现远程节点并建立连接，之后复用同一个连接
+// 请求结束后调用返回的 done 记录连接的状态，供 State 查询。
+// 建立连接时不持有 c.mu，连接很慢时不会阻塞 Close(以及调用它的 Stop 和 SetPeers)
+func (c *Client) dial() (grpcClient pb.GroupCacheClient, done func(), err error) {
+	c.mu.Lock()
+	if c.conn != nil {
+		conn, stub := c.conn, c.stub
+		c.mu.Unlock()
+		return stub, func() { c.state.Store(int32(conn.GetState())) }, nil
 	}
-	defer cli.Close()
+	c.mu.Unlock()
 
 	//使用etcd客户端发现指定服务（g.baseURL）并建立连接（conn）。如果发现服务或建立连接失败，则返回错误
-	conn, err := registry.EtcdDial(cli, c.baseURL)
+	conn, release, err := c.connect()
+	if err != nil {
+		c.state.Store(int32(connectivity.TransientFailure))
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		// 并发的请求已经建立了连接，复用它
+		conn.Close()
+		release()
+	} else {
+		c.conn, c.release = conn, release
+		c.stub = pb.NewGroupCacheClient(conn)
+	}
+	conn = c.conn
+	return c.stub, func() { c.state.Store(int32(conn.GetState())) }, nil
+}
+
+// connect 建立与远程节点的连接，静态模式直接按地址连接，否则通过etcd发现
+func (c *Client) connect() (*grpc.ClientConn, func(), error) {
+	if !c.static {
+		return dialService(etcdConfigOr(c.etcdConfig), c.baseURL, c.credentials(), c.dialOpts...)
+	}
+	conn, err := dialStatic(c.addr(), c.credentials(), c.dialOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, func() {}, nil
+}
+
+// rpcContext 返回在 ctx 的基础上加上 RPCTimeout 超时的上下文，ctx 的截止时间更早时保持不变
+func (c *Client) rpcContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.RPCTimeout
+	if timeout <= 0 {
+		timeout = defaultRPCTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// credentials 返回连接远程节点使用的传输层凭证，未设置 TLS 配置时返回 nil
+// 未指定 ServerName 时使用远程节点的主机名校验证书
+func (c *Client) credentials() credentials.TransportCredentials {
+	if c.tlsConfig == nil {
+		return nil
+	}
+	cfg := c.tlsConfig
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(c.addr()); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return credentials.NewTLS(cfg)
+}
+
+// addr 返回远程节点的地址 ip:port
+func (c *Client) addr() string {
+	return strings.TrimPrefix(c.baseURL, "geecache-")
+}
+
+// Close 关闭与远程节点的连接并释放etcd客户端，之后的请求会重新建立连接
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.release()
+	c.conn, c.release, c.stub = nil, nil, nil
+	return err
+}
+
+// State 返回最近一次请求结束时连接的状态，尚未发送过请求时为 Idle
+func (c *Client) State() connectivity.State {
+	return connectivity.State(c.state.Load())
+}
+
+// Get 方法允许 Client 结构体实例向远程节点发送请求，获取缓存数据，并将响应解码为 pb.Response 结构体。
+func (c *Client) Get(in *pb.Request, out *pb.Response) error {
+	return c.GetContext(context.Background(), in, out)
+}
+
+// GetContext 与 Get 相同，ctx 被取消或到达截止时间时请求随之结束。
+// CacheOnly 请求询问的是远程节点当前是否缓存了该key，既不读取也不写入客户端响应缓存；
+// Refresh 请求不读取客户端响应缓存，返回的新值会覆盖其中的旧值
+func (c *Client) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	useCache := !in.GetCacheOnly()
+	if b, ok := c.cachedResponse(in.GetGroup(), in.GetKey()); ok && useCache && !in.GetRefresh() {
+		return proto.Unmarshal(b, out)
+	}
+	grpcClient, done, err := c.dial()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer done()
 
-	// 创建一个新的 gRPC 客户端，用于与远程节点通信
-	grpcClient := pb.NewGroupCacheClient(conn)
-	// 创建一个带有10s超时时间的上下文，并使用该上下文发送 gRPC 请求到远程节点
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// 在 ctx 的基础上设置超时时间，并使用该上下文发送 gRPC 请求到远程节点
+	ctx, cancel := c.rpcContext(ctx)
 	defer cancel()
 	response, err := grpcClient.Get(ctx, in)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return fmt.Errorf("reading response body: %w", peerError(err))
 	}
 	if err = proto.Unmarshal(response.GetValue(), out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
+	if useCache {
+		c.storeResponse(in.GetGroup(), in.GetKey(), response.GetValue())
+	}
 	return nil
 }
 
+// responseBytes 是客户端响应缓存中保存的编码后的 pb.Response
+type responseBytes []byte
+
+func (b responseBytes) Len() int {
+	return len(b)
+}
+
+// cachedResponse 返回客户端响应缓存中 group/key 未过期的响应，未开启或未命中时返回 false
+func (c *Client) cachedResponse(group, key string) ([]byte, bool) {
+	if c.ResponseTTL <= 0 {
+		return nil, false
+	}
+	c.respMu.Lock()
+	defer c.respMu.Unlock()
+	if c.responses == nil {
+		return nil, false
+	}
+	v, ok := c.responses.Get(group + "/" + key)
+	if !ok {
+		return nil, false
+	}
+	return v.(responseBytes), true
+}
+
+// storeResponse 将 group/key 的响应保存到客户端响应缓存
+func (c *Client) storeResponse(group, key string, b []byte) {
+	if c.ResponseTTL <= 0 {
+		return
+	}
+	c.respMu.Lock()
+	defer c.respMu.Unlock()
+	if c.responses == nil {
+		c.responses = lru.New(responseCacheBytes, nil, c.ResponseTTL)
+	}
+	c.responses.Add(group+"/"+key, responseBytes(b), c.ResponseTTL)
+}
+
+// forgetResponse 丢弃客户端响应缓存中 group/key 的响应
+func (c *Client) forgetResponse(group, key string) {
+	c.respMu.Lock()
+	defer c.respMu.Unlock()
+	if c.responses != nil {
+		c.responses.Remove(group + "/" + key)
+	}
+}
+
+// GetRaw 与 Get 相同，但直接返回远程节点解码后的完整响应，包括版本号和返回该值的节点等元数据
+func (c *Client) GetRaw(in *pb.Request) (*pb.Response, error) {
+	out := &pb.Response{}
+	if err := c.Get(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Increment 在远程节点上原子地增加计数器
+func (c *Client) Increment(in *pb.IncrementRequest, out *pb.IncrementResponse) error {
+	defer c.forgetResponse(in.GetGroup(), in.GetKey())
+	grpcClient, done, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := c.rpcContext(context.Background())
+	defer cancel()
+	response, err := grpcClient.Increment(ctx, in)
+	if err != nil {
+		return fmt.Errorf("increment: %w", peerError(err))
+	}
+	out.Value = response.GetValue()
+	return nil
+}
+
+// Set 将数据写入远程节点
+func (c *Client) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	defer c.forgetResponse(in.GetGroup(), in.GetKey())
+	grpcClient, done, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := c.rpcContext(context.Background())
+	defer cancel()
+	if _, err := grpcClient.Set(ctx, in); err != nil {
+		return fmt.Errorf("set: %w", peerError(err))
+	}
+	return nil
+}
+
+// GetMulti 一次从远程节点获取多个key，单个key不存在时记录在 out.NotFound 中，获取失败时记录在 out.Errors 中，不影响其他key
+func (c *Client) GetMulti(in *pb.MultiRequest, out *pb.MultiResponse) error {
+	grpcClient, done, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := c.rpcContext(context.Background())
+	defer cancel()
+	response, err := grpcClient.GetMulti(ctx, in)
+	if err != nil {
+		return fmt.Errorf("get multi: %w", peerError(err))
+	}
+	out.Entries, out.Errors, out.NotFound = response.GetEntries(), response.GetErrors(), response.GetNotFound()
+	return nil
+}
+
+// Export 从远程节点流式拉取 group 的全部缓存数据，每收到一条数据调用一次 fn
+func (c *Client) Export(group string, fn func(*pb.Entry)) error {
+	grpcClient, done, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	stream, err := grpcClient.Export(ctx, &pb.ExportRequest{Group: group})
+	if err != nil {
+		return err
+	}
+	return recvEntries(stream, fn)
+}
+
+// peerError 将远程节点返回的 NotFound 状态码转换为包装了 ErrGroupNotFound 的错误，其他错误原样返回
+func peerError(err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.NotFound {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, st.Message())
+	}
+	return err
+}
+
+// recvEntries 读取导出流直到结束
+func recvEntries(stream pb.GroupCache_ExportClient, fn func(*pb.Entry)) error {
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return peerError(err)
+		}
+		fn(e)
+	}
+}
+
 var _ PeerPicker = (*Server)(nil)
+var _ PeerBroadcaster = (*Server)(nil)
+var _ ReplicaPicker = (*Server)(nil)
+var _ PeerLookup = (*Server)(nil)
+var _ LoadLocker = (*registry.LoadLock)(nil)
+var _ InvalidationBus = (*registry.InvalidationBus)(nil)
+var _ MetadataDiscovery = (*registry.EtcdDiscovery)(nil)
 
 // 测试 Client 是否实现了 PeerGetter 接口
 var _ PeerGetter = (*Client)(nil)
+var _ PeerGetterContext = (*Client)(nil)
+var _ PeerIncrementer = (*Client)(nil)
+var _ PeerSetter = (*Client)(nil)
+var _ PeerMultiGetter = (*Client)(nil)
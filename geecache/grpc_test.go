@@ -0,0 +1,1706 @@
+package geecache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"geecache/consistenthash"
+	pb "geecache/proto"
+	"geecache/registry"
+	"hash/crc32"
+	"math/big"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// stubDial 在测试期间用 dial 替换 dialService，测试结束时恢复
+func stubDial(t *testing.T, dial func(clientv3.Config, string, credentials.TransportCredentials, ...grpc.DialOption) (*grpc.ClientConn, func(), error)) {
+	old := dialService
+	dialService = dial
+	t.Cleanup(func() { dialService = old })
+}
+
+// useDirectDial 让 Client 不经过etcd，直接连接服务名中的节点地址，未指定 creds 时不加密，测试结束时恢复
+func useDirectDial(t *testing.T) {
+	stubDial(t, func(cfg clientv3.Config, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, func(), error) {
+		if creds == nil {
+			creds = insecure.NewCredentials()
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+		conn, err := grpc.NewClient(strings.TrimPrefix(service, "geecache-"), opts...)
+		return conn, func() {}, err
+	})
+}
+
+// stubDiscovery 在测试期间用 d 替换默认的节点注册与发现，测试结束时恢复
+func stubDiscovery(t *testing.T, d Discovery) {
+	old := defaultDiscovery
+	defaultDiscovery = func(clientv3.Config, registry.Metadata) (Discovery, func(), error) {
+		return d, func() {}, nil
+	}
+	t.Cleanup(func() { defaultDiscovery = old })
+}
+
+// registerFunc 是只负责注册的 Discovery，Resolve 和 Watch 返回错误，集群成员保持 SetPeers 设置的节点
+type registerFunc func(service, addr string, stop chan error) error
+
+func (f registerFunc) Register(service, addr string, stop chan error) error {
+	return f(service, addr, stop)
+}
+
+func (f registerFunc) Watch(context.Context, string) (<-chan []string, error) {
+	return nil, errors.New("watch is not supported")
+}
+
+func (f registerFunc) Resolve(string) ([]string, error) {
+	return nil, errors.New("resolve is not supported")
+}
+
+func TestNewServerIPv6(t *testing.T) {
+	testCases := map[string]string{
+		"localhost:8001":    "localhost:8001",
+		"127.0.0.1:8001":    "127.0.0.1:8001",
+		"[::1]:8001":        "[::1]:8001",
+		"[fe80::1%lo]:8001": "[fe80::1%lo]:8001",
+	}
+	for self, want := range testCases {
+		s, err := NewServer(self)
+		if err != nil || s.self != want {
+			t.Errorf("NewServer(%q) self = %v, %v, want %s", self, s, err, want)
+		}
+	}
+
+	for _, self := range []string{"::1:8001", "localhost", "http://localhost:8001"} {
+		if _, err := NewServer(self); err == nil {
+			t.Errorf("NewServer(%q) should fail", self)
+		}
+	}
+}
+
+func TestListenAddrIPv6(t *testing.T) {
+	// 先找一个空闲端口
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	self := net.JoinHostPort("::1", strconv.Itoa(port))
+	addr, err := listenAddr(self)
+	if err != nil || addr != ":"+strconv.Itoa(port) {
+		t.Fatalf("listenAddr(%q) = %q, %v", self, addr, err)
+	}
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if got := lis.Addr().(*net.TCPAddr).Port; got != port {
+		t.Fatalf("bound port %d, want %d", got, port)
+	}
+}
+
+func TestServerJumpHash(t *testing.T) {
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+	s, _ := NewServer(addrs[0], WithJumpHash())
+	s.SetPeers(addrs...)
+	if _, ok := s.peers.(*consistenthash.Jump); !ok {
+		t.Fatalf("peers should use jump hash, got %T", s.peers)
+	}
+
+	jump := consistenthash.NewJump(nil)
+	jump.Add(addrs...)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		peer, ok := s.PickPeer(key)
+		owner := jump.Get(key)
+		if (owner == addrs[0]) == ok || (ok && peer != s.clients[owner]) {
+			t.Fatalf("PickPeer(%s) = %v, %v, owner %s", key, peer, ok, owner)
+		}
+	}
+}
+
+func TestServerJumpHashPeerOrder(t *testing.T) {
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001", "10.0.0.4:8001"}
+	// 发现服务把当前节点追加在末尾，各节点传入的顺序不同
+	s1, _ := NewServer(addrs[0], WithJumpHash())
+	s1.SetPeers(addrs[1], addrs[2], addrs[3], addrs[0])
+	s2, _ := NewServer(addrs[2], WithJumpHash())
+	s2.SetPeers(addrs[3], addrs[0], addrs[1], addrs[2])
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if o1, o2 := s1.peers.Get(key), s2.peers.Get(key); o1 != o2 {
+			t.Fatalf("owner of %s is %s on one server and %s on the other", key, o1, o2)
+		}
+	}
+}
+
+func TestExplainPick(t *testing.T) {
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+	s, _ := NewServer(addrs[0], WithPickTrace())
+	if e := s.ExplainPick("k"); e.Node != "" || e.Index != -1 {
+		t.Fatalf("explain before SetPeers = %+v", e)
+	}
+	s.SetPeers(addrs...)
+	s.SetPeerAvailable(addrs[1], false)
+
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(addrs...)
+	ring.SetAvailable(addrs[1], false)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		e := s.ExplainPick(key)
+		if e.Key != key || e.Node != ring.Get(key) || e.Self != (e.Node == addrs[0]) {
+			t.Fatalf("ExplainPick(%s) = %+v, ring picks %s", key, e, ring.Get(key))
+		}
+		if e.Hash != crc32.ChecksumIEEE([]byte(key)) || e.Index < 0 {
+			t.Fatalf("ExplainPick(%s) hash/index = %d/%d", key, e.Hash, e.Index)
+		}
+		for _, skipped := range e.Skipped {
+			if skipped != addrs[1] {
+				t.Fatalf("ExplainPick(%s) skipped available node %s", key, skipped)
+			}
+		}
+		peer, ok := s.PickPeer(key)
+		if ok == e.Self || (ok && peer != s.clients[e.Node]) {
+			t.Fatalf("PickPeer(%s) = %v, %v, explanation %+v", key, peer, ok, e)
+		}
+	}
+}
+
+func TestPickPeerOrSelf(t *testing.T) {
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001"}
+	s, _ := NewServer(addrs[0])
+	if addr, isSelf := s.PickPeerOrSelf("k"); addr != "" || isSelf {
+		t.Fatalf("PickPeerOrSelf before SetPeers = %s, %v", addr, isSelf)
+	}
+	s.SetPeers(addrs...)
+
+	var self, remote int
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		addr, isSelf := s.PickPeerOrSelf(key)
+		if addr != s.peers.Get(key) || isSelf != (addr == s.self) {
+			t.Fatalf("PickPeerOrSelf(%s) = %s, %v", key, addr, isSelf)
+		}
+		// PickPeer 只在远程节点负责时返回 true
+		if _, ok := s.PickPeer(key); ok == isSelf {
+			t.Fatalf("PickPeer(%s) = %v, PickPeerOrSelf isSelf = %v", key, ok, isSelf)
+		}
+		if isSelf {
+			self++
+		} else {
+			remote++
+		}
+	}
+	if self == 0 || remote == 0 {
+		t.Fatalf("keys should be owned by both nodes, self %d remote %d", self, remote)
+	}
+}
+
+func TestGetWithOwner(t *testing.T) {
+	NewGroup("with-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+	s, _ := NewServer(addrs[0])
+	s.SetPeers(addrs...)
+
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(addrs...)
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		v, owner, err := s.GetWithOwner(context.Background(), "with-owner", key)
+		if err != nil || v.String() != key+"-value" || owner != ring.Get(key) {
+			t.Fatalf("GetWithOwner(%s) = %q, %s, %v, ring owner %s", key, v.String(), owner, err, ring.Get(key))
+		}
+	}
+
+	if _, _, err := s.GetWithOwner(context.Background(), "no-such-group", "k"); err == nil {
+		t.Fatal("unknown group should fail")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, owner, err := s.GetWithOwner(ctx, "with-owner", "k"); err != context.Canceled || owner != ring.Get("k") {
+		t.Fatalf("cancelled GetWithOwner = %s, %v", owner, err)
+	}
+}
+
+func TestGetWithReplicas(t *testing.T) {
+	addrs := []string{"10.0.0.3:8001", "10.0.0.1:8001", "10.0.0.2:8001"}
+	s, _ := NewServer(addrs[0])
+	s.SetPeers(addrs...)
+	sorted := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		owner := s.peers.Get(key)
+		set := s.GetWithReplicas(key, 2)
+		// 副本为排序后紧随主节点的下一个节点
+		var next string
+		for j, addr := range sorted {
+			if addr == owner {
+				next = sorted[(j+1)%len(sorted)]
+			}
+		}
+		if owner == s.self {
+			if set.Primary != nil || !set.Self || len(set.Replicas) != 1 || set.Replicas[0] != s.clients[next] {
+				t.Fatalf("GetWithReplicas(%s) = %+v, owner is self", key, set)
+			}
+			continue
+		}
+		if set.Primary != s.clients[owner] || set.Self != (next == s.self) {
+			t.Fatalf("GetWithReplicas(%s) = %+v, owner %s", key, set, owner)
+		}
+		if !set.Self && (len(set.Replicas) != 1 || set.Replicas[0] != s.clients[next]) {
+			t.Fatalf("GetWithReplicas(%s) replicas = %v, want %s", key, set.Replicas, next)
+		}
+	}
+
+	// n 超过节点数时包含所有节点
+	set := s.GetWithReplicas("k", 10)
+	n := len(set.Replicas)
+	if set.Primary != nil {
+		n++
+	}
+	if !set.Self || n+1 != len(addrs) {
+		t.Fatalf("GetWithReplicas with large n = %+v", set)
+	}
+}
+
+func TestJoinWarming(t *testing.T) {
+	src := NewGroup("warm-src", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	for i := 0; i < 50; i++ {
+		src.Get(strconv.Itoa(i))
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, old)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	// 新节点加入后负责一部分键，从旧节点导出并只保留自己负责的数据
+	loads := 0
+	dst := NewGroup("warm-dst", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	joining, _ := NewServer("10.0.0.2:8001", WithJoinWarming("warm-dst"))
+	joining.SetPeers(old.self, joining.self)
+
+	conn, err := grpc.NewClient(old.self, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewGroupCacheClient(conn).Export(context.Background(), &pb.ExportRequest{Group: "warm-src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := 0
+	if err := recvEntries(stream, func(e *pb.Entry) {
+		if joining.importEntry(dst, e) {
+			imported++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if imported == 0 || imported == 50 {
+		t.Fatalf("joining node should take over part of the keys, imported %d", imported)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		_, cached := dst.mainCache.get(key)
+		if owns := joining.peers.Get(key) == joining.self; owns != cached {
+			t.Fatalf("key %s owned by joining node: %v, cached: %v", key, owns, cached)
+		}
+		if cached {
+			if v, _ := dst.Get(key); v.String() != key+"-value" {
+				t.Fatalf("warmed value of %s = %q", key, v.String())
+			}
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("warmed keys should not hit the source, got %d loads", loads)
+	}
+}
+
+func TestReadyWithoutWarming(t *testing.T) {
+	s, _ := NewServer("127.0.0.1:8001", WithJoinWarming("warm-missing"))
+	s.warmUp()
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("Ready should be closed after warm up")
+	}
+}
+
+func TestStopWithTimeoutDrain(t *testing.T) {
+	registered, deregistered := make(chan struct{}), make(chan struct{})
+	stubDiscovery(t, registerFunc(func(service, addr string, stop chan error) error {
+		close(registered)
+		<-stop
+		close(deregistered)
+		return nil
+	}))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("drain", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			close(started)
+			<-release
+		}
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	g.Get("k")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	s, _ := NewServer(addr)
+	g.RegisterPeers(s)
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+	<-registered
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGroupCacheClient(conn)
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := client.Get(context.Background(), &pb.Request{Group: "drain", Key: "slow"})
+		inflight <- err
+	}()
+	<-started
+
+	stopErr := make(chan error, 1)
+	go func() { stopErr <- s.StopWithTimeout(5 * time.Second) }()
+	<-deregistered
+
+	// 排空期间本地读取不受影响
+	if v, err := g.Get("k"); err != nil || v.String() != "k-value" {
+		t.Fatalf("cached read during drain = %v, %v", v, err)
+	}
+	res, err := s.health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || res.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("health during drain = %v, %v", res, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, &pb.Request{Group: "drain", Key: "k"}); err == nil {
+		t.Fatal("new peer RPCs should be refused during drain")
+	}
+
+	// 处理中的加载完成后服务才停止
+	close(release)
+	if err := <-inflight; err != nil {
+		t.Fatalf("in-flight RPC failed: %v", err)
+	}
+	if err := <-stopErr; err != nil {
+		t.Fatalf("StopWithTimeout = %v", err)
+	}
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start = %v", err)
+	}
+}
+
+// blockingExport 的 Export 阻塞到 release 关闭，用于让加入集群的预热停在中途
+type blockingExport struct {
+	pb.UnimplementedGroupCacheServer
+	started, release chan struct{}
+}
+
+func (b *blockingExport) Export(*pb.ExportRequest, pb.GroupCache_ExportServer) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestStopWithTimeoutDuringWarming(t *testing.T) {
+	NewGroup("stop-warming", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &blockingExport{started: make(chan struct{}), release: make(chan struct{})}
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+	s, _ := NewServer(addr, WithStaticCluster(), WithJoinWarming("stop-warming"))
+	s.SetPeers(lis.Addr().String(), addr)
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+	<-peer.started
+
+	// 预热期间 gRPC 服务还未创建，注销用完了超时时间也不能把排空算作超时
+	err = s.StopWithTimeout(50 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "deregister") {
+		t.Fatalf("StopWithTimeout = %v, want only the deregister timeout", err)
+	}
+	close(peer.release)
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start = %v", err)
+	}
+}
+
+func TestEventsRPC(t *testing.T) {
+	g := NewGroup("events-rpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewServer(lis.Addr().String(), WithEventsToken("secret"))
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(s.self, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGroupCacheClient(conn)
+
+	// 未携带令牌的订阅被拒绝
+	stream, err := client.Events(context.Background(), &pb.EventsRequest{Group: "events-rpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("unauthenticated subscribe = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret"))
+	defer cancel()
+	stream, err = client.Events(ctx, &pb.EventsRequest{Group: "events-rpc", MaxPerSecond: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, g, 1)
+
+	// 每秒只推送2条，其余3条被丢弃
+	for i := 0; i < 5; i++ {
+		g.Get("Tom")
+	}
+	expect := []*pb.Event{
+		{Type: "load", Group: "events-rpc", Key: "Tom", Source: "local"},
+		{Type: "hit", Group: "events-rpc", Key: "Tom", Source: "main"},
+	}
+	for _, want := range expect {
+		ev, err := stream.Recv()
+		if err != nil || !proto.Equal(ev, want) {
+			t.Fatalf("event = %v, %v, want %v", ev, err, want)
+		}
+	}
+	time.Sleep(time.Second)
+	g.Get("Tom")
+	ev, err := stream.Recv()
+	if err != nil || ev.GetType() != "hit" || ev.GetDropped() != 3 {
+		t.Fatalf("event after window = %v, %v, want hit with 3 dropped", ev, err)
+	}
+
+	// 断开后取消订阅
+	cancel()
+	waitSubscribers(t, g, 0)
+}
+
+// waitSubscribers 等待 g 的事件订阅者数量变为 n
+func waitSubscribers(t *testing.T, g *Group, n int) {
+	for i := 0; i < 100; i++ {
+		g.events.mu.RLock()
+		got := len(g.events.subscribers)
+		g.events.mu.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("group %s should have %d event subscribers", g.name, n)
+}
+
+func TestPeerStates(t *testing.T) {
+	NewGroup("peer-states", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	stubDial(t, func(cfg clientv3.Config, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, func(), error) {
+		if !reflect.DeepEqual(cfg.Endpoints, []string{"etcd-1:2379", "etcd-2:2379"}) {
+			t.Errorf("dial with etcd endpoints %v", cfg.Endpoints)
+		}
+		conn, err := grpc.NewClient(strings.TrimPrefix(service, "geecache-"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		return conn, func() {}, err
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := lis.Addr().String()
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, &Server{self: up})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	lis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := lis.Addr().String()
+	lis.Close()
+
+	s, _ := NewServer("127.0.0.1:1", WithEtcdConfig(clientv3.Config{Endpoints: []string{"etcd-1:2379", "etcd-2:2379"}}))
+	s.SetPeers(s.self, up, down)
+	want := map[string]connectivity.State{up: connectivity.Idle, down: connectivity.Idle}
+	if got := s.PeerStates(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("PeerStates before any request = %v", got)
+	}
+
+	if err := s.clients[up].Get(&pb.Request{Group: "peer-states", Key: "k"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.clients[down].Get(&pb.Request{Group: "peer-states", Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatal("Get from unreachable peer should fail")
+	}
+	want = map[string]connectivity.State{up: connectivity.Ready, down: connectivity.TransientFailure}
+	if got := s.PeerStates(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("PeerStates = %v, want %v", got, want)
+	}
+}
+
+func TestClientReusesConn(t *testing.T) {
+	NewGroup("reuse-conn", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	dials, releases := 0, 0
+	stubDial(t, func(cfg clientv3.Config, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, func(), error) {
+		dials++
+		conn, err := grpc.NewClient(strings.TrimPrefix(service, "geecache-"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		return conn, func() { releases++ }, err
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := lis.Addr().String()
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, &Server{self: peer})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, _ := NewServer("127.0.0.1:1")
+	s.status = true
+	s.stopSignal = make(chan error, 1)
+	s.SetPeers(s.self, peer)
+	client := s.clients[peer]
+	for i := 0; i < 10; i++ {
+		if err := client.Get(&pb.Request{Group: "reuse-conn", Key: "k"}, &pb.Response{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dials != 1 || releases != 0 {
+		t.Fatalf("10 Gets dialed %d times, released %d times", dials, releases)
+	}
+
+	// 节点仍在集群中时 Set 复用已有的客户端
+	s.SetPeers(s.self, peer, "127.0.0.1:2")
+	if s.clients[peer] != client {
+		t.Fatal("Set should keep the client of a remaining peer")
+	}
+	s.Stop()
+	if releases != 1 {
+		t.Fatalf("Stop released %d connections, want 1", releases)
+	}
+	if err := client.Close(); err != nil || releases != 1 {
+		t.Fatalf("second Close = %v, released %d times", err, releases)
+	}
+}
+
+func TestClientCloseDuringDial(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	stubDial(t, func(cfg clientv3.Config, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, func(), error) {
+		close(dialing)
+		<-release // 模拟一直连不上的节点
+		return nil, nil, errors.New("unreachable")
+	})
+
+	client := NewClient("geecache-127.0.0.1:1")
+	getErr := make(chan error, 1)
+	go func() { getErr <- client.Get(&pb.Request{Group: "g", Key: "k"}, &pb.Response{}) }()
+	<-dialing
+
+	// 建立连接期间 Close 不会被阻塞
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close should not wait for a pending dial")
+	}
+	close(release)
+	if err := <-getErr; err == nil {
+		t.Fatal("Get to an unreachable peer should fail")
+	}
+}
+
+func TestSharedEtcdClientKey(t *testing.T) {
+	// 不设置 Username，避免创建客户端时向不存在的etcd认证
+	cfg := clientv3.Config{Endpoints: []string{"127.0.0.1:1"}, Password: "a"}
+	cli1, release1, err := sharedEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release1()
+	cli2, release2, err := sharedEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release2()
+	if cli1 != cli2 {
+		t.Fatal("identical configs should share a client")
+	}
+
+	// 地址相同但密码或TLS配置不同时不能共享
+	for name, other := range map[string]clientv3.Config{
+		"password": {Endpoints: cfg.Endpoints, Password: "b"},
+		"tls":      {Endpoints: cfg.Endpoints, Password: "a", TLS: &tls.Config{}},
+	} {
+		cli, release, err := sharedEtcdClient(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cli == cli1 {
+			t.Errorf("config with a different %s should not share the client", name)
+		}
+		release()
+	}
+}
+
+// selfSignedTLS 生成一个 127.0.0.1 的自签名证书，返回同时用于服务端和客户端的TLS配置
+func selfSignedTLS(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geecache"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      pool,
+	}
+}
+
+func TestTLS(t *testing.T) {
+	NewGroup("tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := selfSignedTLS(t)
+	peer, _ := NewServer(lis.Addr().String(), WithTLSConfig(tlsConfig))
+	grpcServer := peer.newGRPCServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, _ := NewServer("127.0.0.1:1", WithTLSConfig(tlsConfig))
+	s.SetPeers(s.self, peer.self)
+	out := &pb.Response{}
+	if err := s.clients[peer.self].Get(&pb.Request{Group: "tls", Key: "k"}, out); err != nil || string(out.GetValue()) != "k-value" {
+		t.Fatalf("Get over TLS = %q, %v", out.GetValue(), err)
+	}
+
+	// 不加密的客户端无法与开启了TLS的节点通信
+	plain, _ := NewServer("127.0.0.1:2")
+	plain.SetPeers(plain.self, peer.self)
+	if err := plain.clients[peer.self].Get(&pb.Request{Group: "tls", Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatal("plaintext Get from TLS peer should fail")
+	}
+}
+
+func TestCheckPicker(t *testing.T) {
+	addrs := []string{"10.0.0.1:8001", "10.0.0.2:8001", "10.0.0.3:8001"}
+	s, _ := NewServer(addrs[0])
+	s.SetPeers(addrs...)
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	if got := s.CheckPicker(s, keys); len(got) != 0 {
+		t.Fatalf("Server should agree with itself, got %v", got)
+	}
+
+	// 漏掉一个节点的 picker 会把该节点的key分给其他节点
+	other, _ := NewServer(addrs[0])
+	other.SetPeers(addrs[:2]...)
+	got := s.CheckPicker(other, keys)
+	if len(got) == 0 {
+		t.Fatal("mismatched picker should be reported")
+	}
+	for _, m := range got {
+		owner, _ := s.PickPeerOrSelf(m.Key)
+		picked, _ := other.PickPeerOrSelf(m.Key)
+		if m.Server != owner || m.Picker != picked || m.Server == m.Picker {
+			t.Fatalf("mismatch %+v, server owner %s, picker owner %s", m, owner, picked)
+		}
+	}
+	for _, key := range keys {
+		owner, _ := s.PickPeerOrSelf(key)
+		picked, _ := other.PickPeerOrSelf(key)
+		if owner != picked && !containsMismatch(got, key) {
+			t.Fatalf("key %s owned by %s and %s is not reported", key, owner, picked)
+		}
+	}
+
+	// 无法识别的 PeerGetter 按类型报告
+	got = s.CheckPicker(&fakePeers{}, keys)
+	if len(got) != len(keys) {
+		t.Fatalf("picker routing every key remotely should mismatch all keys, got %d", len(got))
+	}
+	for _, m := range got {
+		if m.Picker != "geecache.fakePeer" {
+			t.Fatalf("unknown peer reported as %+v", m)
+		}
+	}
+}
+
+func containsMismatch(ms []OwnershipMismatch, key string) bool {
+	for _, m := range ms {
+		if m.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetRPC(t *testing.T) {
+	owner := NewGroup("set-rpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithTTL(time.Hour))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	client := NewClient("geecache-" + peer.self)
+	defer client.Close()
+	if err := client.Set(&pb.SetRequest{Group: "set-rpc", Key: "k", Value: []byte("v"), TtlMs: 60000}, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ttl, err := owner.GetWithTTL("k"); err != nil || v.String() != "v" || ttl > 2*time.Minute {
+		t.Fatalf("owner get = %q, %v, %v", v.String(), ttl, err)
+	}
+	if err := client.Set(&pb.SetRequest{Group: "no-such-group", Key: "k"}, &pb.SetResponse{}); err == nil {
+		t.Fatal("unknown group should fail")
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	NewGroup("get-raw", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	client := NewClient("geecache-" + peer.self)
+	defer client.Close()
+	res, err := client.GetRaw(&pb.Request{Group: "get-raw", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ByteView{b: []byte("k-value")}
+	if string(res.GetValue()) != want.String() || res.GetVersion() != want.Version() || res.GetServedBy() != peer.self {
+		t.Fatalf("GetRaw = %v", res)
+	}
+	if _, err := client.GetRaw(&pb.Request{Group: "no-such-group", Key: "k"}); err == nil {
+		t.Fatal("unknown group should fail")
+	}
+}
+
+func TestGroupNotFound(t *testing.T) {
+	useDirectDial(t)
+	serve := func(opts ...ServerOption) *Client {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := NewServer(lis.Addr().String(), opts...)
+		grpcServer := grpc.NewServer()
+		pb.RegisterGroupCacheServer(grpcServer, s)
+		go grpcServer.Serve(lis)
+		t.Cleanup(grpcServer.Stop)
+		client := NewClient("geecache-" + s.self)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	// 默认返回可以区分的 ErrGroupNotFound
+	client := serve()
+	err := client.Get(&pb.Request{Group: "not-found-default", Key: "k"}, &pb.Response{})
+	if !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("Get from missing group = %v", err)
+	}
+	if err := client.Increment(&pb.IncrementRequest{Group: "not-found-default", Key: "k"}, &pb.IncrementResponse{}); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("Increment on missing group = %v", err)
+	}
+	if err := client.Export("not-found-default", func(*pb.Entry) {}); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("Export of missing group = %v", err)
+	}
+
+	// 开启 WithGroupFactory 时自动创建认识的缓存组
+	created := 0
+	client = serve(WithGroupFactory(func(name string) *Group {
+		if name != "not-found-auto" {
+			return nil
+		}
+		created++
+		return NewGroup(name, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key + "-value"), nil
+		}), WithTTL(time.Hour))
+	}))
+	for i := 0; i < 2; i++ {
+		out := &pb.Response{}
+		if err := client.Get(&pb.Request{Group: "not-found-auto", Key: "k"}, out); err != nil || string(out.GetValue()) != "k-value" {
+			t.Fatalf("Get from auto-registered group = %q, %v", out.GetValue(), err)
+		}
+	}
+	if created != 1 || GetGroup("not-found-auto") == nil {
+		t.Fatalf("factory created the group %d times", created)
+	}
+	err = client.Get(&pb.Request{Group: "not-found-unknown", Key: "k"}, &pb.Response{})
+	if !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("Get from group unknown to the factory = %v", err)
+	}
+}
+
+func TestWarmupProgress(t *testing.T) {
+	g := NewGroup("warm-progress", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	for i := 0; i < 20; i++ {
+		g.Get(strconv.Itoa(i))
+	}
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, up)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	lis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := lis.Addr().String()
+	lis.Close()
+
+	joining, _ := NewServer("127.0.0.1:1", WithJoinWarming("warm-progress", "warm-progress-missing"))
+	joining.SetPeers(up.self, down, joining.self)
+	if joining.IsReady() || joining.Warmup().Total != 0 {
+		t.Fatalf("ready = %v, progress = %+v before warming", joining.IsReady(), joining.Warmup())
+	}
+	joining.warmUp()
+
+	// 每个缓存组从两个节点各导出一次，不可达的节点和不存在的缓存组记为失败
+	p := joining.Warmup()
+	if p.Total != 4 || p.Done != 4 || p.Errors != 3 || p.Percent() != 100 {
+		t.Fatalf("progress after warming = %+v", p)
+	}
+	owned := 0
+	for i := 0; i < 20; i++ {
+		if joining.peers.Get(strconv.Itoa(i)) == joining.self {
+			owned++
+		}
+	}
+	if p.Keys != owned {
+		t.Fatalf("imported %d keys, joining node owns %d", p.Keys, owned)
+	}
+	if !joining.IsReady() {
+		t.Fatal("server should be ready after warming")
+	}
+}
+
+func TestGetMultiRPC(t *testing.T) {
+	NewGroup("get-multi-rpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "missing":
+			return nil, ErrNotFound
+		case "broken":
+			return nil, errors.New("source unavailable")
+		}
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	client := NewClient("geecache-" + peer.self)
+	defer client.Close()
+	out := &pb.MultiResponse{}
+	if err := client.GetMulti(&pb.MultiRequest{Group: "get-multi-rpc", Keys: []string{"a", "b", "missing", "broken", "a"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, e := range out.GetEntries() {
+		got[e.GetKey()] = string(e.GetValue())
+	}
+	if len(got) != 2 || got["a"] != "a-value" || got["b"] != "b-value" {
+		t.Fatalf("entries = %v", got)
+	}
+	// 不存在的key与其他失败分开返回
+	if len(out.GetErrors()) != 1 || out.GetErrors()["broken"] == "" {
+		t.Fatalf("errors = %v", out.GetErrors())
+	}
+	if nf := out.GetNotFound(); len(nf) != 1 || nf[0] != "missing" {
+		t.Fatalf("not found = %v", nf)
+	}
+	if err := client.GetMulti(&pb.MultiRequest{Group: "no-such-group", Keys: []string{"a"}}, &pb.MultiResponse{}); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("unknown group error = %v", err)
+	}
+}
+
+func TestStartStopRepeatedly(t *testing.T) {
+	registered := make(chan struct{}, 1)
+	stubDiscovery(t, registerFunc(func(service, addr string, stop chan error) error {
+		registered <- struct{}{}
+		<-stop
+		return nil
+	}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	s, _ := NewServer(addr)
+	s.SetPeers(addr, "127.0.0.1:1")
+	s.Stop() // 未启动时是 no-op
+
+	for i := 0; i < 5; i++ {
+		startErr := make(chan error, 1)
+		go func() { startErr <- s.Start() }()
+		<-registered
+
+		// 停止期间其他 goroutine 仍在选择节点
+		picking := make(chan struct{})
+		go func() {
+			defer close(picking)
+			for j := 0; j < 100; j++ {
+				s.PickPeer(strconv.Itoa(j))
+			}
+		}()
+		done := make(chan struct{})
+		go func() {
+			s.Stop()
+			s.Stop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Stop %d deadlocked", i)
+		}
+		<-picking
+		if err := <-startErr; err != nil {
+			t.Fatalf("Start %d returned %v", i, err)
+		}
+	}
+	if s.peers == nil || len(s.clients) == 0 {
+		t.Fatal("Stop should keep the hash ring and clients")
+	}
+}
+
+func TestWithDialOptions(t *testing.T) {
+	NewGroup("dial-options", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	var methods []string
+	s, _ := NewServer("127.0.0.1:1", WithDialOptions(grpc.WithChainUnaryInterceptor(
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			methods = append(methods, method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})))
+	s.SetPeers(peer.self)
+	defer s.Stop()
+	if err := s.clients[peer.self].Get(&pb.Request{Group: "dial-options", Key: "k"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 1 || !strings.HasSuffix(methods[0], "/Get") {
+		t.Fatalf("interceptor saw %v", methods)
+	}
+}
+
+func TestSingleNodeCluster(t *testing.T) {
+	stubDiscovery(t, registerFunc(func(service, addr string, stop chan error) error {
+		t.Error("single node should not register to etcd")
+		return nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	s, _ := NewServer(addr, WithSingleNodeCluster(), WithDiscovery(newMemDiscovery()))
+	s.SetPeers("127.0.0.1:1", addr)
+	if got := s.addrs; !reflect.DeepEqual(got, []string{addr}) {
+		t.Fatalf("peers = %v, want only self", got)
+	}
+	if peer, ok := s.PickPeer("key"); ok || peer != nil {
+		t.Fatalf("PickPeer = %v, %v, want no remote peer", peer, ok)
+	}
+	if owner, isSelf := s.PickPeerOrSelf("key"); owner != addr || !isSelf {
+		t.Fatalf("PickPeerOrSelf = %q, %v", owner, isSelf)
+	}
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+	<-s.Ready()
+	s.Stop()
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+}
+
+func TestStaticCluster(t *testing.T) {
+	stubDiscovery(t, registerFunc(func(service, addr string, stop chan error) error {
+		t.Error("static cluster should not register to etcd")
+		return nil
+	}))
+	stubDial(t, func(clientv3.Config, string, credentials.TransportCredentials, ...grpc.DialOption) (*grpc.ClientConn, func(), error) {
+		t.Error("static cluster should not discover peers through etcd")
+		return nil, nil, errors.New("etcd is not running")
+	})
+
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, lis.Addr().String())
+		lis.Close()
+	}
+	var nodes []*Server
+	for _, addr := range addrs {
+		addr := addr
+		s, _ := NewServer(addr, WithStaticCluster())
+		s.SetPeers(addrs...)
+		startErr := make(chan error, 1)
+		go func() { startErr <- s.Start() }()
+		defer func() {
+			s.Stop()
+			if err := <-startErr; err != nil {
+				t.Errorf("Start %s returned %v", addr, err)
+			}
+		}()
+		nodes = append(nodes, s)
+	}
+
+	NewGroup("static-cluster", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	// 找到一个由第二个节点负责的key，从第一个节点直接连接第二个节点读取
+	var remote string
+	for i := 0; remote == ""; i++ {
+		key := strconv.Itoa(i)
+		if p := nodes[0].peers.Get(key); p == addrs[1] {
+			remote = key
+		}
+	}
+	peer, ok := nodes[0].PickPeer(remote)
+	if !ok {
+		t.Fatalf("%s should be picked for %s", addrs[1], remote)
+	}
+	var resp pb.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := peer.(*Client).Get(&pb.Request{Group: "static-cluster", Key: remote}, &resp)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get from %s: %v", addrs[1], err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(resp.GetValue()) != remote {
+		t.Fatalf("Get = %q, want %q", resp.GetValue(), remote)
+	}
+}
+
+// memDiscovery 是保存在内存中的 Discovery，用于测试由注册与发现驱动的集群成员变化
+type memDiscovery struct {
+	mu       sync.Mutex
+	addrs    map[string]bool
+	watchers []chan []string
+}
+
+func newMemDiscovery() *memDiscovery {
+	return &memDiscovery{addrs: make(map[string]bool)}
+}
+
+func (d *memDiscovery) Register(service, addr string, stop chan error) error {
+	d.update(addr, true)
+	<-stop
+	d.update(addr, false)
+	return nil
+}
+
+func (d *memDiscovery) Watch(ctx context.Context, service string) (<-chan []string, error) {
+	d.mu.Lock()
+	ch := make(chan []string, 100)
+	ch <- d.list()
+	d.watchers = append(d.watchers, ch)
+	d.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for i, w := range d.watchers {
+			if w == ch {
+				d.watchers = append(d.watchers[:i], d.watchers[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (d *memDiscovery) Resolve(service string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.list(), nil
+}
+
+func (d *memDiscovery) update(addr string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ok {
+		d.addrs[addr] = true
+	} else {
+		delete(d.addrs, addr)
+	}
+	for _, w := range d.watchers {
+		w <- d.list()
+	}
+}
+
+// list 返回排序后的节点地址，调用方需持有 d.mu
+func (d *memDiscovery) list() []string {
+	addrs := make([]string, 0, len(d.addrs))
+	for addr := range d.addrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// metaDiscovery 是还能提供节点元数据的 memDiscovery
+type metaDiscovery struct {
+	*memDiscovery
+	md map[string]registry.Metadata
+}
+
+func (d *metaDiscovery) Metadata(service string) (map[string]registry.Metadata, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.md, nil
+}
+
+func TestDiscoveryPeerWeights(t *testing.T) {
+	d := &metaDiscovery{memDiscovery: newMemDiscovery(), md: map[string]registry.Metadata{
+		"127.0.0.1:2": {Weight: 9},
+	}}
+	s, _ := NewServer("127.0.0.1:1", WithDiscovery(d))
+	defer s.Stop()
+	// owned 返回 127.0.0.1:2 负责的key占的比例
+	owned := func() float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		n := 0
+		for i := 0; i < 2000; i++ {
+			if s.peers.Get(strconv.Itoa(i)) == "127.0.0.1:2" {
+				n++
+			}
+		}
+		return float64(n) / 2000
+	}
+
+	// 节点变化时同步元数据，按权重分配哈希环
+	s.setDiscoveredPeers(d, []string{"127.0.0.1:2"})
+	if md, ok := s.PeerMetadata("127.0.0.1:2"); !ok || md.Weight != 9 {
+		t.Fatalf("PeerMetadata = %+v, %v", md, ok)
+	}
+	if r := owned(); r < 0.75 {
+		t.Fatalf("weighted peer owns %.2f of the keys", r)
+	}
+
+	// 手动同步后按新的权重重建哈希环
+	d.mu.Lock()
+	d.md = map[string]registry.Metadata{"127.0.0.1:1": {Weight: 9}}
+	d.mu.Unlock()
+	if err := s.SyncPeerMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if r := owned(); r > 0.25 {
+		t.Fatalf("after reweighting the peer owns %.2f of the keys", r)
+	}
+}
+
+func TestDefaultDiscovery(t *testing.T) {
+	d := newMemDiscovery()
+	stubDiscovery(t, d)
+	d.update("127.0.0.1:1", true)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	// 未设置 WithDiscovery 时同样由默认的注册与发现驱动集群成员
+	s, _ := NewServer(addr)
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, peer := s.LookupPeer("127.0.0.1:1")
+		if list, _ := d.Resolve("geecache"); peer && slices.Contains(list, addr) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("default discovery should register the node and resolve its peers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.StopWithTimeout(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	if list, _ := d.Resolve("geecache"); slices.Contains(list, addr) {
+		t.Fatal("Stop should deregister the node")
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	stubDiscovery(t, registerFunc(func(service, addr string, stop chan error) error {
+		t.Error("custom discovery should replace etcd registration")
+		return nil
+	}))
+	NewGroup("discovery", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	d := newMemDiscovery()
+	var addrs []string
+	var nodes []*Server
+	var startErrs []chan error
+	for i := 0; i < 2; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, lis.Addr().String())
+		lis.Close()
+		s, _ := NewServer(addrs[i], WithDiscovery(d))
+		startErr := make(chan error, 1)
+		go func() { startErr <- s.Start() }()
+		nodes = append(nodes, s)
+		startErrs = append(startErrs, startErr)
+	}
+	a, b := nodes[0], nodes[1]
+	defer a.Stop()
+
+	// waitPeer 等待 s 对节点 addr 的成员关系变为 want
+	waitPeer := func(s *Server, addr string, want bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, ok := s.LookupPeer(addr); ok == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: peer %s in cluster should be %v", s.self, addr, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitPeer(a, b.self, true)
+	waitPeer(b, a.self, true)
+
+	// 找到一个由 b 负责的key，从 a 直接连接 b 读取
+	var remote string
+	for i := 0; remote == ""; i++ {
+		key := strconv.Itoa(i)
+		if peer, ok := a.PickPeer(key); ok && peer.(*Client).addr() == b.self {
+			remote = key
+		}
+	}
+	peer, _ := a.LookupPeer(b.self)
+	var resp pb.Response
+	if err := peer.(*Client).Get(&pb.Request{Group: "discovery", Key: remote}, &resp); err != nil || string(resp.GetValue()) != remote {
+		t.Fatalf("Get from %s = %q, %v", b.self, resp.GetValue(), err)
+	}
+
+	// b 停止后注销，a 通过 Watch 得知并将其移出集群
+	b.Stop()
+	if err := <-startErrs[1]; err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	waitPeer(a, b.self, false)
+	if _, ok := a.PickPeer(remote); ok {
+		t.Fatalf("%s should be picked locally after %s left", remote, b.self)
+	}
+}
+
+func TestRPCTimeout(t *testing.T) {
+	NewGroup("rpc-timeout", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(300 * time.Millisecond)
+		return []byte(key), nil
+	}))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, _ := NewServer("127.0.0.1:1", WithRPCTimeout(50*time.Millisecond))
+	s.SetPeers(peer.self)
+	defer s.Stop()
+	client := s.clients[peer.self]
+	if client.RPCTimeout != 50*time.Millisecond {
+		t.Fatalf("RPCTimeout = %v, want the server setting", client.RPCTimeout)
+	}
+	start := time.Now()
+	err = client.Get(&pb.Request{Group: "rpc-timeout", Key: "a"}, &pb.Response{})
+	if status.Code(errors.Unwrap(err)) != codes.DeadlineExceeded || time.Since(start) > 250*time.Millisecond {
+		t.Fatalf("Get = %v after %v, want to fail fast", err, time.Since(start))
+	}
+
+	// 调用方的截止时间更早时以调用方为准
+	client.RPCTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = client.GetContext(ctx, &pb.Request{Group: "rpc-timeout", Key: "b"}, &pb.Response{})
+	if status.Code(errors.Unwrap(err)) != codes.DeadlineExceeded || time.Since(start) > 250*time.Millisecond {
+		t.Fatalf("GetContext = %v after %v, want the caller deadline", err, time.Since(start))
+	}
+
+	// 超时足够长时成功
+	if err := client.Get(&pb.Request{Group: "rpc-timeout", Key: "c"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerBroadcast(t *testing.T) {
+	gee := NewGroup("server-broadcast", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, _ := NewServer("127.0.0.1:1")
+	s.SetPeers(s.self, peer.self)
+	defer s.Stop()
+	// Broadcast 不写入本地缓存，hotCache 中的数据只能来自远程节点处理的 Set 请求
+	if err := s.Broadcast(&pb.Request{Group: "server-broadcast", Key: "hot"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := gee.hotCache.get("hot"); !ok || v.String() != "v" {
+		t.Fatalf("broadcast copy in hotCache = %q, %v", v.String(), ok)
+	}
+	if _, ok := gee.mainCache.get("hot"); ok {
+		t.Fatal("broadcast copy should not be stored in mainCache")
+	}
+
+	// 推送失败的节点在错误中返回
+	s.SetPeers(s.self, peer.self, "127.0.0.1:2")
+	s.clients["127.0.0.1:2"].RPCTimeout = 200 * time.Millisecond
+	if err := s.Broadcast(&pb.Request{Group: "server-broadcast", Key: "hot"}, []byte("v")); err == nil || !strings.Contains(err.Error(), "127.0.0.1:2") {
+		t.Fatalf("Broadcast with an unreachable peer = %v", err)
+	}
+}
+
+func TestClientResponseTTL(t *testing.T) {
+	NewGroup("client-response-ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}))
+	useDirectDial(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewServer(lis.Addr().String())
+	var gets atomic.Int32
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == pb.GroupCache_Get_FullMethodName {
+			gets.Add(1)
+		}
+		return handler(ctx, req)
+	}))
+	pb.RegisterGroupCacheServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, _ := NewServer("127.0.0.1:1", WithClientResponseTTL(100*time.Millisecond))
+	s.SetPeers(peer.self)
+	defer s.Stop()
+	client := s.clients[peer.self]
+
+	get := func(key string) string {
+		t.Helper()
+		out := &pb.Response{}
+		if err := client.Get(&pb.Request{Group: "client-response-ttl", Key: key}, out); err != nil {
+			t.Fatal(err)
+		}
+		return string(out.GetValue())
+	}
+	// TTL 内重复读取同一个key只发送一次请求
+	for i := 0; i < 3; i++ {
+		if v := get("a"); v != "a-value" {
+			t.Fatalf("Get = %q", v)
+		}
+	}
+	if n := gets.Load(); n != 1 {
+		t.Fatalf("%d Get RPCs within the TTL, want 1", n)
+	}
+	get("b")
+	if n := gets.Load(); n != 2 {
+		t.Fatalf("%d Get RPCs after another key, want 2", n)
+	}
+
+	// Set 丢弃对应key的缓存
+	if err := client.Set(&pb.SetRequest{Group: "client-response-ttl", Key: "a", Value: []byte("new")}, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("a"); v != "new" || gets.Load() != 3 {
+		t.Fatalf("Get after Set = %q with %d RPCs", v, gets.Load())
+	}
+
+	// CacheOnly 请求总是询问远程节点，也不会写入缓存
+	for i := 0; i < 2; i++ {
+		if err := client.Get(&pb.Request{Group: "client-response-ttl", Key: "c", CacheOnly: true}, &pb.Response{}); err == nil {
+			t.Fatal("CacheOnly Get of an uncached key should fail")
+		}
+	}
+	get("c")
+	if n := gets.Load(); n != 6 {
+		t.Fatalf("%d Get RPCs after CacheOnly requests, want 6", n)
+	}
+	client.Get(&pb.Request{Group: "client-response-ttl", Key: "a", CacheOnly: true}, &pb.Response{})
+	if n := gets.Load(); n != 7 {
+		t.Fatalf("CacheOnly Get should bypass the response cache, %d RPCs", n)
+	}
+
+	// 过期后重新请求
+	time.Sleep(150 * time.Millisecond)
+	get("b")
+	if n := gets.Load(); n != 8 {
+		t.Fatalf("%d Get RPCs after the TTL, want 8", n)
+	}
+}
+
+func TestMigrateKey(t *testing.T) {
+	g := NewGroup("migrate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	useDirectDial(t)
+
+	// 目标是真实的 Server，记录它收到的 Set 请求。同一进程中的 Server 共享 Group，
+	// 因此只能通过收到的请求判断迁移的内容
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := NewServer(lis.Addr().String(), WithStaticCluster())
+	sets := make(chan *pb.SetRequest, 2)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if in, ok := req.(*pb.SetRequest); ok && err == nil {
+			sets <- in
+		}
+		return resp, err
+	}))
+	pb.RegisterGroupCacheServer(grpcServer, target)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	to := target.self
+
+	s, _ := NewServer("127.0.0.1:1", WithStaticCluster())
+	defer s.Stop()
+	g.Get("migrate-k") // 其他测试的缓存组没有这个key
+	received := func() *pb.SetRequest {
+		select {
+		case in := <-sets:
+			return in
+		case <-time.After(5 * time.Second):
+			t.Fatal("target did not receive the migrated key")
+			return nil
+		}
+	}
+
+	if err := s.MigrateKey("absent", to); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("MigrateKey of an uncached key = %v", err)
+	}
+	// 新节点还不在集群中，迁移后本节点保留副本
+	if err := s.MigrateKey("migrate-k", to); err != nil {
+		t.Fatal(err)
+	}
+	if in := received(); in.GetGroup() != "migrate" || string(in.GetValue()) != "migrate-k-value" || in.GetTtlMs() < (59*time.Minute).Milliseconds() {
+		t.Fatalf("target received %v, want the value with its remaining ttl", in)
+	}
+	if _, ok := g.mainCache.get("migrate-k"); !ok {
+		t.Fatal("MigrateKey should keep the local copy")
+	}
+
+	if err := s.MigrateKeyAndEvict("migrate-k", to); err != nil {
+		t.Fatal(err)
+	}
+	if in := received(); string(in.GetValue()) != "migrate-k-value" {
+		t.Fatalf("target received %v", in)
+	}
+	if _, ok := g.mainCache.get("migrate-k"); ok {
+		t.Fatal("MigrateKeyAndEvict should evict the local copy")
+	}
+}
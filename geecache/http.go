@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"geecache/consistenthash"
 	pb "geecache/proto"
+	"geecache/singleflight"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -27,6 +30,7 @@ type HTTPPool struct {
 	mu          sync.Mutex // guards peers and httpGetters
 	peers       *consistenthash.Map
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	inflight    singleflight.Group     // collapses identical concurrent requests, keyed by group/key
 }
 
 // NewHTTPPool initializes an HTTP pool of peers.
@@ -64,20 +68,103 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
+	// Values stored in an accepted content encoding are sent as stored,
+	// without decoding and re-encoding them.
+	accept := acceptEncodings(r.Header.Get("Accept-Encoding"))
+
+	// Identical concurrent requests share a single Get and encode.
+	// The group name cannot contain "/" and the accepted encodings
+	// cannot contain ";", so the key is unambiguous.
+	res, err := p.inflight.Do(strings.Join(accept, ",")+";"+groupName+"/"+key, func() (interface{}, error) {
+		view, encoding, err := group.GetEncoded(key, accept...)
+		if err != nil {
+			return nil, err
+		}
+		// Write the value to the response body as a proto message.
+		body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Encoding: encoding})
+		if err != nil {
+			return nil, err
+		}
+		etag := fmt.Sprintf(`"%016x"`, view.Version())
+		if encoding != "" {
+			etag = fmt.Sprintf(`"%016x-%s"`, view.Version(), encoding)
+		}
+		e := &httpEntry{body: body, etag: etag}
+		e.expire, e.updated, _ = group.expiry(key)
+		return e, nil
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	e := res.(*httpEntry)
+	w.Header().Set("Vary", "Accept-Encoding")
+	e.setCacheHeaders(w.Header())
+	if etagMatch(r.Header.Get("If-None-Match"), e.etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(body)
+	w.Write(e.body)
+}
+
+// httpEntry is an encoded value together with the metadata
+// used for its HTTP caching headers.
+type httpEntry struct {
+	body    []byte
+	etag    string    // quoted value version
+	expire  time.Time // zero if the value is not cached locally
+	updated time.Time
+}
+
+// setCacheHeaders sets ETag, Cache-Control and Last-Modified.
+// max-age is the remaining TTL of the locally cached entry; values
+// served from a peer without a local copy are marked no-cache.
+func (e *httpEntry) setCacheHeaders(h http.Header) {
+	h.Set("ETag", e.etag)
+	if maxAge := int64(time.Until(e.expire) / time.Second); maxAge > 0 {
+		h.Set("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10))
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	if !e.updated.IsZero() {
+		h.Set("Last-Modified", e.updated.UTC().Format(http.TimeFormat))
+	}
+}
+
+// acceptEncodings returns the content codings listed in an
+// Accept-Encoding header, skipping those with q=0.
+func acceptEncodings(header string) []string {
+	var encodings []string
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || coding == "*" || coding == "identity" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		encodings = append(encodings, coding)
+	}
+	return encodings
+}
+
+// etagMatch reports whether an If-None-Match header matches etag,
+// using the weak comparison required by RFC 9110.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
 }
 
 // Set updates the pool's list of peers.
@@ -134,6 +221,14 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	if err = proto.Unmarshal(bytes, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
+	// The transport accepts gzip by default, so the value may be
+	// sent in its stored encoding.
+	if out.Encoding != "" {
+		if out.Value, err = decodeContent(out.Encoding, out.Value); err != nil {
+			return fmt.Errorf("decoding %s value: %v", out.Encoding, err)
+		}
+		out.Encoding = ""
+	}
 
 	return nil
 }
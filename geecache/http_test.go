@@ -0,0 +1,196 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	pb "geecache/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPPoolCollapsesRequests(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	NewGroup("http-collapse", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+
+	pool := NewHTTPPool("http://127.0.0.1")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	bodies := make([][]byte, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := http.Get(srv.URL + defaultBasePath + "http-collapse/k")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer res.Body.Close()
+			bodies[i], errs[i] = io.ReadAll(res.Body)
+		}(i)
+	}
+	// 等待请求都到达后再放行加载
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("identical requests should load once, got %d", loads)
+	}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("request %d failed: %v", i, errs[i])
+		}
+		out := &pb.Response{}
+		if err := proto.Unmarshal(bodies[i], out); err != nil || string(out.GetValue()) != "k-value" {
+			t.Fatalf("request %d got %q, %v", i, out.GetValue(), err)
+		}
+	}
+}
+
+func TestHTTPPoolCachingHeaders(t *testing.T) {
+	g := NewGroup("http-headers", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+
+	pool := NewHTTPPool("http://127.0.0.1")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	u := srv.URL + defaultBasePath + "http-headers/k"
+
+	get := func(ifNoneMatch string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	before := time.Now().Add(-time.Second)
+	res := get("")
+	etag := fmt.Sprintf(`"%016x"`, ByteView{b: []byte("k-value")}.Version())
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != etag {
+		t.Fatalf("status %d, ETag %q, want %q", res.StatusCode, res.Header.Get("ETag"), etag)
+	}
+	// TTL 为一小时，加上最多一分钟的随机抖动
+	var maxAge int
+	if _, err := fmt.Sscanf(res.Header.Get("Cache-Control"), "max-age=%d", &maxAge); err != nil || maxAge < 3590 || maxAge > 3660 {
+		t.Fatalf("Cache-Control = %q", res.Header.Get("Cache-Control"))
+	}
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil || modified.Before(before) {
+		t.Fatalf("Last-Modified = %q, %v", res.Header.Get("Last-Modified"), err)
+	}
+	if _, ttl, err := g.GetWithTTL("k"); err != nil || ttl < 59*time.Minute {
+		t.Fatalf("GetWithTTL = %v, %v", ttl, err)
+	}
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		res := get(header)
+		if res.StatusCode != http.StatusNotModified || res.Header.Get("ETag") != etag || res.Header.Get("Cache-Control") == "" {
+			t.Fatalf("If-None-Match %s: status %d, headers %v", header, res.StatusCode, res.Header)
+		}
+	}
+	if res := get(`"other"`); res.StatusCode != http.StatusOK {
+		t.Fatalf("stale ETag should get the value, status %d", res.StatusCode)
+	}
+}
+
+// countingCodec 记录编码和解码的次数
+type countingCodec struct {
+	ContentEncoder
+	encodes, decodes atomic.Int32
+}
+
+func (c *countingCodec) Encode(value []byte) ([]byte, error) {
+	c.encodes.Add(1)
+	return c.ContentEncoder.Encode(value)
+}
+
+func (c *countingCodec) Decode(stored []byte) ([]byte, error) {
+	c.decodes.Add(1)
+	return c.ContentEncoder.Decode(stored)
+}
+
+func TestHTTPPoolContentEncoding(t *testing.T) {
+	gz, _ := NewGzipCodec(gzip.DefaultCompression)
+	codec := &countingCodec{ContentEncoder: gz.(ContentEncoder)}
+	value := bytes.Repeat([]byte("compressible "), 50)
+	g := NewGroup("http-gzip", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}), WithTTL(time.Hour), WithValueCodec(codec))
+	g.Get("k") // 加载并压缩保存
+
+	pool := NewHTTPPool("http://127.0.0.1")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	u := srv.URL + defaultBasePath + "http-gzip/k"
+
+	// get 显式设置 Accept-Encoding，传输层不会自动添加 gzip
+	get := func(acceptEncoding string) (*http.Response, *pb.Response) {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		out := &pb.Response{}
+		if err := proto.Unmarshal(body, out); err != nil {
+			t.Fatal(err)
+		}
+		return res, out
+	}
+
+	raw, _ := g.mainCache.lru.Peek("k")
+	res, out := get("br, gzip;q=0.8")
+	if out.GetEncoding() != "gzip" || !bytes.Equal(out.GetValue(), raw.(ByteView).b) {
+		t.Fatalf("gzip-accepting client got %d bytes encoded as %q, want the stored bytes", len(out.GetValue()), out.GetEncoding())
+	}
+	if e, d := codec.encodes.Load(), codec.decodes.Load(); e != 1 || d != 0 {
+		t.Fatalf("serving the stored bytes took %d encodes and %d decodes, want 1 and 0", e, d)
+	}
+	if res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Vary = %q", res.Header.Get("Vary"))
+	}
+	gzipTag := res.Header.Get("ETag")
+
+	// 不接受 gzip 时返回解码后的值
+	res, out = get("identity, gzip;q=0")
+	if out.GetEncoding() != "" || !bytes.Equal(out.GetValue(), value) || codec.decodes.Load() != 1 {
+		t.Fatalf("identity client got %d bytes encoded as %q", len(out.GetValue()), out.GetEncoding())
+	}
+	if res.Header.Get("ETag") == gzipTag {
+		t.Fatal("representations with different encodings should have different ETags")
+	}
+
+	// 远程节点客户端自动解码
+	var resp pb.Response
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	if err := getter.Get(&pb.Request{Group: "http-gzip", Key: "k"}, &resp); err != nil || !bytes.Equal(resp.GetValue(), value) || resp.GetEncoding() != "" {
+		t.Fatalf("httpGetter.Get = %d bytes, %q, %v", len(resp.GetValue()), resp.GetEncoding(), err)
+	}
+}
@@ -0,0 +1,101 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrThrottled 表示加载请求被 LimitRate 限流拒绝
+var ErrThrottled = errors.New("geecache: load throttled")
+
+// Priority 是请求的优先级，通过 ContextWithPriority 附加在 GetContext 的 ctx 上，限流中间件据此决定是否放行
+type Priority int
+
+const (
+	PriorityNormal   Priority = iota // 默认优先级，受限流约束
+	PriorityCritical                 // 关键请求(如健康检查依赖的配置)，绕过 LimitConcurrency 和 LimitRate
+)
+
+// priorityKey 是 Priority 在 context 中的键
+type priorityKey struct{}
+
+// ContextWithPriority 返回携带优先级 p 的 ctx
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext 返回 ctx 携带的优先级，没有时为 PriorityNormal
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// LimitConcurrency 返回限制并发加载数的中间件：同时最多进行 n 个加载，超出的请求排队等待空位，
+// ctx 结束时放弃等待并返回 ctx.Err()(经 singleflight 合并的加载不随调用方取消，会等到有空位)。
+// PriorityCritical 的请求既不占用也不等待名额。中间件运行在 singleflight 之内，优先级取自最先发起加载的调用方，
+// 不同优先级的请求分开合并，关键请求不会排在普通请求发起的加载之后
+func LimitConcurrency(n int) Middleware {
+	if n <= 0 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	return func(next LoadFunc) LoadFunc {
+		return func(ctx context.Context, key string) (ByteView, error) {
+			if PriorityFromContext(ctx) >= PriorityCritical {
+				return next(ctx, key)
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ByteView{}, ctx.Err()
+			}
+			defer func() { <-sem }()
+			return next(ctx, key)
+		}
+	}
+}
+
+// LimitRate 返回令牌桶限流中间件：每秒补充 perSecond 个令牌，最多积累 burst 个，每次加载消耗一个，
+// 没有令牌时请求直接返回 ErrThrottled 而不排队。PriorityCritical 的请求不消耗令牌，总是放行，
+// 也不会与普通请求合并而得到它们的 ErrThrottled
+func LimitRate(perSecond float64, burst int) Middleware {
+	b := &tokenBucket{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	return func(next LoadFunc) LoadFunc {
+		return func(ctx context.Context, key string) (ByteView, error) {
+			if PriorityFromContext(ctx) < PriorityCritical && !b.take() {
+				return ByteView{}, ErrThrottled
+			}
+			return next(ctx, key)
+		}
+	}
+}
+
+// tokenBucket 是 LimitRate 使用的令牌桶
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 令牌数上限
+	tokens float64 // 当前的令牌数
+	last   time.Time
+}
+
+// take 补充自上次以来的令牌后尝试取走一个，没有令牌时返回 false
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,89 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrencyPriority(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	gee := NewGroup("limit-concurrency", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			close(started)
+			<-release
+		}
+		return []byte(key), nil
+	}))
+	gee.Use(LimitConcurrency(1))
+	defer close(release)
+
+	// 唯一的名额被一个慢加载占用
+	go gee.Get("slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "normal"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("normal request error = %v, want it to wait until the deadline", err)
+	}
+
+	ctx, cancel = context.WithTimeout(ContextWithPriority(context.Background(), PriorityCritical), time.Second)
+	defer cancel()
+	if v, err := gee.GetContext(ctx, "critical"); err != nil || v.String() != "critical" {
+		t.Fatalf("critical request = %q, %v", v.String(), err)
+	}
+}
+
+func TestCriticalJoinerBehindThrottledLeader(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	gee := NewGroup("limit-critical-joiner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			close(started)
+			<-release
+		}
+		return []byte(key), nil
+	}))
+	gee.Use(LimitConcurrency(1))
+	defer close(release)
+
+	go gee.Get("slow")
+	<-started
+	// 普通请求发起的加载在排队等待名额，另一个普通请求与它合并
+	go gee.Get("k")
+	go gee.Get("k")
+	for i := 0; i < 100 && gee.LoadWaiters("k") == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 同一个key的关键请求不与排队的普通加载合并
+	ctx, cancel := context.WithTimeout(ContextWithPriority(context.Background(), PriorityCritical), time.Second)
+	defer cancel()
+	if v, err := gee.GetContext(ctx, "k"); err != nil || v.String() != "k" {
+		t.Fatalf("critical joiner = %q, %v", v.String(), err)
+	}
+}
+
+func TestLimitRatePriority(t *testing.T) {
+	gee := NewGroup("limit-rate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gee.Use(LimitRate(0.001, 1))
+
+	if _, err := gee.Get("first"); err != nil {
+		t.Fatalf("first request should use the burst token, got %v", err)
+	}
+	if _, err := gee.Get("second"); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("second request error = %v, want ErrThrottled", err)
+	}
+	critical := ContextWithPriority(context.Background(), PriorityCritical)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := gee.GetContext(critical, key); err != nil {
+			t.Fatalf("critical request %s = %v", key, err)
+		}
+	}
+	if PriorityFromContext(context.Background()) != PriorityNormal {
+		t.Fatal("default priority should be PriorityNormal")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -14,13 +15,133 @@ type Cache struct {
 	ll        *list.List
 	cache     map[string]*list.Element
 	OnEvicted func(key string, value Value) // 可选，在entry被移除的时候执⾏
+	OnEvictedBatch func(pairs []KeyValue) // 可选，一轮淘汰结束后以批量方式执行一次
 	defaultTTL time.Duration
+	evicted    []KeyValue // 本轮淘汰中积累的键值对，交给 OnEvictedBatch
+	batching   bool       // 是否处于一轮连续淘汰中
+	history    time.Duration // 更新后旧值的保留时长，为0表示不保留
+	cost       func(key string, value Value) int64 // 节点占用的容量，为 nil 时使用键和值的长度
+	grace      time.Duration // 过期节点的保留时长，期间仍可通过 GetExpired 读取
+	k          int           // LRU-K 的 K，小于2时为普通 LRU
+	tick       uint64        // 逻辑时钟，每次访问加一，用于记录 LRU-K 的访问时间
+	checksum   func(value Value) uint64 // 校验和函数，为 nil 表示不校验
+	jitter     time.Duration            // 过期时间额外增加的随机时长上限，为0表示不增加
+	randN      func(n int64) int64      // 返回 [0, n) 的随机数，用于计算 jitter
+	expireStrategy ExpireStrategy       // 重复写入已存在的键时如何更新过期时间
+	cleanup    time.Duration            // janitor 主动清理过期节点的间隔，为0表示不开启
+	locker     sync.Locker              // janitor 清理时持有的锁，与调用方访问 Cache 时使用的锁相同
+	stop       chan struct{}            // 关闭后 janitor 退出
+	closeOnce  sync.Once
 }
 
 type entry struct {
 	key   string
 	value Value
 	expire time.Time	// 节点的过期时间
+	stale      time.Time // 软过期时间，超过后仍可读取但被视为陈旧，零值表示不会陈旧
+	prev       Value     // 被覆盖前的旧值，仅在开启 history 时记录
+	prevExpire time.Time // 旧值的保留截止时间
+	size       int64     // 节点计入的容量
+	prevSize   int64     // 旧值计入的容量
+	updated    time.Time // 最近一次写入的时间
+	sum        uint64    // 写入时计算的校验和，仅在开启 WithChecksum 时记录
+	hist       []uint64  // LRU-K 最近 K 次访问的逻辑时间，环形存储
+	histN      int       // 累计访问次数
+}
+
+// ExpireStrategy 决定重复写入已存在的键时如何更新其过期时间
+type ExpireStrategy int
+
+const (
+	// ExtendOnly 只在新的过期时间更晚时更新，即只延长不缩短，适合刷新式写入(默认)
+	ExtendOnly ExpireStrategy = iota
+	// AlwaysReplace 总是使用新的过期时间，调用方可以借此显式缩短 TTL
+	AlwaysReplace
+	// Shortest 只在新的过期时间更早时更新，保留两者中较早的过期时间
+	Shortest
+)
+
+// KeyValue 是被淘汰的一对键值，用于 OnEvictedBatch
+type KeyValue struct {
+	Key   string
+	Value Value
+}
+
+// Option 用于在 New 时配置 Cache 的可选行为
+type Option func(*Cache)
+
+// WithHistory 开启深度为1的历史记录：键被更新后，旧值在 retention 时长内仍可通过 GetPrevious 读取
+// 旧值同样计入已占用容量
+func WithHistory(retention time.Duration) Option {
+	return func(c *Cache) {
+		c.history = retention
+	}
+}
+
+// WithCost 使用 cost 计算每个节点占用的容量，代替默认的 len(key)+value.Len()，
+// maxBytes 随之成为 cost 之和的上限。开启 history 时旧值按 cost(key, 旧值) 计入
+func WithCost(cost func(key string, value Value) int64) Option {
+	return func(c *Cache) {
+		c.cost = cost
+	}
+}
+
+// WithGrace 让过期的节点在 grace 时长内继续保留：Get 视其为未命中但不会移除，
+// 可以通过 GetExpired 读取，用于远程节点或数据源故障时降级返回旧数据。保留的节点仍计入容量并优先被淘汰
+func WithGrace(grace time.Duration) Option {
+	return func(c *Cache) {
+		c.grace = grace
+	}
+}
+
+// WithLRUK 使用 LRU-K 淘汰策略：每个节点记录最近 K 次访问，容量不足时优先淘汰访问不足 K 次的节点
+// (其中最久未使用的先淘汰)，其次淘汰第 K 次最近访问最早的节点。
+// 相比普通 LRU，只在突发中被访问一次的键不会挤掉反复被访问的键。选择淘汰节点需要遍历，时间复杂度为 O(n)
+func WithLRUK(k int) Option {
+	return func(c *Cache) {
+		c.k = k
+	}
+}
+
+// WithChecksum 在写入时用 sum 计算并保存每个节点的校验和，读取(Get/GetWithStale)时重新计算并比对，
+// 不一致说明值在内存中被破坏，节点会被移除并视为未命中。每次读取都要重新计算，默认不开启
+func WithChecksum(sum func(value Value) uint64) Option {
+	return func(c *Cache) {
+		c.checksum = sum
+	}
+}
+
+// WithJitter 为每个节点的过期时间额外增加 [0, max) 的随机时长，避免同时写入的大量节点同时过期(缓存雪崩)。
+// 默认不增加，节点在 ttl 后准时过期
+func WithJitter(max time.Duration) Option {
+	return func(c *Cache) {
+		c.jitter = max
+	}
+}
+
+// WithRand 设置计算 jitter 使用的随机数来源，默认使用 math/rand 的全局来源，测试中可以传入固定种子的来源。
+// Cache 本身不是并发安全的，r 只会在调用方持有的锁内使用
+func WithRand(r *rand.Rand) Option {
+	return func(c *Cache) {
+		c.randN = r.Int63n
+	}
+}
+
+// WithExpireStrategy 设置重复写入已存在的键时过期时间的更新策略，默认为 ExtendOnly
+func WithExpireStrategy(s ExpireStrategy) Option {
+	return func(c *Cache) {
+		c.expireStrategy = s
+	}
+}
+
+// WithCleanupInterval 启动后台 janitor，每隔 interval 主动移除已过期(且超过 WithGrace 保留时长)的节点，
+// 避免大量过期后再也没有被访问的节点一直占用内存和容量。Cache 本身不是并发安全的，janitor 清理时持有 mu，
+// 调用方访问 Cache 时必须持有同一把锁，mu 不能为 nil。不再使用时调用 Close 停止 janitor，否则 goroutine 会一直运行
+func WithCleanupInterval(interval time.Duration, mu sync.Locker) Option {
+	return func(c *Cache) {
+		c.cleanup = interval
+		c.locker = mu
+	}
 }
 
 type Value interface {
@@ -28,40 +149,118 @@ type Value interface {
 }
 
 // 生成缓存
-func New(maxbytes int64, onEvicted func(string, Value),defaultTTL time.Duration) *Cache {
-	return &Cache{
+func New(maxbytes int64, onEvicted func(string, Value),defaultTTL time.Duration, opts ...Option) *Cache {
+	c := &Cache{
 		maxBytes:  maxbytes,
 		ll:        list.New(),
 		cache:     make(map[string]*list.Element),
 		OnEvicted: onEvicted,
 		defaultTTL: defaultTTL,
+		randN:      rand.Int63n,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cleanup > 0 && c.locker != nil {
+		c.stop = make(chan struct{})
+		go c.janitor(c.cleanup, c.stop)
+	}
+	return c
+}
+
+// janitor 每隔 interval 在锁内清理一次过期节点，直到 stop 被关闭
+func (c *Cache) janitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.locker.Lock()
+			c.RemoveExpired()
+			c.locker.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close 停止 WithCleanupInterval 启动的 janitor，重复调用是安全的，未开启时什么也不做
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+	})
+}
+
+// RemoveExpired 移除所有已过期且超过 WithGrace 保留时长的节点，返回移除的数量。
+// 移除的节点同样触发 OnEvicted，并合并为一次 OnEvictedBatch 回调。需要遍历所有节点，时间复杂度为 O(n)
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	c.batching = true
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if kv := e.Value.(*entry); kv.expire.Add(c.grace).Before(now) {
+			c.RemoveElement(e)
+			n++
+		}
+		e = prev
 	}
+	c.batching = false
+	c.flushEvicted()
+	return n
 }
 
 // 根据键值缓存中的值，存在就把节点移动到链表最前面(最近使用),如果不存在或键值过期,返回0或false
 func (c *Cache) Get(key string) (value Value, ok bool) {
+	value, _, ok = c.GetWithStale(key)
+	return
+}
+
+// GetWithStale 与 Get 相同，额外返回节点是否已超过软过期时间
+// 超过硬过期时间的节点会被移除并视为未命中
+func (c *Cache) GetWithStale(key string) (value Value, stale bool, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		kv := ele.Value.(*entry)
-		if kv.expire.Before(time.Now()) {
+		now := time.Now()
+		if kv.expire.Before(now) {
+			if now.Before(kv.expire.Add(c.grace)) {
+				return nil, false, false
+			}
 			c.RemoveElement(ele)
 			log.Printf("The LRUcache key—%s has expired", key)
-			return nil, false
+			return nil, false, false
+		}
+		if c.checksum != nil && c.checksum(kv.value) != kv.sum {
+			c.RemoveElement(ele)
+			log.Printf("The LRUcache key—%s failed checksum verification", key)
+			return nil, false, false
 		}
 		c.ll.MoveToFront(ele)
-		return kv.value, true
+		c.touch(kv)
+		return kv.value, !kv.stale.IsZero() && kv.stale.Before(now), true
 	}
 	return
 }
 
-// 找到最久未使用且已过期的缓存项，然后将其从缓存中移除。
+// 找到最久未使用且已过期的缓存项，然后将其从缓存中移除；没有过期的缓存项时移除最久未使用的缓存项。
+// 开启 LRU-K 时按 LRU-K 选择淘汰的节点
 func (c *Cache) RemoveOldest() {
+	if c.k > 1 {
+		c.removeLRUK()
+		return
+	}
+	now := time.Now()
 	for e := c.ll.Back(); e != nil; e = e.Prev() {
-		kv := e.Value.(*entry)
-		if kv.expire.Before(time.Now()) {
+		if e.Value.(*entry).expire.Before(now) {
 			c.RemoveElement(e)
-			break
+			return
 		}
 	}
+	if e := c.ll.Back(); e != nil {
+		c.RemoveElement(e)
+	}
 }
 	
 
@@ -69,37 +268,292 @@ func (c *Cache) RemoveOldest() {
 // 如果键不存在,则链表头部插入新的节点，并更新已占有的容器
 // 如果添加新的键值对后超出了最大存储容量，则会连续移除最久未使用的记录，直到满足容量要求
 func (c *Cache) Add(key string, value Value,ttl time.Duration) {
-	expireTime := time.Now().Add(ttl + time.Duration(rand.Intn(60))*time.Second)
+	c.AddWithSoftTTL(key, value, 0, ttl)
+}
+
+// AddWithSoftTTL 与 Add 相同，额外设置软过期时间(stale-while-revalidate)：
+// 超过 softTTL 后节点仍可读取，但 GetWithStale 会将其标记为陈旧；超过 ttl 后节点失效
+// softTTL 为0表示节点不会陈旧
+func (c *Cache) AddWithSoftTTL(key string, value Value, softTTL, ttl time.Duration) {
+	expireTime := time.Now().Add(ttl + c.randJitter())
+	var staleTime time.Time
+	if softTTL > 0 {
+		staleTime = time.Now().Add(softTTL)
+	}
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
-		kv.value = value
-		// 更新过期时间时，判断是否应该保留原本的过期时间
-		if kv.expire.Before(expireTime) {
+		if c.history > 0 {
+			// 保留旧值，替换掉更早的旧值
+			prevSize := c.prevCost(key, kv.value)
+			c.nbytes += prevSize - kv.prevSize
+			kv.prev, kv.prevSize = kv.value, prevSize
+			kv.prevExpire = time.Now().Add(c.history)
+		}
+		size := c.entryCost(key, value)
+		c.nbytes += size - kv.size
+		kv.value, kv.size = value, size
+		kv.updated = time.Now()
+		if c.checksum != nil {
+			kv.sum = c.checksum(value)
+		}
+		// 更新过期时间时，根据策略判断是否应该保留原本的过期时间
+		if c.replaceExpire(kv.expire, expireTime) {
 			kv.expire = expireTime
 		}
+		kv.stale = staleTime
+		c.touch(kv)
 	} else {
-		ele = c.ll.PushFront(&entry{key: key, value: value, expire: expireTime})
+		size := c.entryCost(key, value)
+		ele = c.ll.PushFront(&entry{key: key, value: value, expire: expireTime, stale: staleTime, size: size, updated: time.Now()})
 		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.nbytes += size
+		if c.checksum != nil {
+			ele.Value.(*entry).sum = c.checksum(value)
+		}
+		c.touch(ele.Value.(*entry))
 	}
-	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+	// 容量超限时可能连续淘汰多个节点，合并为一次 OnEvictedBatch 回调
+	c.batching = true
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes && c.ll.Len() > 0 {
 		c.RemoveOldest()
 	}
+	c.batching = false
+	c.flushEvicted()
+}
+
+// replaceExpire 判断重复写入时是否用新的过期时间 next 替换原本的 old
+func (c *Cache) replaceExpire(old, next time.Time) bool {
+	switch c.expireStrategy {
+	case AlwaysReplace:
+		return true
+	case Shortest:
+		return next.Before(old)
+	default:
+		return old.Before(next)
+	}
 }
 
+// randJitter 返回 [0, jitter) 的随机时长
+func (c *Cache) randJitter() time.Duration {
+	if c.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(c.randN(int64(c.jitter)))
+}
+
+// Peek 读取 key 的值但不改变它在链表中的位置，也不计入 LRU-K 的访问记录，适用于统计、调试等管理性读取。
+// 已过期或校验和不一致的节点视为未命中，但不会被移除
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.expire.Before(time.Now()) || (c.checksum != nil && c.checksum(kv.value) != kv.sum) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// GetPrevious 返回键被最近一次更新前的旧值，旧值超过保留时长后返回false
+// 与 Get 不同，GetPrevious 不会改变节点的最近使用顺序
+func (c *Cache) GetPrevious(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.prev == nil {
+		return nil, false
+	}
+	if kv.prevExpire.Before(time.Now()) {
+		c.nbytes -= kv.prevSize
+		kv.prev, kv.prevSize = nil, 0
+		return nil, false
+	}
+	return kv.prev, true
+}
+
+// GetExpired 返回已过期但仍在 WithGrace 保留期内的节点，节点未过期、不存在或超过保留期时返回false
+// 与 Get 不同，GetExpired 不会改变节点的最近使用顺序
+func (c *Cache) GetExpired(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	now := time.Now()
+	if !kv.expire.Before(now) || !now.Before(kv.expire.Add(c.grace)) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// Expiry 返回未过期节点的过期时间和最近一次写入的时间，节点不存在或已过期时返回false
+// 与 Get 不同，Expiry 不会改变节点的最近使用顺序
+func (c *Cache) Expiry(key string) (expire, updated time.Time, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	kv := ele.Value.(*entry)
+	if kv.expire.Before(time.Now()) {
+		return time.Time{}, time.Time{}, false
+	}
+	return kv.expire, kv.updated, true
+}
+
+// Updated 返回节点最近一次写入的时间，已过期但尚未被移除的节点同样返回，节点不存在时返回false。
+// 与 Get 不同，Updated 不会改变节点的最近使用顺序
+func (c *Cache) Updated(key string) (updated time.Time, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return ele.Value.(*entry).updated, true
+}
+
+// Range 按最近使用到最久未使用的顺序遍历未过期的节点，fn 返回 false 时停止遍历
+// Range 不会改变节点的最近使用顺序
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	now := time.Now()
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		if kv.expire.Before(now) {
+			continue
+		}
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Bytes 返回已占用的容量，即所有键、值(以及保留的旧值)的长度之和，设置了 WithCost 时为 cost 之和
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// Len 返回缓存中的节点数，包括已过期但尚未被清理的节点
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+// Keys 按最近使用到最久未使用的顺序返回所有未过期的键，不会改变节点的位置。
+// 需要遍历所有节点，时间复杂度为 O(n)
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.ll.Len())
+	c.Range(func(key string, _ Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// LenLive 返回缓存中未过期的节点数
+// 需要遍历所有节点，时间复杂度为 O(n)，不适合在热路径上频繁调用
+func (c *Cache) LenLive() int {
+	n := 0
+	c.Range(func(string, Value) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Remove 删除 key 对应的节点(包括已过期的节点)，返回节点是否存在，
+// 与淘汰一样会触发 OnEvicted/OnEvictedBatch
+func (c *Cache) Remove(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	c.RemoveElement(ele)
+	return true
+}
+
 // RemoveElement 函数用于删除某个节点
 func (c *Cache) RemoveElement(e *list.Element) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)                                //删除key-节点这对映射
-	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) //重新计算已用容量
+	c.nbytes -= kv.size + kv.prevSize //重新计算已用容量
 	if c.OnEvicted != nil {
 		c.OnEvicted(kv.key, kv.value) //调用对应的回调函数
 	}
-}
\ No newline at end of file
+	if c.OnEvictedBatch != nil {
+		c.evicted = append(c.evicted, KeyValue{Key: kv.key, Value: kv.value})
+		if !c.batching {
+			c.flushEvicted()
+		}
+	}
+}
+
+// flushEvicted 将本轮积累的被淘汰键值对一次性交给 OnEvictedBatch
+func (c *Cache) flushEvicted() {
+	if len(c.evicted) == 0 {
+		return
+	}
+	pairs := c.evicted
+	c.evicted = nil
+	c.OnEvictedBatch(pairs)
+}
+
+// touch 在开启 LRU-K 时记录一次访问
+func (c *Cache) touch(kv *entry) {
+	if c.k < 2 {
+		return
+	}
+	if kv.hist == nil {
+		kv.hist = make([]uint64, c.k)
+	}
+	c.tick++
+	kv.hist[kv.histN%c.k] = c.tick
+	kv.histN++
+}
+
+// removeLRUK 按 LRU-K 淘汰一个节点：已过期的节点最先淘汰，其次是访问不足 K 次的节点中最久未使用的，
+// 最后是第 K 次最近访问最早的节点
+func (c *Cache) removeLRUK() {
+	now := time.Now()
+	var partial, full *list.Element
+	var fullTick uint64
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		kv := e.Value.(*entry)
+		if kv.expire.Before(now) {
+			c.RemoveElement(e)
+			return
+		}
+		if kv.histN < c.k {
+			if partial == nil {
+				partial = e
+			}
+			continue
+		}
+		// 环形数组中下一个写入的位置保存的就是第 K 次最近访问
+		if kth := kv.hist[kv.histN%c.k]; full == nil || kth < fullTick {
+			full, fullTick = e, kth
+		}
+	}
+	if partial != nil {
+		c.RemoveElement(partial)
+	} else if full != nil {
+		c.RemoveElement(full)
+	}
+}
+
+// entryCost 返回键值对占用的容量
+func (c *Cache) entryCost(key string, value Value) int64 {
+	if c.cost != nil {
+		return c.cost(key, value)
+	}
+	return int64(len(key)) + int64(value.Len())
+}
+
+// prevCost 返回保留的旧值占用的容量，默认只计算值的长度(键已经计入节点本身)
+func (c *Cache) prevCost(key string, value Value) int64 {
+	if c.cost != nil {
+		return c.cost(key, value)
+	}
+	return int64(value.Len())
+}
@@ -1,8 +1,13 @@
 package lru
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 type String string
@@ -12,8 +17,8 @@ func (d String) Len() int {
 }
 
 func TestGet(t *testing.T) {
-	lru := New(int64(0), nil,60)
-	lru.Add("key1", String("1234"),60)
+	lru := New(int64(0), nil, time.Minute)
+	lru.Add("key1", String("1234"), time.Minute)
 	if v, ok := lru.Get("key1"); !ok || string(v.(String)) != "1234" {
 		t.Fatalf("cache hit key1=1234 failed")
 	}
@@ -26,10 +31,10 @@ func TestRemoveoldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "k3"
 	v1, v2, v3 := "value1", "value2", "v3"
 	cap := len(k1 + k2 + v1 + v2)
-	lru := New(int64(cap), nil,60)
-	lru.Add(k1, String(v1),60)
-	lru.Add(k2, String(v2),60)
-	lru.Add(k3, String(v3),60)
+	lru := New(int64(cap), nil, time.Hour)
+	lru.Add(k1, String(v1), time.Hour)
+	lru.Add(k2, String(v2), time.Hour)
+	lru.Add(k3, String(v3), time.Hour)
 
 	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 {
 		t.Fatalf("Removeoldest key1 failed")
@@ -41,11 +46,11 @@ func TestOnEvicted(t *testing.T) {
 	callback := func(key string, value Value) {
 		keys = append(keys, key)
 	}
-	lru := New(int64(10), callback,60)
-	lru.Add("key1", String("123456"),60)
-	lru.Add("k2", String("k2"),60)
-	lru.Add("k3", String("k3"),60)
-	lru.Add("k4", String("k4"),60)
+	lru := New(int64(10), callback, time.Hour)
+	lru.Add("key1", String("123456"), time.Hour)
+	lru.Add("k2", String("k2"), time.Hour)
+	lru.Add("k3", String("k3"), time.Hour)
+	lru.Add("k4", String("k4"), time.Hour)
 
 	expect := []string{"key1", "k2"}
 
@@ -62,4 +67,450 @@ func TestAdd(t *testing.T) {
 	if lru.nbytes != int64(len("key")+len("111")) {
 		t.Fatal("expected 6 but got", lru.nbytes)
 	}
-}
\ No newline at end of file
+}
+
+func TestGetPrevious(t *testing.T) {
+	lru := New(int64(0), nil, time.Minute, WithHistory(50*time.Millisecond))
+	lru.Add("key", String("1"), time.Minute)
+	if _, ok := lru.GetPrevious("key"); ok {
+		t.Fatalf("key has no previous value yet")
+	}
+	lru.Add("key", String("22"), time.Minute)
+	if v, ok := lru.GetPrevious("key"); !ok || string(v.(String)) != "1" {
+		t.Fatalf("GetPrevious key=1 failed")
+	}
+	if lru.nbytes != int64(len("key")+len("22")+len("1")) {
+		t.Fatal("expected 6 but got", lru.nbytes)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := lru.GetPrevious("key"); ok {
+		t.Fatalf("previous value should expire after retention")
+	}
+	if lru.nbytes != int64(len("key")+len("22")) {
+		t.Fatal("expected 5 but got", lru.nbytes)
+	}
+}
+
+func TestOnEvictedBatch(t *testing.T) {
+	var batches [][]KeyValue
+	lru := New(int64(12), nil, 0)
+	lru.OnEvictedBatch = func(pairs []KeyValue) {
+		batches = append(batches, pairs)
+	}
+	lru.Add("a", String("1"), time.Hour)
+	lru.Add("b", String("2"), time.Hour)
+	lru.Add("c", String("3"), time.Hour)
+	lru.Add("d", String("4"), time.Hour)
+	lru.Add("e", String("123456789"), time.Hour)
+
+	expect := [][]KeyValue{{{"a", String("1")}, {"b", String("2")}, {"c", String("3")}}}
+	if !reflect.DeepEqual(expect, batches) {
+		t.Fatalf("OnEvictedBatch got %v, expect %v", batches, expect)
+	}
+}
+
+func TestGetWithStale(t *testing.T) {
+	lru := New(int64(0), nil, time.Minute)
+	lru.AddWithSoftTTL("fresh", String("1"), time.Minute, time.Hour)
+	lru.AddWithSoftTTL("stale", String("2"), time.Nanosecond, time.Hour)
+	lru.AddWithSoftTTL("expired", String("3"), time.Nanosecond, -time.Hour)
+	time.Sleep(time.Millisecond)
+
+	if v, stale, ok := lru.GetWithStale("fresh"); !ok || stale || string(v.(String)) != "1" {
+		t.Fatalf("fresh key should be served and not stale")
+	}
+	if v, stale, ok := lru.GetWithStale("stale"); !ok || !stale || string(v.(String)) != "2" {
+		t.Fatalf("soft-expired key should be served and stale")
+	}
+	if _, _, ok := lru.GetWithStale("expired"); ok || lru.Len() != 2 {
+		t.Fatalf("hard-expired key should be a miss")
+	}
+}
+
+func TestLenLive(t *testing.T) {
+	lru := New(int64(0), nil, time.Minute)
+	lru.Add("k1", String("1"), time.Hour)
+	lru.Add("k2", String("2"), time.Hour)
+	lru.Add("k3", String("3"), -time.Hour)
+	lru.Add("k4", String("4"), -time.Hour)
+
+	// 过期节点尚未被清理，Len 仍然统计它们
+	if lru.Len() != 4 || lru.LenLive() != 2 {
+		t.Fatalf("Len = %d, LenLive = %d, want 4, 2", lru.Len(), lru.LenLive())
+	}
+	lru.Get("k3")
+	if lru.Len() != 3 || lru.LenLive() != 2 {
+		t.Fatalf("Len = %d, LenLive = %d, want 3, 2", lru.Len(), lru.LenLive())
+	}
+}
+
+func TestCost(t *testing.T) {
+	// 以 x 开头的键代价很高，按字节数计算时不会触发淘汰
+	cost := func(key string, value Value) int64 {
+		if key[0] == 'x' {
+			return 60
+		}
+		return 1
+	}
+	var evicted []string
+	onEvicted := func(key string, value Value) {
+		evicted = append(evicted, key)
+	}
+	weighted := New(int64(100), onEvicted, 0, WithCost(cost))
+	plain := New(int64(100), nil, 0)
+	for _, key := range []string{"a", "b", "x1", "x2"} {
+		weighted.Add(key, String("v"), time.Hour)
+		plain.Add(key, String("v"), time.Hour)
+	}
+
+	if expect := []string{"a", "b", "x1"}; !reflect.DeepEqual(expect, evicted) {
+		t.Fatalf("evicted %v, expect %v", evicted, expect)
+	}
+	if weighted.Bytes() != 60 || weighted.Len() != 1 {
+		t.Fatalf("weighted cache bytes=%d len=%d", weighted.Bytes(), weighted.Len())
+	}
+	if plain.Bytes() != 10 || plain.Len() != 4 {
+		t.Fatalf("plain cache bytes=%d len=%d", plain.Bytes(), plain.Len())
+	}
+
+	// 更新时按新值重新计算代价
+	weighted.Add("x2", String("value"), time.Hour)
+	if weighted.Bytes() != 60 {
+		t.Fatalf("bytes after update = %d", weighted.Bytes())
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	lru := New(int64(0), nil, 0, WithGrace(2*time.Hour))
+	lru.Add("expired", String("1"), -time.Hour)
+	lru.Add("live", String("2"), time.Hour)
+	lru.Add("gone", String("3"), -3*time.Hour)
+
+	if _, ok := lru.Get("expired"); ok {
+		t.Fatal("expired key should miss")
+	}
+	if v, ok := lru.GetExpired("expired"); !ok || string(v.(String)) != "1" {
+		t.Fatalf("expired key within grace = %v, %v", v, ok)
+	}
+	if _, ok := lru.GetExpired("live"); ok {
+		t.Fatal("GetExpired should not return live keys")
+	}
+	// 超过保留期的节点在 Get 时被移除
+	lru.Get("gone")
+	if _, ok := lru.GetExpired("gone"); ok || lru.Len() != 2 {
+		t.Fatalf("key past grace should be removed, len %d", lru.Len())
+	}
+}
+
+func TestLRUK(t *testing.T) {
+	recurring := []string{"r0", "r1", "r2", "r3", "r4"}
+	// 容量为8个节点
+	count := func(string, Value) int64 { return 1 }
+	plain := New(int64(8), nil, 0, WithCost(count))
+	lruk := New(int64(8), nil, 0, WithCost(count), WithLRUK(2))
+	access := func(key string) {
+		plain.Add(key, String("v"), time.Hour)
+		lruk.Add(key, String("v"), time.Hour)
+	}
+	// 每一轮反复访问常用键，之后是一批只访问一次的突发键
+	for round := 0; round < 4; round++ {
+		for _, key := range recurring {
+			access(key)
+			access(key)
+		}
+		for i := 0; i < 5; i++ {
+			access(fmt.Sprintf("burst-%d-%d", round, i))
+		}
+	}
+
+	retained := func(c *Cache) int {
+		n := 0
+		for _, key := range recurring {
+			if _, ok := c.cache[key]; ok {
+				n++
+			}
+		}
+		return n
+	}
+	if got := retained(lruk); got != len(recurring) {
+		t.Fatalf("LRU-K should keep all recurring keys, kept %d", got)
+	}
+	if got := retained(plain); got >= len(recurring) {
+		t.Fatalf("plain LRU should lose recurring keys to the burst, kept %d", got)
+	}
+	if lruk.Len() != 8 {
+		t.Fatalf("LRU-K cache len = %d", lruk.Len())
+	}
+	if v, ok := lruk.Get("r0"); !ok || string(v.(String)) != "v" {
+		t.Fatalf("r0 should hit in LRU-K cache")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	lru := New(0, nil, time.Hour)
+	if _, _, ok := lru.Expiry("k"); ok {
+		t.Fatal("missing key should have no expiry")
+	}
+	before := time.Now()
+	lru.Add("k", String("v1"), time.Hour)
+	expire, updated, ok := lru.Expiry("k")
+	if !ok || expire.Before(before.Add(time.Hour)) || updated.Before(before) {
+		t.Fatalf("Expiry = %v, %v, %v", expire, updated, ok)
+	}
+
+	time.Sleep(time.Millisecond)
+	lru.Add("k", String("v2"), time.Hour)
+	if _, updated2, _ := lru.Expiry("k"); !updated2.After(updated) {
+		t.Fatalf("updated time should advance on write, %v -> %v", updated, updated2)
+	}
+
+	lru.Add("expired", String("v"), -2*time.Minute)
+	if _, _, ok := lru.Expiry("expired"); ok {
+		t.Fatal("expired key should have no expiry")
+	}
+	// Updated 同样返回已过期节点的写入时间
+	if updated, ok := lru.Updated("expired"); !ok || updated.Before(before) {
+		t.Fatalf("Updated of expired key = %v, %v", updated, ok)
+	}
+	if _, ok := lru.Updated("missing"); ok {
+		t.Fatal("missing key should have no updated time")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var evicted []string
+	lru := New(0, func(key string, value Value) {
+		evicted = append(evicted, key)
+	}, time.Hour)
+	lru.Add("k1", String("v1"), time.Hour)
+	lru.Add("k2", String("v2"), time.Hour)
+
+	if !lru.Remove("k1") {
+		t.Fatal("Remove k1 should report the key existed")
+	}
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 1 || lru.Bytes() != int64(len("k2v2")) {
+		t.Fatalf("after Remove: len %d, bytes %d", lru.Len(), lru.Bytes())
+	}
+	if lru.Remove("k1") || lru.Remove("missing") {
+		t.Fatal("Remove of an absent key should return false")
+	}
+	if !reflect.DeepEqual(evicted, []string{"k1"}) {
+		t.Fatalf("OnEvicted got %v", evicted)
+	}
+}
+
+func TestRemoveOldestWithoutExpired(t *testing.T) {
+	var evicted []string
+	lru := New(int64(10), func(key string, value Value) {
+		evicted = append(evicted, key)
+	}, time.Hour)
+	// 所有节点都未过期，容量超限时仍按最近使用顺序淘汰
+	lru.Add("k1", String("v1"), time.Hour)
+	lru.Add("k2", String("v2"), time.Hour)
+	lru.Get("k1")
+	lru.Add("k3", String("v3"), time.Hour)
+	if !reflect.DeepEqual(evicted, []string{"k2"}) || lru.Bytes() > 10 {
+		t.Fatalf("evicted %v, bytes %d", evicted, lru.Bytes())
+	}
+
+	// 已过期的节点优先于最久未使用的节点被淘汰
+	lru.Add("old", String("x"), -time.Hour)
+	lru.Get("k1")
+	lru.Get("k3")
+	lru.Add("k4", String("v4"), time.Hour)
+	if !reflect.DeepEqual(evicted, []string{"k2", "old", "k1"}) {
+		t.Fatalf("evicted %v", evicted)
+	}
+
+	// 单个超过容量的节点也不会让 Add 陷入死循环
+	lru.Add("huge", String("0123456789"), time.Hour)
+	if lru.Len() != 0 || lru.Bytes() != 0 {
+		t.Fatalf("oversized entry: len %d, bytes %d", lru.Len(), lru.Bytes())
+	}
+}
+
+type bytesValue []byte
+
+func (b bytesValue) Len() int {
+	return len(b)
+}
+
+func TestChecksum(t *testing.T) {
+	var evicted []string
+	sum := func(v Value) uint64 {
+		var s uint64
+		for _, c := range v.(bytesValue) {
+			s = s*31 + uint64(c)
+		}
+		return s
+	}
+	lru := New(0, func(key string, value Value) {
+		evicted = append(evicted, key)
+	}, time.Hour, WithChecksum(sum))
+
+	good, bad := bytesValue("value"), bytesValue("value")
+	lru.Add("good", good, time.Hour)
+	lru.Add("bad", bad, time.Hour)
+	bad[0] = 'V' // 模拟内存中的数据被破坏
+
+	if v, ok := lru.Get("good"); !ok || string(v.(bytesValue)) != "value" {
+		t.Fatalf("intact entry = %v, %v", v, ok)
+	}
+	if _, ok := lru.Get("bad"); ok {
+		t.Fatal("corrupted entry should miss")
+	}
+	if lru.Len() != 1 || !reflect.DeepEqual(evicted, []string{"bad"}) {
+		t.Fatalf("corrupted entry should be removed, len %d, evicted %v", lru.Len(), evicted)
+	}
+
+	// 更新后按新值重新计算校验和
+	lru.Add("good", bytesValue("other"), time.Hour)
+	if v, ok := lru.Get("good"); !ok || string(v.(bytesValue)) != "other" {
+		t.Fatalf("updated entry = %v, %v", v, ok)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	// 默认不增加随机时长，节点在 ttl 后准时过期
+	plain := New(0, nil, 0)
+	plain.Add("key", String("v"), 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := plain.Get("key"); ok {
+		t.Fatal("entry should expire after ttl without jitter")
+	}
+
+	// 随机时长来自注入的来源，相同种子得到相同的结果
+	c := New(0, nil, 0, WithJitter(time.Minute), WithRand(rand.New(rand.NewSource(1))))
+	want := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		start := time.Now()
+		c.Add(key, String("v"), time.Hour)
+		expire, _, _ := c.Expiry(key)
+		jitter := time.Duration(want.Int63n(int64(time.Minute)))
+		if d := expire.Sub(start) - time.Hour - jitter; d < 0 || d > time.Second {
+			t.Fatalf("expire of %s is %v after ttl, want jitter %v", key, expire.Sub(start)-time.Hour, jitter)
+		}
+	}
+}
+
+func TestExpireStrategy(t *testing.T) {
+	testCases := []struct {
+		strategy      ExpireStrategy
+		shorten, grow bool // 缩短、延长 TTL 后过期时间是否被更新
+	}{
+		{ExtendOnly, false, true},
+		{AlwaysReplace, true, true},
+		{Shortest, true, false},
+	}
+	for _, tc := range testCases {
+		lru := New(0, nil, time.Hour, WithExpireStrategy(tc.strategy))
+		lru.Add("k", String("v1"), time.Hour)
+		first, _, _ := lru.Expiry("k")
+
+		lru.Add("k", String("v2"), time.Minute)
+		shortened, _, _ := lru.Expiry("k")
+		if got := shortened.Before(first); got != tc.shorten {
+			t.Fatalf("strategy %d: shorten updated = %v, want %v", tc.strategy, got, tc.shorten)
+		}
+
+		lru.Add("k", String("v3"), 2*time.Hour)
+		grown, _, _ := lru.Expiry("k")
+		if got := grown.After(shortened); got != tc.grow {
+			t.Fatalf("strategy %d: extend updated = %v, want %v", tc.strategy, got, tc.grow)
+		}
+		if v, ok := lru.Get("k"); !ok || string(v.(String)) != "v3" {
+			t.Fatalf("strategy %d: value should always be replaced, got %v", tc.strategy, v)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lru := New(int64(len(k1+k2+v1+v2)), nil, time.Hour)
+	lru.Add(k1, String(v1), time.Hour)
+	lru.Add(k2, String(v2), time.Hour)
+
+	if v, ok := lru.Peek(k1); !ok || string(v.(String)) != v1 {
+		t.Fatalf("Peek key1 = %v, %v", v, ok)
+	}
+	// Peek 不会把 key1 移到最前面，新增节点时它仍然最先被淘汰
+	lru.Add(k3, String(v3), time.Hour)
+	if _, ok := lru.Peek(k1); ok {
+		t.Fatal("peeked key1 should still be evicted")
+	}
+	if _, ok := lru.Peek(k2); !ok {
+		t.Fatal("key2 should be kept")
+	}
+
+	lru.Add("expired", String("v"), -time.Minute)
+	if _, ok := lru.Peek("expired"); ok {
+		t.Fatal("expired key should miss")
+	}
+	if _, ok := lru.Peek("unknown"); ok {
+		t.Fatal("unknown key should miss")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	lru := New(0, nil, time.Hour)
+	if keys := lru.Keys(); len(keys) != 0 {
+		t.Fatalf("empty cache keys = %v", keys)
+	}
+	lru.Add("a", String("1"), time.Hour)
+	lru.Add("b", String("2"), time.Hour)
+	lru.Add("expired", String("3"), -time.Minute)
+	lru.Add("c", String("4"), time.Hour)
+	lru.Get("a")
+	lru.Peek("b")
+
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"a", "c", "b"}) {
+		t.Fatalf("Keys = %v, want most recently used first", keys)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	var evicted []string
+	lru := New(0, func(key string, _ Value) { evicted = append(evicted, key) }, time.Hour, WithGrace(time.Hour))
+	lru.Add("live", String("v"), time.Hour)
+	lru.Add("grace", String("v"), -time.Minute)
+	lru.Add("gone", String("v"), -2*time.Hour)
+	if n := lru.RemoveExpired(); n != 1 || !reflect.DeepEqual(evicted, []string{"gone"}) {
+		t.Fatalf("RemoveExpired = %d, evicted %v", n, evicted)
+	}
+	if lru.Len() != 2 || lru.Bytes() != int64(len("live")+len("grace")+2) {
+		t.Fatalf("Len = %d, Bytes = %d", lru.Len(), lru.Bytes())
+	}
+}
+
+func TestCleanupInterval(t *testing.T) {
+	var mu sync.Mutex
+	evictions := 0
+	lru := New(0, func(string, Value) { evictions++ }, time.Hour, WithCleanupInterval(10*time.Millisecond, &mu))
+	defer lru.Close()
+	mu.Lock()
+	for i := 0; i < 10; i++ {
+		lru.Add(strconv.Itoa(i), String("v"), 20*time.Millisecond)
+	}
+	lru.Add("live", String("v"), time.Hour)
+	mu.Unlock()
+
+	// 过期的节点从未被访问，由 janitor 清理
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n, bytes, evicted := lru.Len(), lru.Bytes(), evictions
+		mu.Unlock()
+		if n == 1 && bytes == int64(len("live")+1) && evicted == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after cleanup: %d entries, %d bytes, %d evictions", n, bytes, evicted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lru.Close()
+	lru.Close()
+}
@@ -0,0 +1,104 @@
+// Package metrics 将 geecache 的统计信息导出为 Prometheus 指标。
+// 它是独立的模块，geecache 本身不依赖 Prometheus，不使用 Prometheus 的程序无需引入
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"geecache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Collector 是 prometheus.Collector，每次抓取时读取各个 Group 的 Stats 和 CacheBytes，
+// 并通过 UnaryClientInterceptor 记录访问远程节点的耗时
+type Collector struct {
+	groups []*geecache.Group
+
+	hits       *prometheus.Desc
+	hotHits    *prometheus.Desc
+	misses     *prometheus.Desc
+	peerLoads  *prometheus.Desc
+	localLoads *prometheus.Desc
+	evictions  *prometheus.Desc
+	items      *prometheus.Desc
+	bytes      *prometheus.Desc
+
+	peerLatency *prometheus.HistogramVec // 按远程节点和方法统计的gRPC调用耗时
+}
+
+// NewCollector 创建导出 groups 统计信息的 Collector，使用 prometheus.Registerer.Register 注册后生效
+func NewCollector(groups ...*geecache.Group) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("geecache_"+name, help, append([]string{"group"}, labels...), nil)
+	}
+	return &Collector{
+		groups:     groups,
+		hits:       desc("hits_total", "Number of Get calls served from the local hot or main cache."),
+		hotHits:    desc("hot_cache_hits_total", "Number of Get calls served from the hot cache."),
+		misses:     desc("misses_total", "Number of Get calls that missed the local cache."),
+		peerLoads:  desc("peer_loads_total", "Number of values loaded from remote peers."),
+		localLoads: desc("local_loads_total", "Number of values loaded from the data source."),
+		evictions:  desc("evictions_total", "Number of entries evicted for capacity or expiry."),
+		items:      desc("items", "Current number of entries in the cache.", "cache"),
+		bytes:      desc("bytes", "Bytes of keys and values held in the cache.", "cache"),
+		peerLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "geecache_peer_rpc_duration_seconds",
+			Help:    "Latency of gRPC calls to remote peers.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"peer", "method", "code"}),
+	}
+}
+
+// Describe 实现 prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.hits, c.hotHits, c.misses, c.peerLoads, c.localLoads, c.evictions, c.items, c.bytes} {
+		ch <- d
+	}
+	c.peerLatency.Describe(ch)
+}
+
+// Collect 实现 prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, g := range c.groups {
+		name := g.Name()
+		s := g.Stats()
+		counter := func(d *prometheus.Desc, v int64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), name)
+		}
+		counter(c.hits, s.Hits)
+		counter(c.hotHits, s.HotCacheHits)
+		counter(c.misses, s.Misses)
+		counter(c.peerLoads, s.PeerLoads)
+		counter(c.localLoads, s.LocalLoads)
+		counter(c.evictions, s.Evictions)
+		mainItems, hotItems := g.CacheItems()
+		ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(mainItems), name, "main")
+		ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(hotItems), name, "hot")
+		mainBytes, hotBytes := g.CacheBytes()
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(mainBytes), name, "main")
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(hotBytes), name, "hot")
+	}
+	c.peerLatency.Collect(ch)
+}
+
+// UnaryClientInterceptor 返回记录远程调用耗时的gRPC客户端拦截器，
+// 通过 geecache.WithDialOptions(grpc.WithChainUnaryInterceptor(...)) 安装到连接其他节点的客户端上
+func (c *Collector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.peerLatency.WithLabelValues(peerOf(cc.Target()), method[strings.LastIndex(method, "/")+1:], status.Code(err).String()).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// peerOf 从连接的目标地址(如 etcd:///geecache-127.0.0.1:8001)中取出节点地址
+func peerOf(target string) string {
+	return strings.TrimPrefix(target[strings.LastIndex(target, "/")+1:], "geecache-")
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"geecache"
+	pb "geecache/proto"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestCollector(t *testing.T) {
+	g := geecache.NewGroup("metrics", 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), geecache.WithTTL(time.Hour))
+	g.Get("Tom")
+	g.Get("Tom")
+	g.Get("Jack")
+
+	c := NewCollector(g)
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	mainBytes, _ := g.CacheBytes()
+	want := `
+# HELP geecache_bytes Bytes of keys and values held in the cache.
+# TYPE geecache_bytes gauge
+geecache_bytes{cache="hot",group="metrics"} 0
+geecache_bytes{cache="main",group="metrics"} ` + strconv.FormatInt(mainBytes, 10) + `
+# HELP geecache_hits_total Number of Get calls served from the local hot or main cache.
+# TYPE geecache_hits_total counter
+geecache_hits_total{group="metrics"} 1
+# HELP geecache_items Current number of entries in the cache.
+# TYPE geecache_items gauge
+geecache_items{cache="hot",group="metrics"} 0
+geecache_items{cache="main",group="metrics"} 2
+# HELP geecache_misses_total Number of Get calls that missed the local cache.
+# TYPE geecache_misses_total counter
+geecache_misses_total{group="metrics"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "geecache_bytes", "geecache_hits_total", "geecache_items", "geecache_misses_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 通过拦截器记录远程调用耗时
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := geecache.NewServer(lis.Addr().String())
+	grpcServer := grpc.NewServer()
+	pb.RegisterGroupCacheServer(grpcServer, server)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(c.UnaryClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGroupCacheClient(conn)
+	client.Get(context.Background(), &pb.Request{Group: "metrics", Key: "Tom"})
+	client.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "Tom"})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make(map[string]uint64)
+	for _, f := range families {
+		if f.GetName() != "geecache_peer_rpc_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["peer"] != lis.Addr().String() || labels["method"] != "Get" {
+				t.Fatalf("unexpected labels %v", labels)
+			}
+			codes[labels["code"]] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	if codes["OK"] != 1 || codes["NotFound"] != 1 {
+		t.Fatalf("peer latency samples by code = %v", codes)
+	}
+}
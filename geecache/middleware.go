@@ -0,0 +1,23 @@
+package geecache
+
+import "context"
+
+// LoadFunc 加载一个未命中缓存的 key：从远程节点或本地数据源获取
+type LoadFunc func(ctx context.Context, key string) (ByteView, error)
+
+// Middleware 包装 LoadFunc，用于在加载前后插入日志、指标、鉴权或改写 key/value 等横切逻辑，
+// 返回的 LoadFunc 可以不调用 next 以直接返回结果或错误
+type Middleware func(next LoadFunc) LoadFunc
+
+// Use 为 Get 未命中缓存时的加载注册中间件，多次调用会追加到已有的中间件之后。
+// 先注册的中间件在外层，即最先看到请求、最后看到结果。
+// 中间件运行在 singleflight 之内，并发的同一个 key 只会经过一次；Refresh 的加载同样经过中间件。
+// 与 RegisterPeers 一样，Use 需要在 Group 开始服务之前调用
+func (g *Group) Use(mw ...Middleware) {
+	g.middlewares = append(g.middlewares, mw...)
+	chain := LoadFunc(g.loadUncached)
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		chain = g.middlewares[i](chain)
+	}
+	g.loadChain = chain
+}
@@ -0,0 +1,58 @@
+package geecache
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	gee := NewGroup("middleware", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		calls = append(calls, "getter "+key)
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+
+	record := func(name string) Middleware {
+		return func(next LoadFunc) LoadFunc {
+			return func(ctx context.Context, key string) (ByteView, error) {
+				calls = append(calls, name+" before "+key)
+				v, err := next(ctx, key)
+				calls = append(calls, name+" after "+v.String())
+				return v, err
+			}
+		}
+	}
+	// 统一 key 的大小写，数据源只看到小写的 key
+	lower := func(next LoadFunc) LoadFunc {
+		return func(ctx context.Context, key string) (ByteView, error) {
+			return next(ctx, strings.ToLower(key))
+		}
+	}
+	gee.Use(record("outer"), lower)
+	gee.Use(record("inner"))
+
+	v, err := gee.Get("Tom")
+	if err != nil || v.String() != "tom-value" {
+		t.Fatalf("Get Tom = %q, %v", v.String(), err)
+	}
+	want := []string{
+		"outer before Tom",
+		"inner before tom",
+		"getter tom",
+		"inner after tom-value",
+		"outer after tom-value",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %q, want %q", calls, want)
+	}
+
+	// 命中缓存时不经过中间件
+	calls = nil
+	gee.Get("tom")
+	if len(calls) != 0 {
+		t.Fatalf("cache hit should skip middleware, got %q", calls)
+	}
+}
@@ -1,6 +1,11 @@
 package geecache
 
-import "geecache/proto"
+import (
+	"context"
+	"geecache/proto"
+	"geecache/registry"
+	"reflect"
+)
 
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter,ok bool)	// 根据传入的 key 选择相应节点 PeerGetter
@@ -8,4 +13,95 @@ type PeerPicker interface {
 
 type PeerGetter interface {
 	Get(in *proto.Request, out *proto.Response) error	// 用于从对应 group 查找缓存值
-}
\ No newline at end of file
+}
+
+// PeerGetterContext 是可选接口，PeerGetter 实现后远程加载会使用 GetContext 的 ctx，调用方放弃时请求随之取消
+type PeerGetterContext interface {
+	GetContext(ctx context.Context, in *proto.Request, out *proto.Response) error
+}
+
+// peerGet 从 peer 获取数据，peer 实现了 PeerGetterContext 时传递 ctx
+func peerGet(ctx context.Context, peer PeerGetter, in *proto.Request, out *proto.Response) error {
+	if pc, ok := peer.(PeerGetterContext); ok {
+		return pc.GetContext(ctx, in, out)
+	}
+	return peer.Get(in, out)
+}
+
+// isNilPeer 判断 peer 是否为 nil，包括装在接口中的 nil 指针
+func isNilPeer(peer PeerGetter) bool {
+	if peer == nil {
+		return true
+	}
+	v := reflect.ValueOf(peer)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// PeerIncrementer 可以在远程节点上原子地增加计数器，PeerGetter 实现该接口后 Group.Increment 才能转发给负责的节点
+type PeerIncrementer interface {
+	Increment(in *proto.IncrementRequest, out *proto.IncrementResponse) error
+}
+
+// PeerSetter 可以将数据写入远程节点，PeerGetter 实现该接口后 Group.Set 才能转发给负责的节点
+type PeerSetter interface {
+	Set(in *proto.SetRequest, out *proto.SetResponse) error
+}
+
+// PeerMultiGetter 可以一次从远程节点获取多个key，PeerGetter 实现该接口后 Group.GetMulti 才会合并请求
+type PeerMultiGetter interface {
+	GetMulti(in *proto.MultiRequest, out *proto.MultiResponse) error
+}
+
+// PeerBroadcaster 可以将数据推送给集群中的所有节点，PeerPicker 实现该接口后热点数据可以被广播
+type PeerBroadcaster interface {
+	Broadcast(in *proto.Request, value []byte) error // 将 group/key 对应的 value 推送给其他所有节点
+}
+
+// ReplicaSet 是负责某个key的一组节点：一个主节点和若干只读副本
+type ReplicaSet struct {
+	Primary  PeerGetter   // 主节点，为 nil 表示当前节点就是主节点(或没有可用节点)
+	Replicas []PeerGetter // 除主节点和当前节点外的只读副本，按查询顺序排列
+	Self     bool         // 当前节点是否属于该副本集合
+}
+
+// ReplicaPicker 用于主从读写分离，PeerPicker 实现该接口后 WithReadReplicas 才会生效
+type ReplicaPicker interface {
+	GetWithReplicas(key string, n int) ReplicaSet // 返回 key 的副本集合，n 为包括主节点在内的节点数
+}
+
+// PeerLookup 可以根据节点地址返回对应的 PeerGetter，PeerPicker 实现该接口后 WithLoadLock 才能从完成加载的节点读取
+type PeerLookup interface {
+	LookupPeer(addr string) (peer PeerGetter, ok bool) // addr 为当前节点或未知节点时返回 false
+}
+
+// LoadLocker 在集群范围内协调同一个key从数据源的加载，见 WithLoadLock，registry.LoadLock 是基于etcd的实现
+type LoadLocker interface {
+	// LockLoad 阻塞直到获得 group/key 的加载锁，返回最近完成该key加载的节点地址(没有时为空)，
+	// 加载结束后调用 unlock 释放锁，loaded 表示当前节点是否从数据源完成了加载
+	LockLoad(ctx context.Context, group, key string) (loadedBy string, unlock func(loaded bool), err error)
+}
+
+// Discovery 是节点注册与发现的后端，见 WithDiscovery，registry.EtcdDiscovery 是基于etcd的实现，
+// 也可以接入 Consul、Kubernetes DNS 等
+type Discovery interface {
+	// Register 将节点 addr 注册为服务 service 并保持在线，直到 stop 被关闭后注销并返回
+	Register(service, addr string, stop chan error) error
+	// Watch 监听服务 service 的节点变化，每次变化后发送完整的节点地址列表，ctx 取消后关闭通道
+	Watch(ctx context.Context, service string) (<-chan []string, error)
+	// Resolve 返回服务 service 当前的所有节点地址
+	Resolve(service string) ([]string, error)
+}
+
+// MetadataDiscovery 是还能提供节点元数据的 Discovery，Server 在节点变化时同步元数据，
+// 并按其中的 Weight 分配哈希环上的虚拟节点，registry.EtcdDiscovery 实现了该接口
+type MetadataDiscovery interface {
+	Discovery
+	// Metadata 返回服务 service 下所有节点的地址及其元数据
+	Metadata(service string) (map[string]registry.Metadata, error)
+}
+
+// InvalidationBus 在节点之间广播缓存失效通知，见 WithInvalidationBus，registry.InvalidationBus 是基于etcd的实现
+type InvalidationBus interface {
+	Publish(ctx context.Context, group, key string) error                 // 发布 group/key 的失效通知
+	Subscribe(group string, fn func(key string)) (cancel func(), err error) // 监听 group 的失效通知
+}
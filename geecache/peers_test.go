@@ -0,0 +1,332 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "geecache/proto"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePeer 模拟远程节点，总是返回 key 本身作为值
+type fakePeer struct{}
+
+func (fakePeer) Get(in *pb.Request, out *pb.Response) error {
+	out.Value = []byte(in.GetKey())
+	return nil
+}
+
+// fakePeers 将所有 key 路由到 fakePeer，并记录被广播的 key
+type fakePeers struct {
+	broadcasts chan string
+}
+
+func (p *fakePeers) PickPeer(key string) (PeerGetter, bool) {
+	return fakePeer{}, true
+}
+
+func (p *fakePeers) Broadcast(in *pb.Request, value []byte) error {
+	p.broadcasts <- in.GetGroup() + "/" + in.GetKey() + "=" + string(value)
+	return nil
+}
+
+func TestPromotionPolicy(t *testing.T) {
+	gee := NewGroup("promotion", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithTTL(time.Hour), WithPromotionPolicy("config:", PromoteBroadcast))
+	peers := &fakePeers{broadcasts: make(chan string, 10)}
+	gee.RegisterPeers(peers)
+
+	// 远程访问达到阈值后成为热点
+	for i := 0; i < defaultMaxMinuteRemoteQPS; i++ {
+		gee.Get("config:flags")
+		gee.Get("user:1")
+	}
+	if _, ok := gee.hotCache.get("config:flags"); !ok {
+		t.Fatal("config:flags should be promoted to hotCache")
+	}
+	if _, ok := gee.hotCache.get("user:1"); !ok {
+		t.Fatal("user:1 should be promoted to hotCache")
+	}
+
+	select {
+	case got := <-peers.broadcasts:
+		if got != "promotion/config:flags=config:flags" {
+			t.Fatalf("unexpected broadcast %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("config:flags should be broadcast on promotion")
+	}
+	select {
+	case got := <-peers.broadcasts:
+		t.Fatalf("only config:flags should be broadcast, got %s", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// groupPeer 将请求直接转发给同进程中的另一个 Group，模拟集群中的其他节点
+type groupPeer struct {
+	g         *Group
+	gets      int32 // 普通请求次数
+	cacheGets int32 // 只查询缓存的请求次数
+}
+
+func (p *groupPeer) Get(in *pb.Request, out *pb.Response) error {
+	if in.GetCacheOnly() {
+		atomic.AddInt32(&p.cacheGets, 1)
+		v, ok := p.g.getCached(in.GetKey())
+		if !ok {
+			return errCacheMiss
+		}
+		out.Value = v.ByteSlice()
+		return nil
+	}
+	atomic.AddInt32(&p.gets, 1)
+	get := p.g.Get
+	if in.GetRefresh() {
+		get = p.g.Refresh
+	}
+	v, err := get(in.GetKey())
+	if err != nil {
+		return err
+	}
+	out.Value = v.ByteSlice()
+	return nil
+}
+
+// fixedReplicas 对所有 key 返回相同的副本集合
+type fixedReplicas struct {
+	set ReplicaSet
+}
+
+func (p *fixedReplicas) PickPeer(key string) (PeerGetter, bool) {
+	return p.set.Primary, p.set.Primary != nil
+}
+
+func (p *fixedReplicas) GetWithReplicas(key string, n int) ReplicaSet {
+	return p.set
+}
+
+func TestReadReplicas(t *testing.T) {
+	var loads int32
+	primary := NewGroup("replica-primary", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(key), nil
+	}), WithTTL(time.Hour))
+	noLoad := GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should only be loaded by the primary", key)
+		return nil, nil
+	})
+	replica := NewGroup("replica-replica", 2<<10, noLoad, WithTTL(time.Hour), WithReadReplicas(3))
+	client := NewGroup("replica-client", 2<<10, noLoad, WithTTL(time.Hour), WithReadReplicas(3))
+
+	primaryPeer := &groupPeer{g: primary}
+	replicaPeer := &groupPeer{g: replica}
+	replica.RegisterPeers(&fixedReplicas{set: ReplicaSet{Primary: primaryPeer, Self: true}})
+	client.RegisterPeers(&fixedReplicas{set: ReplicaSet{Primary: primaryPeer, Replicas: []PeerGetter{replicaPeer}}})
+
+	// 副本未命中，经主节点加载
+	if v, err := client.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("client get = %v, %v", v, err)
+	}
+	if loads != 1 || primaryPeer.gets != 1 || replicaPeer.cacheGets != 1 {
+		t.Fatalf("loads=%d primary gets=%d replica cache gets=%d", loads, primaryPeer.gets, replicaPeer.cacheGets)
+	}
+
+	// 副本自身读取时从主节点取回并保存
+	if v, err := replica.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("replica get = %v, %v", v, err)
+	}
+	if _, ok := replica.mainCache.get("k"); !ok {
+		t.Fatal("replica should keep the value fetched from the primary")
+	}
+
+	// 之后的读取由副本提供，不再访问主节点
+	gets := primaryPeer.gets
+	for i := 0; i < 3; i++ {
+		if v, err := client.Get("k"); err != nil || v.String() != "k" {
+			t.Fatalf("client get = %v, %v", v, err)
+		}
+	}
+	if primaryPeer.gets != gets || replicaPeer.cacheGets != 4 || loads != 1 {
+		t.Fatalf("reads should hit the replica: primary gets=%d replica cache gets=%d loads=%d",
+			primaryPeer.gets, replicaPeer.cacheGets, loads)
+	}
+}
+
+// nilPeers 模拟有缺陷的 PeerPicker，选中节点却返回 nil
+type nilPeers struct {
+	peer PeerGetter
+}
+
+func (p nilPeers) PickPeer(key string) (PeerGetter, bool) {
+	return p.peer, true
+}
+
+func TestNilPeerGetter(t *testing.T) {
+	for name, peer := range map[string]PeerGetter{"nil": nil, "typed-nil": (*Client)(nil)} {
+		loads := 0
+		gee := NewGroup("nil-peer-"+name, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}), WithTTL(time.Hour))
+		gee.RegisterPeers(nilPeers{peer: peer})
+
+		v, err := gee.Get("k")
+		if err != nil || v.String() != "k" || loads != 1 {
+			t.Fatalf("%s peer: Get = %v, %v with %d loads, want local fallback", name, v, err, loads)
+		}
+	}
+}
+
+// downPeer 模拟不可达的远程节点
+type downPeer struct{}
+
+func (downPeer) Get(in *pb.Request, out *pb.Response) error {
+	return errors.New("connection refused")
+}
+
+func TestStaleOnPeerError(t *testing.T) {
+	for _, stale := range []bool{true, false} {
+		loads := 0
+		opts := []GroupOption{WithTTL(-time.Hour)} // 写入即过期
+		if stale {
+			opts = append(opts, WithStaleOnPeerError(2*time.Hour))
+		}
+		gee := NewGroup(fmt.Sprintf("stale-on-error-%v", stale), 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte(fmt.Sprintf("%s-%d", key, loads)), nil
+		}), opts...)
+		gee.RegisterPeers(nilPeers{peer: downPeer{}})
+
+		// 负责该key的节点不可达，第一次只能从数据源加载
+		if v, err := gee.Get("k"); err != nil || v.String() != "k-1" {
+			t.Fatalf("first get = %v, %v", v, err)
+		}
+		// 本地副本已经过期，节点仍不可达
+		v, err := gee.Get("k")
+		if stale && (err != nil || v.String() != "k-1" || loads != 1) {
+			t.Fatalf("stale copy should be served: %v, %v with %d loads", v, err, loads)
+		}
+		if !stale && (err != nil || v.String() != "k-2" || loads != 2) {
+			t.Fatalf("without the option the source should be hit again: %v, %v with %d loads", v, err, loads)
+		}
+	}
+}
+
+// mismatchedPeer 模拟没有注册请求的 Group 的远程节点
+type mismatchedPeer struct{}
+
+func (mismatchedPeer) Get(in *pb.Request, out *pb.Response) error {
+	return fmt.Errorf("%w: %s", ErrGroupNotFound, in.GetGroup())
+}
+
+func TestPeerGroupNotFound(t *testing.T) {
+	loads := 0
+	gee := NewGroup("peer-group-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithTTL(-time.Hour), WithStaleOnPeerError(time.Hour))
+	gee.populateCache("k", ByteView{b: []byte("stale")})
+	gee.RegisterPeers(nilPeers{peer: mismatchedPeer{}})
+
+	// 远程节点没有该 Group 时既不返回旧值，也不退回本地数据源
+	if v, err := gee.Get("k"); !errors.Is(err, ErrGroupNotFound) || loads != 0 {
+		t.Fatalf("Get = %q, %v with %d loads, want ErrGroupNotFound", v.String(), err, loads)
+	}
+}
+
+func TestMaxStaleness(t *testing.T) {
+	for name, opts := range map[string][]GroupOption{
+		"stale-on-error":         {WithTTL(-time.Hour), WithStaleOnPeerError(2 * time.Hour)},
+		"stale-while-revalidate": {WithTTL(time.Hour), WithSoftTTL(time.Millisecond)},
+	} {
+		var down atomic.Bool
+		gee := NewGroup("max-staleness-"+name, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			if down.Load() {
+				return nil, errors.New("source is down")
+			}
+			return []byte(key + "-value"), nil
+		}), append(opts, WithMaxStaleness(100*time.Millisecond))...)
+		gee.RegisterPeers(nilPeers{peer: downPeer{}})
+
+		if v, err := gee.Get("k"); err != nil || v.String() != "k-value" {
+			t.Fatalf("%s: first get = %v, %v", name, v, err)
+		}
+		// 数据源和远程节点都不可用，未超过上限的旧值仍然返回
+		down.Store(true)
+		time.Sleep(5 * time.Millisecond)
+		if v, err := gee.Get("k"); err != nil || v.String() != "k-value" {
+			t.Fatalf("%s: stale copy within the ceiling = %v, %v", name, v, err)
+		}
+		// 超过上限后不再返回旧值
+		time.Sleep(150 * time.Millisecond)
+		if v, err := gee.Get("k"); err == nil {
+			t.Fatalf("%s: stale copy beyond the ceiling should not be served, got %q", name, v.String())
+		}
+	}
+}
+
+func TestRegisterPeersConcurrentGet(t *testing.T) {
+	NewGroup("register-race", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-local"), nil
+	}), WithTTL(time.Hour))
+
+	// 其他 goroutine 在 RegisterPeers 之前就通过 GetGroup 开始读取
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := GetGroup("register-race")
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d-%d", i, j)
+				v, err := g.Get(key)
+				if err != nil || (v.String() != key && v.String() != key+"-local") {
+					t.Errorf("Get(%s) = %q, %v", key, v.String(), err)
+				}
+			}
+		}(i)
+	}
+	GetGroup("register-race").RegisterPeers(&fakePeers{})
+	wg.Wait()
+
+	// 注册完成后未命中的 key 从远程节点加载
+	if v, _ := GetGroup("register-race").Get("after"); v.String() != "after" {
+		t.Fatalf("Get after RegisterPeers = %q", v.String())
+	}
+}
+
+// lookupBus 在订阅时查找 Group，用于检查订阅不在全局锁内进行
+type lookupBus struct {
+	cancelled chan struct{}
+}
+
+func (b lookupBus) Publish(context.Context, string, string) error { return nil }
+
+func (b lookupBus) Subscribe(group string, fn func(key string)) (func(), error) {
+	GetGroup(group)
+	return func() { close(b.cancelled) }, nil
+}
+
+func TestInvalidationSubscribeOutsideLock(t *testing.T) {
+	bus := lookupBus{cancelled: make(chan struct{})}
+	done := make(chan *Group)
+	go func() {
+		done <- NewGroup("subscribe-unlocked", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithInvalidationBus(bus))
+	}()
+	select {
+	case g := <-done:
+		if g.unsubscribe == nil {
+			t.Fatal("the subscription's cancel func should be kept")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("NewGroup deadlocked subscribing under the global lock")
+	}
+}
@@ -0,0 +1,76 @@
+package geecache
+
+import "sync/atomic"
+
+const (
+	defaultBackgroundWorkers = 16 // 后台任务默认的最大并发数
+	maxFanout                = 16 // 同时向多个节点发送请求(如广播热点数据)时的并发上限
+)
+
+// workerPool 限制后台任务的并发数，池满时 trySubmit 的新任务直接被丢弃而不是排队
+type workerPool struct {
+	sem     chan struct{}
+	active  AtomicInt // 正在执行的任务数
+	dropped AtomicInt // 因池已满被 trySubmit 丢弃的任务数
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &workerPool{sem: make(chan struct{}, size)}
+}
+
+// trySubmit 在新的 goroutine 中执行 fn，池已满时不执行并返回 false
+func (p *workerPool) trySubmit(fn func()) bool {
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+	p.run(fn)
+	return true
+}
+
+// submit 与 trySubmit 相同，但池已满时等待有任务结束后再执行，不会丢弃 fn
+func (p *workerPool) submit(fn func()) {
+	p.sem <- struct{}{}
+	p.run(fn)
+}
+
+// run 在新的 goroutine 中执行 fn，调用前需已占用 sem 中的一个位置
+func (p *workerPool) run(fn func()) {
+	p.active.Add(1)
+	go func() {
+		defer func() {
+			p.active.Add(-1)
+			<-p.sem
+		}()
+		fn()
+	}()
+}
+
+// background 是包内所有后台任务(如陈旧数据的后台刷新)共用的协程池
+var background atomic.Pointer[workerPool]
+
+func init() {
+	background.Store(newWorkerPool(defaultBackgroundWorkers))
+}
+
+// SetBackgroundWorkers 设置后台任务的最大并发数，超出时新的后台任务会被丢弃(之后的请求会再次触发)
+// 已经在执行的任务不受影响，建议在创建 Group 之前调用
+func SetBackgroundWorkers(n int) {
+	background.Store(newWorkerPool(n))
+}
+
+// ActiveBackgroundWorkers 返回当前正在执行的后台任务数
+func ActiveBackgroundWorkers() int64 {
+	return background.Load().active.Get()
+}
+
+// DroppedBackgroundTasks 返回当前协程池因已满而丢弃的后台任务数，SetBackgroundWorkers 会创建新的池并重新计数。
+// 每个 Group 被丢弃的任务见 Stats.DroppedBackgroundTasks
+func DroppedBackgroundTasks() int64 {
+	return background.Load().dropped.Get()
+}
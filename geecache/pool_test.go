@@ -0,0 +1,100 @@
+package geecache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(2)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		if p.trySubmit(func() {
+			defer wg.Done()
+			<-release
+		}) {
+			accepted++
+		} else {
+			wg.Done()
+		}
+	}
+	if accepted != 2 || p.active.Get() != 2 || p.dropped.Get() != 8 {
+		t.Fatalf("pool of size 2 accepted %d tasks, %d active, %d dropped", accepted, p.active.Get(), p.dropped.Get())
+	}
+
+	close(release)
+	wg.Wait()
+	for i := 0; i < 100 && p.active.Get() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if p.active.Get() != 0 || !p.trySubmit(func() {}) {
+		t.Fatalf("pool should accept tasks again after workers finish")
+	}
+}
+
+func TestBackgroundRefreshRespectsPool(t *testing.T) {
+	SetBackgroundWorkers(1)
+	defer SetBackgroundWorkers(defaultBackgroundWorkers)
+
+	release := make(chan struct{})
+	loads := make(chan string, 10)
+	gee := NewGroup("pool", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads <- key
+		if len(loads) > 2 {
+			<-release
+		}
+		return []byte(key), nil
+	}), WithTTL(time.Hour), WithSoftTTL(time.Millisecond))
+
+	gee.Get("Tom")
+	gee.Get("Jack")
+	time.Sleep(5 * time.Millisecond)
+	// 两个陈旧的键同时需要后台刷新，但池中只有一个 worker
+	gee.Get("Tom")
+	gee.Get("Jack")
+	time.Sleep(5 * time.Millisecond)
+	if n := ActiveBackgroundWorkers(); n != 1 {
+		t.Fatalf("expected 1 active background worker, got %d", n)
+	}
+	if len(loads) != 3 {
+		t.Fatalf("expected only one background refresh, got %d loads", len(loads))
+	}
+	// 被丢弃的刷新计入统计
+	if n := gee.Stats().DroppedBackgroundTasks; n != 1 {
+		t.Fatalf("DroppedBackgroundTasks = %d, want 1", n)
+	}
+	if n := DroppedBackgroundTasks(); n != 1 {
+		t.Fatalf("pool dropped %d tasks, want 1", n)
+	}
+	close(release)
+}
+
+func TestWorkerPoolSubmitWaits(t *testing.T) {
+	p := newWorkerPool(2)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	running, peak := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		p.submit(func() {
+			defer wg.Done()
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	// 池满时等待而不是丢弃，所有任务都执行了且并发不超过池的大小
+	if peak > 2 || p.dropped.Get() != 0 {
+		t.Fatalf("peak concurrency %d, %d dropped", peak, p.dropped.Get())
+	}
+}
@@ -1,22 +1,151 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"go.etcd.io/etcd/client/v3/naming/resolver"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 // EtcdDial 向grpc请求一个服务，通过提供一个etcd client和service name即可获得Connection
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// creds 为连接使用的传输层凭证，为 nil 时使用不加密的连接，opts 为附加的连接选项(如客户端拦截器)。
+// 连接不会阻塞等待节点可达，节点不可达时由之后的请求按各自的超时返回错误
+func EtcdDial(c *clientv3.Client, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c) //使用etcd客户端构建了一个服务发现的构建器。
 	if err != nil {                             
 		return nil, err
 	}
-	return grpc.Dial(
-		"etcd:///"+service,                                       //指定了服务的地址
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	opts = append([]grpc.DialOption{
 		grpc.WithResolvers(etcdResolver),                         //用于服务发现的解析器
-		grpc.WithTransportCredentials(insecure.NewCredentials()), 
-		grpc.WithBlock(),                                         
+		grpc.WithTransportCredentials(creds),                     //传输层凭证
+	}, opts...)
+	return grpc.NewClient(
+		"etcd:///"+service,                                       //指定了服务的地址
+		opts...,
 	)
-} 
\ No newline at end of file
+}
+
+// listTimeout 是读取节点列表的超时时间，etcd不可达时 Resolve 等调用不会一直阻塞
+const listTimeout = 5 * time.Second
+
+// ListEndpoints 返回服务 service 下所有已注册节点的地址及其元数据
+func ListEndpoints(c *clientv3.Client, service string) (map[string]Metadata, error) {
+	em, err := endpoints.NewManager(c, service)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c.Ctx(), listTimeout)
+	defer cancel()
+	eps, err := em.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[string]Metadata, len(eps))
+	for _, ep := range eps {
+		md, err := decodeMetadata(ep.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("decode metadata of %s: %v", ep.Addr, err)
+		}
+		peers[ep.Addr] = md
+	}
+	return peers, nil
+}
+
+// decodeMetadata 将从etcd读出的 endpoint 元数据(JSON解码后的 map)转换为 Metadata
+func decodeMetadata(v interface{}) (Metadata, error) {
+	var md Metadata
+	if v == nil {
+		return md, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return md, err
+	}
+	err = json.Unmarshal(b, &md)
+	return md, err
+}
+
+// EtcdDiscovery 基于etcd的节点注册与发现，注册的节点带有租约和心跳，节点崩溃后自动下线
+type EtcdDiscovery struct {
+	cli *clientv3.Client
+	md  Metadata // 注册节点时携带的元数据
+}
+
+// NewEtcdDiscovery 使用 cli 创建节点注册与发现，Register 注册的节点携带元数据 md
+func NewEtcdDiscovery(cli *clientv3.Client, md Metadata) *EtcdDiscovery {
+	return &EtcdDiscovery{cli: cli, md: md}
+}
+
+// Register 将 addr 注册为服务 service 的节点并保持心跳，stop 被关闭后撤销租约。与 Register 一样，没有错误时不会返回
+func (d *EtcdDiscovery) Register(service, addr string, stop chan error) error {
+	return register(etcdLease{d.cli}, []Endpoint{{Service: service, Addr: addr, Metadata: d.md}}, stop)
+}
+
+// Resolve 返回服务 service 当前所有已注册节点的地址，已排序
+func (d *EtcdDiscovery) Resolve(service string) ([]string, error) {
+	peers, err := ListEndpoints(d.cli, service)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// Metadata 返回服务 service 下所有已注册节点的地址及其元数据
+func (d *EtcdDiscovery) Metadata(service string) (map[string]Metadata, error) {
+	return ListEndpoints(d.cli, service)
+}
+
+// Watch 监听服务 service 的节点变化，每次变化后发送排序后的完整节点列表，已有节点时首先发送当前的所有节点。
+// ctx 取消后停止监听并关闭通道
+func (d *EtcdDiscovery) Watch(ctx context.Context, service string) (<-chan []string, error) {
+	em, err := endpoints.NewManager(d.cli, service)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []string, 1)
+	go func() {
+		defer close(ch)
+		peers := make(map[string]string) // etcd中的键 -> 节点地址
+		for batch := range updates {
+			for _, u := range batch {
+				switch u.Op {
+				case endpoints.Add:
+					peers[u.Key] = u.Endpoint.Addr
+				case endpoints.Delete:
+					delete(peers, u.Key)
+				}
+			}
+			addrs := make([]string, 0, len(peers))
+			for _, addr := range peers {
+				addrs = append(addrs, addr)
+			}
+			sort.Strings(addrs)
+			select {
+			case ch <- addrs:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
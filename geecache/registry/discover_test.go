@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	md := Metadata{Zone: "us-east-1a", Weight: 2, Version: "v1.2.0"}
+	// endpoints.Manager 以 JSON 形式将 endpoint 写入etcd，读出时元数据变为 map
+	b, err := json.Marshal(endpoints.Endpoint{Addr: "10.0.0.1:8001", Metadata: md})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ep endpoints.Endpoint
+	if err := json.Unmarshal(b, &ep); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeMetadata(ep.Metadata)
+	if err != nil || got != md {
+		t.Fatalf("decodeMetadata = %+v, %v, want %+v", got, err, md)
+	}
+
+	if got, err := decodeMetadata(nil); err != nil || got != (Metadata{}) {
+		t.Fatalf("decodeMetadata(nil) = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// invalidatePrefix 失效通知在etcd中的键前缀
+const invalidatePrefix = "geecache-invalidate/"
+
+// resubscribeDelay 监听失败后重新监听前的等待时间
+var resubscribeDelay = time.Second
+
+// InvalidationBus 通过etcd在节点之间广播缓存失效通知：发布方写入一条带租约的记录，
+// 所有节点监听记录的写入并在本地删除对应的key，不需要节点之间直接连接。
+// 记录在 ttl 秒内随租约过期删除，不会在etcd中堆积
+type InvalidationBus struct {
+	cli   *clientv3.Client
+	lease *sharedLease // 失效记录复用的租约
+}
+
+// NewInvalidationBus 创建一个失效通知总线，ttl 为失效记录在etcd中保留的时长(秒)
+func NewInvalidationBus(cli *clientv3.Client, ttl int) *InvalidationBus {
+	return &InvalidationBus{cli: cli, lease: &sharedLease{cli: cli, ttl: ttl}}
+}
+
+// Publish 发布 group/key 的失效通知。半个 ttl 内的通知复用同一个租约，记录至少保留 ttl/2 秒
+func (b *InvalidationBus) Publish(ctx context.Context, group, key string) error {
+	return b.lease.put(ctx, invalidatePrefix+group+"/"+key, "")
+}
+
+// Subscribe 监听 group 的失效通知，每收到一条调用一次 fn，调用 cancel 停止监听。
+// 从订阅时的版本开始监听，订阅返回后发布的通知都不会遗漏；监听出错时从上次处理到的版本重新监听，
+// 该版本已被压缩时从压缩后最早的版本继续，压缩掉的通知对应的key保留到过期
+func (b *InvalidationBus) Subscribe(group string, fn func(key string)) (cancel func(), err error) {
+	prefix := invalidatePrefix + group + "/"
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := b.cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		rev := resp.Header.Revision + 1
+		for {
+			rev = b.watch(ctx, group, prefix, rev, fn)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resubscribeDelay):
+			}
+		}
+	}()
+	return cancel, nil
+}
+
+// watch 从版本 rev 开始监听 prefix 下的失效通知，直到监听出错或被取消，返回下次应从哪个版本重新监听
+func (b *InvalidationBus) watch(ctx context.Context, group, prefix string, rev int64, fn func(key string)) int64 {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	for wresp := range b.cli.Watch(wctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev)) {
+		if wresp.CompactRevision > 0 {
+			log.Printf("watch invalidations of %s: revision %d compacted, resuming from %d", group, rev, wresp.CompactRevision)
+			return wresp.CompactRevision
+		}
+		if err := wresp.Err(); err != nil {
+			log.Printf("watch invalidations of %s: %v", group, err)
+			return rev
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypePut { // 租约过期产生的删除事件不是失效通知
+				fn(strings.TrimPrefix(string(ev.Kv.Key), prefix))
+			}
+			rev = ev.Kv.ModRevision + 1
+		}
+	}
+	return rev
+}
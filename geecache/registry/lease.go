@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// sharedLease 在 ttl/2 内复用同一个租约，避免每条短期记录都向etcd申请新租约。
+// 绑定在它上面的记录至少保留 ttl/2 秒，最多保留 ttl 秒
+type sharedLease struct {
+	cli *clientv3.Client
+	ttl int // 租约时长，单位为秒
+
+	mu      sync.Mutex
+	id      clientv3.LeaseID // 当前复用的租约，为0表示还没有申请
+	grantAt time.Time        // 申请 id 的时间
+}
+
+// get 返回可复用的租约，申请已超过半个 ttl 时申请新的租约
+func (l *sharedLease) get(ctx context.Context) (clientv3.LeaseID, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.id != 0 && time.Since(l.grantAt) < time.Duration(l.ttl)*time.Second/2 {
+		return l.id, nil
+	}
+	resp, err := l.cli.Grant(ctx, int64(l.ttl))
+	if err != nil {
+		return 0, err
+	}
+	l.id, l.grantAt = resp.ID, time.Now()
+	return l.id, nil
+}
+
+// reset 丢弃失效的租约 id，之后的 get 会申请新的租约
+func (l *sharedLease) reset(id clientv3.LeaseID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.id == id {
+		l.id = 0
+	}
+}
+
+// put 在复用的租约上写入 key，租约已被提前撤销时重新申请后再试一次
+func (l *sharedLease) put(ctx context.Context, key, val string) error {
+	id, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = l.cli.Put(ctx, key, val, clientv3.WithLease(id))
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		l.reset(id)
+		if id, err = l.get(ctx); err != nil {
+			return err
+		}
+		_, err = l.cli.Put(ctx, key, val, clientv3.WithLease(id))
+	}
+	return err
+}
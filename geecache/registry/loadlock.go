@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"context"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// loadLockPrefix 加载锁及加载记录在etcd中的键前缀
+const loadLockPrefix = "geecache-loadlock/"
+
+// LoadLock 基于etcd租约的按key加载锁，保证集群成员变化期间多个节点都认为自己负责某个key时，
+// 同一时间只有一个节点从数据源加载该key。所有锁共用一个 ttl 秒的会话租约，加载记录复用短期租约，
+// 节点崩溃后自动释放
+type LoadLock struct {
+	cli    *clientv3.Client
+	self   string       // 当前节点地址，完成加载后记录在etcd中
+	ttl    int          // 锁和加载记录的租约时长，单位为秒
+	loaded *sharedLease // 加载记录复用的租约
+
+	sessionMu sync.Mutex
+	session   *concurrency.Session // 所有锁共用的会话，租约过期后重新创建
+
+	mu   sync.Mutex
+	held map[string]chan struct{} // 本节点持有的锁，释放时关闭。同一会话的锁在etcd中不互斥，需要在本地排队
+}
+
+// NewLoadLock 创建一个加载锁，self 为当前节点地址，ttl 为锁和加载记录的租约时长(秒)
+func NewLoadLock(cli *clientv3.Client, self string, ttl int) *LoadLock {
+	return &LoadLock{
+		cli:    cli,
+		self:   self,
+		ttl:    ttl,
+		loaded: &sharedLease{cli: cli, ttl: ttl},
+		held:   make(map[string]chan struct{}),
+	}
+}
+
+// LockLoad 阻塞直到获得 group/key 的加载锁，返回 ttl 内最近一次完成该key加载的节点地址，没有时为空。
+// 加载结束后调用 unlock 释放锁，loaded 为 true 时记录当前节点完成了加载
+func (l *LoadLock) LockLoad(ctx context.Context, group, key string) (loadedBy string, unlock func(loaded bool), err error) {
+	name := group + "/" + key
+	if err := l.lockLocal(ctx, name); err != nil {
+		return "", nil, err
+	}
+	session, err := l.currentSession()
+	if err != nil {
+		l.unlockLocal(name)
+		return "", nil, err
+	}
+	m := concurrency.NewMutex(session, loadLockPrefix+"lock/"+name)
+	if err = m.Lock(ctx); err != nil {
+		l.unlockLocal(name)
+		return "", nil, err
+	}
+	resp, err := l.cli.Get(ctx, loadLockPrefix+"loaded/"+name)
+	if err != nil {
+		m.Unlock(context.Background())
+		l.unlockLocal(name)
+		return "", nil, err
+	}
+	if len(resp.Kvs) > 0 {
+		loadedBy = string(resp.Kvs[0].Value)
+	}
+	return loadedBy, func(loaded bool) {
+		if loaded {
+			l.loaded.put(l.cli.Ctx(), loadLockPrefix+"loaded/"+name, l.self)
+		}
+		m.Unlock(context.Background())
+		l.unlockLocal(name)
+	}, nil
+}
+
+// Close 关闭共用的会话并撤销其租约，之后的 LockLoad 会创建新的会话
+func (l *LoadLock) Close() error {
+	l.sessionMu.Lock()
+	defer l.sessionMu.Unlock()
+	if l.session == nil {
+		return nil
+	}
+	err := l.session.Close()
+	l.session = nil
+	return err
+}
+
+// currentSession 返回共用的会话，会话的租约已过期时创建新的会话
+func (l *LoadLock) currentSession() (*concurrency.Session, error) {
+	l.sessionMu.Lock()
+	defer l.sessionMu.Unlock()
+	if l.session != nil {
+		select {
+		case <-l.session.Done():
+		default:
+			return l.session, nil
+		}
+	}
+	session, err := concurrency.NewSession(l.cli, concurrency.WithTTL(l.ttl))
+	if err != nil {
+		return nil, err
+	}
+	l.session = session
+	return session, nil
+}
+
+// lockLocal 等待本节点上 name 的其他持有者释放锁
+func (l *LoadLock) lockLocal(ctx context.Context, name string) error {
+	for {
+		l.mu.Lock()
+		released, busy := l.held[name]
+		if !busy {
+			l.held[name] = make(chan struct{})
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// unlockLocal 释放本节点上 name 的锁，唤醒等待者
+func (l *LoadLock) unlockLocal(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.held[name])
+	delete(l.held, name)
+}
@@ -2,8 +2,10 @@ package registry // Package registry模块提供服务Service注册至etcd的能
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -15,52 +17,231 @@ var (
 		Endpoints:   []string{"localhost:2379"}, // etcd服务器的地址，这里使用本地地址和默认端口
 		DialTimeout: 5 * time.Second,            // 建立连接的超时时间为5秒
 	}
+	etcdConfig = defaultEtcdConfig // SetEtcdConfig 设置的etcd客户端配置
+
+	retryMu        sync.Mutex
+	keepAliveRetry = retryPolicy{retries: 3, backoff: 500 * time.Millisecond} // 由 retryMu 保护
+
+	// leaseTimeout 是申请租约、注册服务和查询租约等单次etcd操作的超时时间，etcd不可达时注册和心跳重试不会一直阻塞
+	leaseTimeout = 5 * time.Second
 )
 
+// retryPolicy 是心跳通道关闭后重新建立心跳的重试策略
+type retryPolicy struct {
+	retries int           // 最大尝试次数
+	backoff time.Duration // 首次重试前的等待时间，之后每次翻倍
+}
+
+// errKeepAliveLost 表示重试耗尽后仍无法恢复心跳，节点应视为下线
+var errKeepAliveLost = errors.New("keepalive lost after retries")
+
+// SetKeepAliveRetry 设置心跳通道关闭后的重试次数和初始退避时间，需在 Register 之前调用
+// retries 为 0 表示不重试，保持原先立即撤销租约的行为。已经开始的注册继续使用注册时的设置
+func SetKeepAliveRetry(retries int, backoff time.Duration) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	keepAliveRetry = retryPolicy{retries: retries, backoff: backoff}
+}
+
+// SetEtcdConfig 设置注册和发现服务时连接etcd使用的客户端配置，需在 Register 之前调用。
+// cfg.Endpoints 为空时恢复默认的 localhost:2379
+func SetEtcdConfig(cfg clientv3.Config) {
+	if len(cfg.Endpoints) == 0 {
+		cfg = defaultEtcdConfig
+	}
+	etcdConfig = cfg
+}
+
+// EtcdConfig 返回当前的etcd客户端配置
+func EtcdConfig() clientv3.Config {
+	return etcdConfig
+}
+
+// Metadata 是随服务地址一起注册至etcd的节点元数据，发现服务时可以读取
+type Metadata struct {
+	Zone    string `json:"zone,omitempty"`    // 节点所在的可用区
+	Weight  int    `json:"weight,omitempty"`  // 节点权重
+	Version string `json:"version,omitempty"` // 节点运行的版本
+}
+
 // etcdAdd 在租赁模式添加一对kv至etcd
-// 四个参数分别是etcd客户端，etcd租约ID，服务名称，服务地址
-func etcdAdd(c *clientv3.Client, lid *clientv3.LeaseID, service string, addr string) error {
+// 参数分别是请求的上下文，etcd客户端，etcd租约ID，服务名称，服务地址，节点元数据
+func etcdAdd(ctx context.Context, c *clientv3.Client, lid *clientv3.LeaseID, service string, addr string, md Metadata) error {
 	em, err := endpoints.NewManager(c, service) //创建一个用于管理 etcd 中的服务端点（endpoints）
 	if err != nil {
 		return err
 	}
 	//该方法用于将指定的服务地址（addr）添加到 etcd 中的服务端点列表中。
 	//clientv3.WithLease(lid) 选项表示使用指定的租约 ID（lid）来设置键值的生命周期。
-	return em.AddEndpoint(c.Ctx(), service+"/"+addr, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(*lid))
+	return em.AddEndpoint(ctx, service+"/"+addr, endpoints.Endpoint{Addr: addr, Metadata: md}, clientv3.WithLease(*lid))
 }
 
 // Register 注册一个服务至etcd,并且在服务的生命周期内保持心跳检测，确保服务的持续在线。
 // 注意 Register将不会return 如果没有error的话
 func Register(service, addr string, stop chan error) error {
+	return RegisterWithMetadata(service, addr, Metadata{}, stop)
+}
+
+// RegisterWithMetadata 与 Register 相同，同时将节点元数据 md 写入注册的 endpoint，
+// 其他节点可以通过 ListEndpoints 读取
+func RegisterWithMetadata(service, addr string, md Metadata, stop chan error) error {
+	return RegisterAll([]Endpoint{{Service: service, Addr: addr, Metadata: md}}, stop)
+}
+
+// Endpoint 是一个待注册的服务地址
+type Endpoint struct {
+	Service  string
+	Addr     string
+	Metadata Metadata
+}
+
+// RegisterAll 使用同一个etcd客户端和同一个租约注册多个服务，所有服务共享一条心跳，
+// 适用于一个进程承载多个服务的场景。stop 收到信号后撤销租约，所有服务一起下线。
+// 与 Register 一样，没有错误时不会返回
+func RegisterAll(eps []Endpoint, stop chan error) error {
+	return RegisterAllWithConfig(etcdConfig, eps, stop)
+}
+
+// RegisterAllWithConfig 与 RegisterAll 相同，但使用 cfg 连接etcd而不是 SetEtcdConfig 设置的配置
+func RegisterAllWithConfig(cfg clientv3.Config, eps []Endpoint, stop chan error) error {
 	// 创建一个etcd客户端
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(cfg)
 	if err != nil {
 		return fmt.Errorf("create etcd client failed: %v", err)
 	}
 	defer cli.Close()
-	// 创建一个租约，设置租约的过期时间为5秒
+	return register(etcdLease{cli}, eps, stop)
+}
+
+// lease 是注册服务用到的etcd租约操作
+type lease interface {
+	grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error)
+	add(ctx context.Context, lid clientv3.LeaseID, ep Endpoint) error
+	keepAlive(lid clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error)
+	alive(ctx context.Context, lid clientv3.LeaseID) (bool, error) // 租约是否仍然有效，过期或被撤销时为 false
+	revoke(lid clientv3.LeaseID) error
+	done() <-chan struct{} // 客户端关闭时关闭
+}
+
+// etcdLease 基于etcd客户端实现 lease
+type etcdLease struct {
+	cli *clientv3.Client
+}
+
+func (l etcdLease) grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	resp, err := l.cli.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+func (l etcdLease) add(ctx context.Context, lid clientv3.LeaseID, ep Endpoint) error {
+	return etcdAdd(ctx, l.cli, &lid, ep.Service, ep.Addr, ep.Metadata)
+}
+
+func (l etcdLease) keepAlive(lid clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	return l.cli.KeepAlive(context.Background(), lid)
+}
+
+func (l etcdLease) alive(ctx context.Context, lid clientv3.LeaseID) (bool, error) {
+	resp, err := l.cli.TimeToLive(ctx, lid)
+	if err != nil {
+		return false, err
+	}
+	// 租约不存在时 TTL 为 -1
+	return resp.TTL > 0, nil
+}
+
+func (l etcdLease) revoke(lid clientv3.LeaseID) error {
+	_, err := l.cli.Revoke(context.Background(), lid)
+	return err
+}
+
+func (l etcdLease) done() <-chan struct{} {
+	return l.cli.Ctx().Done()
+}
+
+// register 在一个租约下注册所有服务并保持心跳，直到收到停止信号或心跳无法恢复。
+// 重试期间租约已经过期时，重新申请租约并注册所有服务。每次etcd操作最多等待 leaseTimeout
+func register(l lease, eps []Endpoint, stop chan error) error {
+	retryMu.Lock()
+	retry := keepAliveRetry
+	retryMu.Unlock()
+	// 租约的过期时间为5秒
 	var ttl int64 = 5
-	resp, err := cli.Grant(cli.Ctx(), ttl)
+	ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+	leaseID, err := grantAndAdd(ctx, l, ttl, eps)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("create lease failed: %v", err)
+		return err
 	}
-	leaseID := resp.ID // 获取租约ID
-	// 注册服务
-	if err := etcdAdd(cli, &leaseID, service, addr); err != nil {
-		return fmt.Errorf("add service to etcd failed: %v", err)
+	addrs := make([]string, 0, len(eps))
+	for _, ep := range eps {
+		addrs = append(addrs, ep.Service+"/"+ep.Addr)
 	}
 	// 设置服务心跳检测，创建了一个保持租约活动的心跳通道 ch，确保租约在生命周期内保持有效。
-	ch, err := cli.KeepAlive(context.Background(), leaseID)
+	ch, err := l.keepAlive(leaseID)
 	if err != nil {
+		l.revoke(leaseID)
 		return fmt.Errorf("set keepalive failed: %v", err)
 	}
+	ka := func() (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+		defer cancel()
+		// 对已过期的租约续约没有意义，其上的服务也已被etcd删除
+		ok, err := l.alive(ctx, leaseID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			lid, err := grantAndAdd(ctx, l, ttl, eps)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("%v lease expired, registered again with a new lease", addrs)
+			leaseID = lid
+		}
+		return l.keepAlive(leaseID)
+	}
+
+	log.Printf("%v register service success\n", addrs)
+	err = keepAlive(ch, ka, retry, stop, l.done())
+	if err == errKeepAliveLost {
+		// 心跳无法恢复，撤销租约
+		return l.revoke(leaseID)
+	}
+	// 收到停止信号后立即撤销租约，其他节点无需等待租约过期就能发现本节点已下线
+	if rerr := l.revoke(leaseID); rerr != nil {
+		log.Printf("%v revoke lease failed: %v", addrs, rerr)
+	}
+	return err
+}
 
-	log.Printf("[%s] register service success\n", addr)
+// grantAndAdd 申请一个 ttl 秒的租约并在其下注册所有服务，注册失败时撤销租约
+func grantAndAdd(ctx context.Context, l lease, ttl int64, eps []Endpoint) (clientv3.LeaseID, error) {
+	leaseID, err := l.grant(ctx, ttl)
+	if err != nil {
+		return 0, fmt.Errorf("create lease failed: %v", err)
+	}
+	for _, ep := range eps {
+		if err := l.add(ctx, leaseID, ep); err != nil {
+			// 已注册的服务随租约一起撤销
+			l.revoke(leaseID)
+			return 0, fmt.Errorf("add service %s to etcd failed: %v", ep.Service, err)
+		}
+	}
+	return leaseID, nil
+}
+
+// keepAlive 持续消费心跳通道 ch，ka 用于在通道关闭后按 retry 重新建立心跳
+func keepAlive(ch <-chan *clientv3.LeaseKeepAliveResponse, ka func() (<-chan *clientv3.LeaseKeepAliveResponse, error),
+	retry retryPolicy, stop chan error, done <-chan struct{}) error {
 	/*
-		函数同时监听来自 stop 通道的停止信号、cli.Ctx().Done() 的服务关闭信号以及心跳通道 ch 的消息。
+		函数同时监听来自 stop 通道的停止信号、done 的服务关闭信号以及心跳通道 ch 的消息。
 		如果接收到停止信号，函数会返回；
 		如果服务被关闭，函数会打印日志并返回；
-		如果心跳通道被关闭，函数会撤销租约，并返回相应的错误。
+		如果心跳通道被关闭，函数会按指数退避重新建立心跳，重试耗尽后返回 errKeepAliveLost。
 	*/
 	for {
 		select {
@@ -69,16 +250,37 @@ func Register(service, addr string, stop chan error) error {
 				log.Println(err)
 			}
 			return err
-		case <-cli.Ctx().Done():
+		case <-done:
 			log.Println("context done")
 			return nil
 		case _, ok := <-ch:
 			// 监听租约
-			if !ok {
-				log.Println("keepalive channel closed")
-				_, err := cli.Revoke(context.Background(), leaseID)
-				return err
+			if ok {
+				continue
+			}
+			log.Println("keepalive channel closed")
+			backoff := retry.backoff
+			ch = nil
+			for i := 0; i < retry.retries && ch == nil; i++ {
+				select {
+				case err := <-stop:
+					return err
+				case <-done:
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				newCh, err := ka()
+				if err != nil {
+					log.Printf("keepalive retry %d failed: %v", i+1, err)
+					continue
+				}
+				ch = newCh
+				log.Printf("keepalive re-established after %d retries", i+1)
+			}
+			if ch == nil {
+				return errKeepAliveLost
 			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,285 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestKeepAliveRetry(t *testing.T) {
+	closed := make(chan *clientv3.LeaseKeepAliveResponse)
+	close(closed)
+	healthy := make(chan *clientv3.LeaseKeepAliveResponse)
+
+	calls := 0
+	ka := func() (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("etcd unavailable")
+		}
+		return healthy, nil
+	}
+
+	stop := make(chan error)
+	errc := make(chan error)
+	go func() { errc <- keepAlive(closed, ka, retryPolicy{retries: 3, backoff: time.Millisecond}, stop, nil) }()
+
+	// 心跳恢复后节点保持注册，直到收到停止信号
+	select {
+	case err := <-errc:
+		t.Fatalf("keepAlive returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	stop <- nil
+	if err := <-errc; err != nil || calls != 2 {
+		t.Fatalf("keepAlive err = %v, calls = %d", err, calls)
+	}
+}
+
+func TestKeepAliveRetryExhausted(t *testing.T) {
+	closed := make(chan *clientv3.LeaseKeepAliveResponse)
+	close(closed)
+	calls := 0
+	ka := func() (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+		calls++
+		return nil, fmt.Errorf("etcd unavailable")
+	}
+
+	if err := keepAlive(closed, ka, retryPolicy{retries: 2, backoff: time.Millisecond}, make(chan error), nil); err != errKeepAliveLost || calls != 2 {
+		t.Fatalf("keepAlive err = %v, calls = %d", err, calls)
+	}
+}
+
+// fakeLease 在内存中模拟etcd的租约和 endpoint
+type fakeLease struct {
+	mu      sync.Mutex
+	grants  int
+	keys    map[string]clientv3.LeaseID // service/addr -> 租约
+	expired map[clientv3.LeaseID]bool
+	chans   map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse
+	hang    bool // 为 true 时 alive 阻塞到请求超时
+}
+
+func (l *fakeLease) grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.grants++
+	return clientv3.LeaseID(l.grants), nil
+}
+
+func (l *fakeLease) add(ctx context.Context, lid clientv3.LeaseID, ep Endpoint) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys[ep.Service+"/"+ep.Addr] = lid
+	return nil
+}
+
+func (l *fakeLease) keepAlive(lid clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	if l.expired[lid] {
+		// 与etcd一样，对已过期的租约续约时心跳通道立即关闭
+		close(ch)
+		return ch, nil
+	}
+	if l.chans == nil {
+		l.chans = make(map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse)
+	}
+	l.chans[lid] = ch
+	return ch, nil
+}
+
+func (l *fakeLease) alive(ctx context.Context, lid clientv3.LeaseID) (bool, error) {
+	if l.hang {
+		// 模拟etcd不可达，请求一直等到超时
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.expired[lid], nil
+}
+
+// expire 模拟租约过期：删除其上的 endpoint 并关闭心跳通道
+func (l *fakeLease) expire(lid clientv3.LeaseID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.expired == nil {
+		l.expired = make(map[clientv3.LeaseID]bool)
+	}
+	l.expired[lid] = true
+	for k, id := range l.keys {
+		if id == lid {
+			delete(l.keys, k)
+		}
+	}
+	if ch, ok := l.chans[lid]; ok {
+		close(ch)
+		delete(l.chans, lid)
+	}
+}
+
+func (l *fakeLease) revoke(lid clientv3.LeaseID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, id := range l.keys {
+		if id == lid {
+			delete(l.keys, k)
+		}
+	}
+	return nil
+}
+
+func (l *fakeLease) done() <-chan struct{} {
+	return nil
+}
+
+// registered 返回当前已注册的 endpoint 及其租约
+func (l *fakeLease) registered() map[string]clientv3.LeaseID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make(map[string]clientv3.LeaseID, len(l.keys))
+	for k, id := range l.keys {
+		keys[k] = id
+	}
+	return keys
+}
+
+func TestRegisterAllSharesLease(t *testing.T) {
+	l := &fakeLease{keys: make(map[string]clientv3.LeaseID)}
+	eps := []Endpoint{
+		{Service: "geecache", Addr: "10.0.0.1:8001"},
+		{Service: "geecache-admin", Addr: "10.0.0.1:8002"},
+		{Service: "geecache-http", Addr: "10.0.0.1:9999"},
+	}
+	stop := make(chan error)
+	errc := make(chan error)
+	go func() { errc <- register(l, eps, stop) }()
+
+	var keys map[string]clientv3.LeaseID
+	for i := 0; i < 100 && len(keys) < len(eps); i++ {
+		time.Sleep(time.Millisecond)
+		keys = l.registered()
+	}
+	for _, ep := range eps {
+		if id, ok := keys[ep.Service+"/"+ep.Addr]; !ok || id != 1 {
+			t.Fatalf("%s/%s should be registered on the shared lease, got %v", ep.Service, ep.Addr, keys)
+		}
+	}
+	if l.grants != 1 {
+		t.Fatalf("all services should share one lease, granted %d", l.grants)
+	}
+
+	// 停止后所有服务一起下线
+	stop <- nil
+	if err := <-errc; err != nil {
+		t.Fatalf("register returned %v", err)
+	}
+	if keys := l.registered(); len(keys) != 0 {
+		t.Fatalf("all services should deregister together, left %v", keys)
+	}
+}
+
+func TestRegisterAfterLeaseExpired(t *testing.T) {
+	SetKeepAliveRetry(3, time.Millisecond)
+	defer SetKeepAliveRetry(3, 500*time.Millisecond)
+
+	l := &fakeLease{keys: make(map[string]clientv3.LeaseID)}
+	eps := []Endpoint{
+		{Service: "geecache", Addr: "10.0.0.1:8001"},
+		{Service: "geecache-http", Addr: "10.0.0.1:9999"},
+	}
+	stop := make(chan error)
+	errc := make(chan error)
+	go func() { errc <- register(l, eps, stop) }()
+
+	// waitLease 等待所有服务注册在租约 lid 下
+	waitLease := func(lid clientv3.LeaseID) {
+		t.Helper()
+		var keys map[string]clientv3.LeaseID
+		for i := 0; i < 100; i++ {
+			keys = l.registered()
+			n := 0
+			for _, id := range keys {
+				if id == lid {
+					n++
+				}
+			}
+			if n == len(eps) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("services should be registered on lease %d, got %v", lid, keys)
+	}
+	waitLease(1)
+
+	// 租约过期后申请新租约重新注册，而不是继续对旧租约续约
+	l.expire(1)
+	waitLease(2)
+	select {
+	case err := <-errc:
+		t.Fatalf("register returned after re-registering: %v", err)
+	default:
+	}
+
+	stop <- nil
+	if err := <-errc; err != nil {
+		t.Fatalf("register returned %v", err)
+	}
+	if keys := l.registered(); len(keys) != 0 {
+		t.Fatalf("the new lease should be revoked on stop, left %v", keys)
+	}
+}
+
+func TestSetEtcdConfig(t *testing.T) {
+	defer SetEtcdConfig(clientv3.Config{})
+	if got := EtcdConfig(); got.Endpoints[0] != "localhost:2379" {
+		t.Fatalf("default endpoints = %v", got.Endpoints)
+	}
+
+	SetEtcdConfig(clientv3.Config{Endpoints: []string{"etcd-1:2379", "etcd-2:2379"}, DialTimeout: time.Second})
+	if got := EtcdConfig(); len(got.Endpoints) != 2 || got.Endpoints[1] != "etcd-2:2379" || got.DialTimeout != time.Second {
+		t.Fatalf("configured = %+v", got)
+	}
+
+	// 未设置 Endpoints 时退回默认配置
+	SetEtcdConfig(clientv3.Config{})
+	if got := EtcdConfig(); got.Endpoints[0] != "localhost:2379" || got.DialTimeout != 5*time.Second {
+		t.Fatalf("fallback = %+v", got)
+	}
+}
+
+func TestRegisterRetryTimeout(t *testing.T) {
+	SetKeepAliveRetry(2, time.Millisecond)
+	defer SetKeepAliveRetry(3, 500*time.Millisecond)
+	old := leaseTimeout
+	leaseTimeout = 10 * time.Millisecond
+	defer func() { leaseTimeout = old }()
+
+	l := &fakeLease{keys: make(map[string]clientv3.LeaseID), hang: true}
+	stop := make(chan error)
+	errc := make(chan error)
+	go func() { errc <- register(l, []Endpoint{{Service: "geecache", Addr: "10.0.0.1:8001"}}, stop) }()
+	for i := 0; i < 100 && len(l.registered()) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	// 注册之后修改重试设置不影响已经开始的注册
+	SetKeepAliveRetry(1000, time.Millisecond)
+
+	// etcd不可达时每次重试在超时后失败，重试耗尽后注册返回而不是一直阻塞
+	l.expire(1)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("register returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("register should give up after the retries time out")
+	}
+}
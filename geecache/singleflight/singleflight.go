@@ -1,16 +1,49 @@
 package singleflight
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
 
 type call struct { // call 代表正在进行中或者已经结束的请求
 	wg  sync.WaitGroup // 避免重入
 	val interface{}
 	err error
+
+	dups  int             // 等待该请求结果的其他调用数量
+	chans []chan<- Result // DoChan 调用方等待结果的 channel
 }
 
 type Group struct { // 管理不同key的请求
 	mu sync.Mutex
 	m  map[string]*call // 正在进行中，或已经结束的请求
+
+	// MaxWaiters 限制每个进行中的请求上同时等待的调用方数量(不含发起请求的调用方)，为0表示不限制。
+	// 超过上限的调用方立即得到 ErrTooManyWaiters，可以稍后重试或走降级逻辑，
+	// 避免一个很慢的 fn 堆积大量阻塞的 goroutine
+	MaxWaiters int
+}
+
+// ErrTooManyWaiters 表示 key 上进行中的请求的等待者已达到 MaxWaiters
+var ErrTooManyWaiters = errors.New("singleflight: too many waiters")
+
+// Result 是 DoChan 通过 channel 返回的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool // 结果是否被多个调用共享
+}
+
+// PanicError 表示 fn 发生了 panic，panic 被恢复后所有等待该请求的调用方都会收到这个错误
+type PanicError struct {
+	Value interface{} // recover 得到的值
+	Stack []byte      // 发生 panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v\n\n%s", e.Value, e.Stack)
 }
 
 // 实现了singleFlight原理：在多个并发请求触发的回调操作里，只有第⼀个回调方法被执行
@@ -22,6 +55,11 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok { // 如果请求正在进行中，则等待
+		if g.full(c) {
+			g.mu.Unlock()
+			return nil, ErrTooManyWaiters
+		}
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait() // 等待协程结束
 		return c.val, c.err
@@ -31,12 +69,77 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	g.m[key] = c // 表明该key已经有请求在进⾏
 	g.mu.Unlock()
 
-	c.val, c.err = fn() // 执⾏请求
-	c.wg.Done()
+	g.doCall(c, key, fn)
+	return c.val, c.err
+}
 
+// DoChan 与 Do 相同，但不阻塞调用方，而是返回一个在请求完成时收到结果的 channel，
+// 调用方可以同时等待自己的 ctx.Done() 并在取消时放弃等待，放弃等待不会取消正在进行的请求
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.m, key) // 完成请求 更新Fligh
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		if g.full(c) {
+			g.mu.Unlock()
+			ch <- Result{Err: ErrTooManyWaiters}
+			return ch
+		}
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
 	g.mu.Unlock()
 
-	return c.val, c.err
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// full 判断 c 的等待者是否已达到上限，调用时需持有 g.mu
+func (g *Group) full(c *call) bool {
+	return g.MaxWaiters > 0 && c.dups >= g.MaxWaiters
+}
+
+// Waiters 返回 key 上进行中的请求当前的等待者数量(不含发起请求的调用方)，没有进行中的请求时返回0
+func (g *Group) Waiters(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.m[key]; ok {
+		return c.dups
+	}
+	return 0
+}
+
+// doCall 执行请求并把结果交给所有等待的调用方，fn 发生 panic 时以 PanicError 作为结果
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		c.wg.Done()
+
+		g.mu.Lock()
+		if g.m[key] == c { // 完成请求 更新Fligh，Forget 之后 key 可能已经对应新的请求
+			delete(g.m, key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+		}
+		g.mu.Unlock()
+	}()
+	c.val, c.err = fn() // 执⾏请求
+}
+
+// Forget 让 key 之后的调用发起新的请求，而不是等待正在进行的请求，用于放弃卡住的请求。
+// 已经在等待旧请求的调用方仍然会得到它最终的结果
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
 }
@@ -1,6 +1,12 @@
 package singleflight
 
-import "testing"
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestDo(t *testing.T) {
 	var g Group
@@ -10,4 +16,211 @@ func TestDo(t *testing.T) {
 	if v != "bar" || err != nil {
 		t.Errorf("Do v = %v,error = %v", v, err)
 	}
-}
\ No newline at end of file
+}
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	ch := g.DoChan("key", fn)
+
+	// 等待中的 Do 与 DoChan 共享同一次调用
+	done := make(chan interface{})
+	go func() {
+		v, _ := g.Do("key", fn)
+		done <- v
+	}()
+	for {
+		g.mu.Lock()
+		dups := g.m["key"].dups
+		g.mu.Unlock()
+		if dups == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	dup := g.DoChan("key", fn)
+
+	// 调用方可以放弃等待
+	select {
+	case r := <-ch:
+		t.Fatalf("DoChan returned %+v before fn finished", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	for _, c := range []<-chan Result{ch, dup} {
+		if r := <-c; r.Val != "bar" || r.Err != nil || !r.Shared {
+			t.Fatalf("DoChan result = %+v", r)
+		}
+	}
+	if v := <-done; v != "bar" {
+		t.Fatalf("Do v = %v", v)
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+
+	r := <-g.DoChan("key", func() (interface{}, error) { return nil, errors.New("boom") })
+	if r.Err == nil || r.Shared {
+		t.Fatalf("unshared DoChan result = %+v", r)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	stuck, release := make(chan struct{}), make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		close(stuck)
+		<-release
+		return "old", nil
+	})
+	<-stuck
+	waiter := g.DoChan("key", func() (interface{}, error) { return "unused", nil })
+
+	// Forget 之后的调用发起新的请求
+	g.Forget("key")
+	second := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "new", nil
+	})
+	third := g.DoChan("key", func() (interface{}, error) { return "unused", nil })
+	v, err := g.Do("other", func() (interface{}, error) { return "other", nil })
+	if v != "other" || err != nil {
+		t.Fatalf("Do other = %v, %v", v, err)
+	}
+
+	close(release)
+	for _, c := range []<-chan Result{first, waiter} {
+		if r := <-c; r.Val != "old" {
+			t.Fatalf("waiter of the forgotten call got %+v", r)
+		}
+	}
+	for _, c := range []<-chan Result{second, third} {
+		if r := <-c; r.Val != "new" {
+			t.Fatalf("caller after Forget got %+v", r)
+		}
+	}
+	// 旧请求完成时不会删除新请求
+	g.mu.Lock()
+	n := len(g.m)
+	g.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d calls left in flight", n)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started, release := make(chan struct{}), make(chan struct{})
+	errs := make(chan error, 5)
+	go func() {
+		_, err := g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+		errs <- err
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := g.Do("key", func() (interface{}, error) { return "unused", nil })
+			errs <- err
+		}()
+	}
+	ch := g.DoChan("key", func() (interface{}, error) { return "unused", nil })
+	for {
+		g.mu.Lock()
+		dups := g.m["key"].dups
+		g.mu.Unlock()
+		if dups == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			var pe *PanicError
+			if !errors.As(err, &pe) || pe.Value != "boom" {
+				t.Fatalf("Do error = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("callers should not hang after fn panics")
+		}
+	}
+	if r := <-ch; r.Err == nil || !r.Shared {
+		t.Fatalf("DoChan result = %+v", r)
+	}
+
+	// panic 之后 key 可以重新加载
+	if v, err := g.Do("key", func() (interface{}, error) { return "ok", nil }); v != "ok" || err != nil {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
+
+func TestMaxWaiters(t *testing.T) {
+	g := Group{MaxWaiters: 3}
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		return "bar", nil
+	}
+	results := make(chan error, 20)
+	go func() {
+		_, err := g.Do("key", fn)
+		results <- err
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := g.Do("key", fn)
+			results <- err
+		}()
+	}
+	// 3 个等待者留下，其余 17 个应立即被拒绝
+	for i := 0; i < 17; i++ {
+		select {
+		case err := <-results:
+			if !errors.Is(err, ErrTooManyWaiters) {
+				t.Fatalf("rejected joiner got %v, want ErrTooManyWaiters", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("joiners beyond the cap should be rejected without waiting")
+		}
+	}
+	if n := g.Waiters("key"); n != 3 {
+		t.Fatalf("Waiters = %d, want 3", n)
+	}
+	if r := <-g.DoChan("key", fn); !errors.Is(r.Err, ErrTooManyWaiters) {
+		t.Fatalf("DoChan beyond the cap = %+v", r)
+	}
+
+	close(release)
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("waiter got %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("fn called %d times, want 1", got)
+	}
+	if n := g.Waiters("key"); n != 0 {
+		t.Fatalf("Waiters after completion = %d", n)
+	}
+}
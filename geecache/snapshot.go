@@ -0,0 +1,78 @@
+package geecache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// groupSnapshot 是快照中一个缓存组的数据，SnapshotAll 依次写出每个缓存组的 JSON
+type groupSnapshot struct {
+	Name    string          `json:"name"`
+	Entries []entrySnapshot `json:"entries"`
+}
+
+// entrySnapshot 是快照中的一条数据
+type entrySnapshot struct {
+	Key      string    `json:"key"`
+	Value    []byte    `json:"value"`              // 保存在缓存中的形式，开启 WithValueCodec 时为编码后的字节
+	Encoding string    `json:"encoding,omitempty"` // Value 的内容编码，见 ContentEncoder
+	Expire   time.Time `json:"expire"`
+}
+
+// SnapshotAll 将所有已注册缓存组的 mainCache 写入 w，用于整个节点的备份，按缓存组名称排序。
+// hotCache 中的数据由其他节点负责，不包含在快照中。快照保存的是缓存中的保存形式，
+// 开启 WithValueCodec 加密的缓存组在快照中同样是密文，需要恢复到使用相同 codec(和密钥)的缓存组
+func SnapshotAll(w io.Writer) error {
+	mu.RLock()
+	all := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		all = append(all, g)
+	}
+	mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	enc := json.NewEncoder(w)
+	for _, g := range all {
+		snap := groupSnapshot{Name: g.name}
+		for _, e := range g.mainCache.snapshot() {
+			snap.Entries = append(snap.Entries, entrySnapshot{Key: e.key, Value: e.value.b, Encoding: e.value.encoding, Expire: e.expire})
+		}
+		if err := enc.Encode(&snap); err != nil {
+			return fmt.Errorf("snapshot group %s: %w", g.name, err)
+		}
+	}
+	return nil
+}
+
+// RestoreAll 读取 SnapshotAll 写出的快照，将数据恢复到同名缓存组的 mainCache 中，恢复后的数据按快照中的剩余有效期重新计时，
+// 恢复时已过期的数据被跳过。数据按快照中的保存形式原样写回，不会再次编码。缓存组需要事先通过 NewGroup 创建，不存在的缓存组会被跳过，
+// 并在返回的错误中以 ErrGroupNotFound 报告，其余缓存组照常恢复
+func RestoreAll(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var errs []error
+	for {
+		var snap groupSnapshot
+		if err := dec.Decode(&snap); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("restore snapshot: %w", err))...)
+		}
+		g := GetGroup(snap.Name)
+		if g == nil {
+			errs = append(errs, fmt.Errorf("restore group %s: %w", snap.Name, ErrGroupNotFound))
+			continue
+		}
+		for _, e := range snap.Entries {
+			ttl := time.Until(e.Expire)
+			if ttl <= 0 {
+				continue
+			}
+			g.mainCache.restore(e.Key, ByteView{b: e.Value, encoding: e.Encoding}, ttl)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestoreAll(t *testing.T) {
+	var loads atomic.Int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key + "-value"), nil
+	})
+	scores := NewGroup("snapshot-scores", 2<<10, getter, WithTTL(time.Hour))
+	names := NewGroup("snapshot-names", 2<<10, getter, WithTTL(time.Hour))
+	scores.Get("Tom")
+	scores.Get("Jack")
+	names.Get("Sam")
+
+	var buf bytes.Buffer
+	if err := SnapshotAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新创建同名的空缓存组，模拟节点重启
+	loads.Store(0)
+	scores = NewGroup("snapshot-scores", 2<<10, getter, WithTTL(time.Hour))
+	names = NewGroup("snapshot-names", 2<<10, getter, WithTTL(time.Hour))
+	if err := RestoreAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for g, keys := range map[*Group][]string{scores: {"Tom", "Jack"}, names: {"Sam"}} {
+		for _, key := range keys {
+			if v, err := g.Get(key); err != nil || v.String() != key+"-value" {
+				t.Fatalf("%s/%s = %q, %v after restore", g.name, key, v.String(), err)
+			}
+			if _, ttl, err := g.GetWithTTL(key); err != nil || ttl < 50*time.Minute {
+				t.Fatalf("%s/%s restored with ttl %v, %v", g.name, key, ttl, err)
+			}
+		}
+	}
+	if n := loads.Load(); n != 0 {
+		t.Fatalf("restored keys should not hit the source, got %d loads", n)
+	}
+}
+
+func TestSnapshotEncryptedGroup(t *testing.T) {
+	codec, err := NewAESGCMCodec(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loads atomic.Int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("plaintext-secret-" + key), nil
+	})
+	g := NewGroup("snapshot-encrypted", 2<<10, getter, WithTTL(time.Hour), WithValueCodec(codec))
+	g.Get("card")
+
+	var buf bytes.Buffer
+	if err := SnapshotAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("plaintext-secret")) {
+		t.Fatal("snapshot of an encrypted group contains plaintext")
+	}
+
+	// 恢复时原样写回密文，不会被再次加密
+	loads.Store(0)
+	g = NewGroup("snapshot-encrypted", 2<<10, getter, WithTTL(time.Hour), WithValueCodec(codec))
+	if err := RestoreAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get("card"); err != nil || v.String() != "plaintext-secret-card" || loads.Load() != 0 {
+		t.Fatalf("Get after restore = %q, %v with %d loads", v.String(), err, loads.Load())
+	}
+}
+
+func TestSnapshotRoundTripsStoredForm(t *testing.T) {
+	codec, err := NewGzipCodec(gzip.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat(key, 100)), nil
+	})
+	g := NewGroup("snapshot-gzip", 2<<10, getter, WithTTL(time.Hour), WithValueCodec(codec))
+	g.Get("k")
+	stored, _, _ := g.GetEncoded("k", "gzip")
+
+	var buf bytes.Buffer
+	if err := SnapshotAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	g = NewGroup("snapshot-gzip", 2<<10, getter, WithTTL(time.Hour), WithValueCodec(codec))
+	if err := RestoreAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// 恢复后的保存形式与快照前完全相同，包括内容编码
+	v, enc, err := g.GetEncoded("k", "gzip")
+	if err != nil || enc != "gzip" || !bytes.Equal(v.ByteSlice(), stored.ByteSlice()) {
+		t.Fatalf("GetEncoded after restore = %d bytes, %q, %v", v.Len(), enc, err)
+	}
+	if v, err := g.Get("k"); err != nil || v.String() != strings.Repeat("k", 100) {
+		t.Fatalf("Get after restore = %q, %v", v.String(), err)
+	}
+}
+
+func TestRestoreAllMismatch(t *testing.T) {
+	g := NewGroup("restore-known", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("fresh"), nil
+	}))
+	expired := time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	valid := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	snapshot := `{"name":"restore-unknown","entries":[{"key":"a","value":"YQ==","expire":"` + valid + `"}]}
+{"name":"restore-known","entries":[{"key":"old","value":"YQ==","expire":"` + expired + `"},{"key":"new","value":"Yg==","expire":"` + valid + `"}]}
+`
+	err := RestoreAll(strings.NewReader(snapshot))
+	if !errors.Is(err, ErrGroupNotFound) || !strings.Contains(err.Error(), "restore-unknown") {
+		t.Fatalf("RestoreAll error = %v, want ErrGroupNotFound for restore-unknown", err)
+	}
+	// 其余缓存组照常恢复，已过期的数据被跳过
+	if _, _, ok := g.mainCache.lookup("old"); ok {
+		t.Fatal("expired entry should not be restored")
+	}
+	if v, _, ok := g.mainCache.lookup("new"); !ok || v.String() != "b" {
+		t.Fatalf("restored value = %q, %v", v.String(), ok)
+	}
+}
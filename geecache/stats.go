@@ -0,0 +1,199 @@
+package geecache
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats 是 Group 运行状况的快照
+type Stats struct {
+	Hits         int64 // Get 命中本地缓存(hotCache 或 mainCache)的次数
+	HotCacheHits int64 // 其中命中 hotCache 的次数，可用于判断热点提升是否有效
+	Misses       int64 // Get 未命中缓存、需要加载的次数
+	PeerLoads    int64 // 从远程节点(包括只读副本)成功加载的次数
+	LocalLoads   int64 // 从数据源成功加载的次数
+	Evictions    int64 // 因容量不足或过期被淘汰的次数，不包括 Remove
+	Items        int64 // mainCache 和 hotCache 中当前的数据条数，包括尚未被清理的过期数据
+	// DroppedBackgroundTasks 是因后台协程池已满而被丢弃的后台任务(陈旧数据的刷新、热点广播)数，
+	// 持续增长时需要调大 SetBackgroundWorkers
+	DroppedBackgroundTasks int64
+
+	SourceLatencyP50 time.Duration // 从数据源加载(getter.Get)耗时的中位数
+	SourceLatencyP90 time.Duration
+	SourceLatencyP99 time.Duration
+}
+
+// groupCounters 是 Stats 中计数的原子计数器，可以在并发的 Get 中直接自增
+type groupCounters struct {
+	hits       AtomicInt
+	hotHits    AtomicInt
+	misses     AtomicInt
+	peerLoads  AtomicInt
+	localLoads AtomicInt
+	evictions  AtomicInt
+	dropped    AtomicInt // 被丢弃的后台任务
+}
+
+// Stats 返回 Group 当前的统计信息
+func (g *Group) Stats() Stats {
+	return Stats{
+		Hits:                   g.counters.hits.Get(),
+		HotCacheHits:           g.counters.hotHits.Get(),
+		Misses:                 g.counters.misses.Get(),
+		PeerLoads:              g.counters.peerLoads.Get(),
+		LocalLoads:             g.counters.localLoads.Get(),
+		Evictions:              g.counters.evictions.Get(),
+		Items:                  int64(g.mainCache.len() + g.hotCache.len()),
+		DroppedBackgroundTasks: g.counters.dropped.Get(),
+		SourceLatencyP50:       g.sourceLatency.percentile(0.50),
+		SourceLatencyP90:       g.sourceLatency.percentile(0.90),
+		SourceLatencyP99:       g.sourceLatency.percentile(0.99),
+	}
+}
+
+// HotKeyInfo 描述一个远程访问明显多于其他key的热点key
+type HotKeyInfo struct {
+	Key    string
+	QPS    float64 // 每分钟的远程访问次数
+	Factor float64 // QPS 是所有统计中key的中位数的多少倍
+}
+
+// HotKeys 返回远程访问频率超过中位数 WithHotKeyFactor 倍的key，按 QPS 从高到低排列，并记录日志。
+// 统计只包含尚未被提升到 hotCache 的key，可用于判断是否需要调整副本或分片
+func (g *Group) HotKeys() []HotKeyInfo {
+	now := time.Now()
+	g.keysMu.Lock()
+	infos := make([]HotKeyInfo, 0, len(g.keys))
+	for key, stat := range g.keys {
+		interval := now.Sub(stat.firstGetTime).Minutes()
+		infos = append(infos, HotKeyInfo{Key: key, QPS: float64(stat.remoteCnt.Get()) / math.Max(1, interval)})
+	}
+	g.keysMu.Unlock()
+	if len(infos) == 0 {
+		return nil
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].QPS > infos[j].QPS })
+	median := infos[len(infos)/2].QPS
+	if len(infos)%2 == 0 {
+		median = (median + infos[len(infos)/2-1].QPS) / 2
+	}
+	var hot []HotKeyInfo
+	for _, info := range infos {
+		info.Factor = info.QPS / median
+		if info.Factor <= g.hotKeyFactor {
+			break
+		}
+		log.Printf("[GeeCache] hot key %s/%s: %.1f remote gets/min, %.1fx median", g.name, info.Key, info.QPS, info.Factor)
+		hot = append(hot, info)
+	}
+	return hot
+}
+
+// promotionHistory 是 RecentPromotions 最多保留的提升记录数
+const promotionHistory = 64
+
+// PromotionEvent 记录一次热点key被提升到 hotCache 的决策
+type PromotionEvent struct {
+	Key    string
+	QPS    int64           // 提升时计算出的每个统计窗口(见 WithPromotionWindow)的远程访问次数
+	Target PromotionTarget // 提升去向
+	Time   time.Time
+}
+
+// promotionLog 是固定容量的环形缓冲区，写满后覆盖最旧的记录
+type promotionLog struct {
+	mu     sync.Mutex
+	events []PromotionEvent
+	next   int // 下一条记录写入的位置
+}
+
+func (l *promotionLog) record(e PromotionEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) < promotionHistory {
+		l.events = append(l.events, e)
+		return
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % promotionHistory
+}
+
+// recent 按时间从旧到新返回记录的副本
+func (l *promotionLog) recent() []PromotionEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]PromotionEvent, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+	return append(events, l.events[:l.next]...)
+}
+
+func (l *promotionLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events, l.next = nil, 0
+}
+
+// RecentPromotions 按时间顺序返回最近的热点提升记录(最多 promotionHistory 条)，
+// 可用于确认 WithPromotionThreshold 设置的阈值是否提升了预期的key
+func (g *Group) RecentPromotions() []PromotionEvent {
+	return g.promoted.recent()
+}
+
+// ResetPromotions 清空热点提升记录，便于调整参数后重新观察
+func (g *Group) ResetPromotions() {
+	g.promoted.reset()
+}
+
+// latencyBuckets 是延迟直方图各个桶的上界，超过最后一个上界的样本落入溢出桶
+var latencyBuckets = [...]time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// histogram 是固定桶的延迟直方图，记录只需一次原子自增
+type histogram struct {
+	counts [len(latencyBuckets) + 1]int64 // 最后一个为溢出桶
+}
+
+// observe 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+}
+
+// percentile 返回第 q 分位数所在桶的上界，精度取决于桶的划分，没有样本时返回0
+func (h *histogram) percentile(q float64) time.Duration {
+	var counts [len(latencyBuckets) + 1]int64
+	var total int64
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	// 落入溢出桶
+	return latencyBuckets[len(latencyBuckets)-1]
+}
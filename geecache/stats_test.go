@@ -0,0 +1,323 @@
+package geecache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSourceLatencyStats(t *testing.T) {
+	gee := NewGroup("latency", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		d, _ := strconv.Atoi(key)
+		time.Sleep(time.Duration(d) * time.Millisecond)
+		return []byte(key), nil
+	}))
+	if s := gee.Stats(); s.SourceLatencyP50 != 0 {
+		t.Fatalf("no samples yet, got p50 %v", s.SourceLatencyP50)
+	}
+
+	// 18 次约1ms的加载，2 次约30ms的加载
+	for i := 0; i < 18; i++ {
+		gee.getLocally(context.Background(), "1")
+	}
+	gee.getLocally(context.Background(), "30")
+	gee.getLocally(context.Background(), "30")
+
+	s := gee.Stats()
+	if s.SourceLatencyP50 < time.Millisecond || s.SourceLatencyP50 > 5*time.Millisecond {
+		t.Fatalf("p50 = %v, want about 1ms", s.SourceLatencyP50)
+	}
+	if s.SourceLatencyP90 > 5*time.Millisecond {
+		t.Fatalf("p90 = %v, want about 1ms", s.SourceLatencyP90)
+	}
+	if s.SourceLatencyP99 < 25*time.Millisecond || s.SourceLatencyP99 > 100*time.Millisecond {
+		t.Fatalf("p99 = %v, want about 30ms", s.SourceLatencyP99)
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var h histogram
+	for i := 0; i < 100; i++ {
+		h.observe(time.Duration(i+1) * time.Millisecond)
+	}
+	testCases := map[float64]time.Duration{
+		0.01: time.Millisecond,
+		0.50: 50 * time.Millisecond,
+		0.90: 100 * time.Millisecond,
+		0.99: 100 * time.Millisecond,
+	}
+	for q, want := range testCases {
+		if got := h.percentile(q); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", q, got, want)
+		}
+	}
+
+	h.observe(time.Minute)
+	if got := h.percentile(1); got != 10*time.Second {
+		t.Errorf("overflow percentile = %v, want 10s", got)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	gee := NewGroup("hotkeys", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithTTL(time.Hour), WithHotKeyFactor(3))
+	gee.RegisterPeers(&fakePeers{})
+
+	if hot := gee.HotKeys(); len(hot) != 0 {
+		t.Fatalf("no key should be hot yet, got %v", hot)
+	}
+	// 保持在提升阈值之下，避免 hot 被移入 hotCache 后不再统计
+	for i := 0; i < defaultMaxMinuteRemoteQPS-1; i++ {
+		gee.Get("hot")
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		gee.Get(key)
+	}
+	gee.Get("a")
+
+	hot := gee.HotKeys()
+	if len(hot) != 1 || hot[0].Key != "hot" {
+		t.Fatalf("only hot should be flagged, got %+v", hot)
+	}
+	if hot[0].Factor != float64(defaultMaxMinuteRemoteQPS-1) {
+		t.Fatalf("hot factor = %v", hot[0].Factor)
+	}
+}
+
+func TestRecentPromotions(t *testing.T) {
+	gee := NewGroup("promotions", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithTTL(time.Hour), WithPromotionPolicy("config:", PromoteBroadcast))
+	gee.RegisterPeers(&fakePeers{broadcasts: make(chan string, 10)})
+
+	start := time.Now()
+	for i := 0; i < defaultMaxMinuteRemoteQPS; i++ {
+		gee.Get("user:1")
+		gee.Get("config:flags")
+	}
+	gee.Get("cold")
+	// 提升到 hotCache 的key不会再次被统计
+	gee.Get("user:1")
+
+	events := gee.RecentPromotions()
+	if len(events) != 2 || events[0].Key != "user:1" || events[1].Key != "config:flags" {
+		t.Fatalf("promotions = %+v", events)
+	}
+	if events[0].Target != PromoteLocal || events[1].Target != PromoteBroadcast {
+		t.Fatalf("promotion targets = %+v", events)
+	}
+	for _, e := range events {
+		if e.QPS != defaultMaxMinuteRemoteQPS || e.Time.Before(start) {
+			t.Fatalf("promotion %+v", e)
+		}
+	}
+
+	gee.ResetPromotions()
+	if events := gee.RecentPromotions(); len(events) != 0 {
+		t.Fatalf("promotions after reset = %+v", events)
+	}
+
+	// 超过容量后只保留最新的记录
+	var log promotionLog
+	for i := 0; i < promotionHistory+10; i++ {
+		log.record(PromotionEvent{QPS: int64(i)})
+	}
+	events = log.recent()
+	if len(events) != promotionHistory || events[0].QPS != 10 || events[promotionHistory-1].QPS != promotionHistory+9 {
+		t.Fatalf("ring buffer keeps %d events from %d to %d", len(events), events[0].QPS, events[len(events)-1].QPS)
+	}
+}
+
+func TestPromotionThreshold(t *testing.T) {
+	gee := NewGroup("promotion-threshold", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithTTL(time.Hour), WithPromotionThreshold(3), WithPromotionWindow(50*time.Millisecond))
+	gee.RegisterPeers(&fakePeers{broadcasts: make(chan string, 10)})
+
+	for i := 0; i < 3; i++ {
+		gee.Get("hot")
+	}
+	if _, ok := gee.hotCache.get("hot"); !ok {
+		t.Fatal("hot should be promoted after 3 remote gets")
+	}
+
+	// 访问分散在多个窗口中时平均频率达不到阈值
+	gee.Get("slow")
+	gee.Get("slow")
+	time.Sleep(160 * time.Millisecond)
+	gee.Get("slow")
+	if _, ok := gee.hotCache.get("slow"); ok {
+		t.Fatal("slow should not be promoted when spread over several windows")
+	}
+	if events := gee.RecentPromotions(); len(events) != 1 || events[0].Key != "hot" || events[0].QPS != 3 {
+		t.Fatalf("promotions = %+v", events)
+	}
+}
+
+func TestMaxKeyStats(t *testing.T) {
+	gee := NewGroup("max-keystats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithMaxKeyStats(10))
+	gee.RegisterPeers(&fakePeers{})
+
+	for i := 0; i < 1000; i++ {
+		gee.Get(strconv.Itoa(i))
+		// 持续访问的key不会被淘汰，访问次数保持在提升阈值之下
+		if i >= 960 && i%5 == 0 {
+			gee.updateKeyStats("kept", ByteView{})
+		}
+		mu.RLock()
+		n, order := len(gee.keys), gee.keyOrder.Len()
+		mu.RUnlock()
+		if n > 10 || n != order {
+			t.Fatalf("after %d keys: %d stats, %d in order", i+1, n, order)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := gee.keys["kept"]; !ok {
+		t.Fatal("recently updated stats should be kept")
+	}
+	if _, ok := gee.keys["999"]; !ok {
+		t.Fatal("newest stats should be kept")
+	}
+	if _, ok := gee.keys["0"]; ok {
+		t.Fatal("oldest stats should be evicted")
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	})
+	gee := NewGroup("stats-counters", 2<<10, getter, WithTTL(time.Hour))
+
+	gee.Get("a") // miss，从数据源加载
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gee.Get("a")
+		}()
+	}
+	wg.Wait()
+	gee.populateHotCache("a", ByteView{b: []byte("a-value")})
+	gee.Get("a")
+	gee.Get("b")
+
+	want := Stats{Hits: 101, HotCacheHits: 1, Misses: 2, LocalLoads: 2, Items: 3}
+	got := gee.Stats()
+	got.SourceLatencyP50, got.SourceLatencyP90, got.SourceLatencyP99 = 0, 0, 0
+	if got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+
+	// 容量只够一个键值对，加载 b 时 a 被淘汰
+	small := NewGroup("stats-evict", 8, getter, WithTTL(time.Hour))
+	small.Get("a")
+	small.Get("b")
+	if s := small.Stats(); s.Evictions != 1 {
+		t.Fatalf("small Stats = %+v", s)
+	}
+
+	remote := NewGroup("stats-peer", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key %s should be loaded from peer", key)
+		return nil, nil
+	}), WithTTL(time.Hour))
+	remote.RegisterPeers(&fakePeers{})
+	remote.Get("x")
+	remote.Get("y")
+	if s := remote.Stats(); s.PeerLoads != 2 || s.Misses != 2 || s.LocalLoads != 0 {
+		t.Fatalf("remote Stats = %+v", s)
+	}
+}
+
+func TestApproxMemoryBytes(t *testing.T) {
+	gee := NewGroup("approx-memory", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	if n := gee.ApproxMemoryBytes(); n != 0 {
+		t.Fatalf("empty group uses %d bytes", n)
+	}
+	for i := 0; i < 10; i++ {
+		gee.Get(strconv.Itoa(i))
+	}
+	gee.populateHotCache("hot", ByteView{b: []byte("v")})
+	gee.updateKeyStats("remote", ByteView{})
+
+	mainBytes, hotBytes := gee.CacheBytes()
+	want := mainBytes + hotBytes + 11*cacheEntryOverhead + int64(len("remote")) + keyStatsOverhead
+	if n := gee.ApproxMemoryBytes(); n != want || n <= mainBytes+hotBytes {
+		t.Fatalf("ApproxMemoryBytes = %d, want %d (raw %d)", n, want, mainBytes+hotBytes)
+	}
+}
+
+func TestCleanupInterval(t *testing.T) {
+	gee := NewGroup("cleanup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithTTL(20*time.Millisecond), WithCleanupInterval(10*time.Millisecond))
+	defer gee.Close()
+	for i := 0; i < 10; i++ {
+		gee.Get(strconv.Itoa(i))
+	}
+	// 过期数据不再被访问，由后台清理并计入淘汰次数
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := gee.Stats()
+		mainBytes, _ := gee.CacheBytes()
+		if s.Items == 0 && s.Evictions == 10 && mainBytes == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after cleanup: Stats = %+v, %d bytes", s, mainBytes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	bus := lookupBus{cancelled: make(chan struct{})}
+	gee := NewGroup("close", 2<<10, getter, WithTTL(20*time.Millisecond), WithCleanupInterval(10*time.Millisecond),
+		WithExperiment("b", ExperimentConfig{}), WithInvalidationBus(bus))
+	for i := 0; i < 10; i++ {
+		gee.Get(strconv.Itoa(i))
+		gee.GetContext(ContextWithExperiment(context.Background(), "b"), strconv.Itoa(i))
+	}
+	gee.Close()
+	gee.Close()
+	select {
+	case <-bus.cancelled:
+	default:
+		t.Fatal("Close should stop listening for invalidations")
+	}
+
+	// 关闭后不再后台清理，过期数据保留到被访问
+	time.Sleep(50 * time.Millisecond)
+	if s := gee.Stats(); s.Items != 10 || s.Evictions != 0 {
+		t.Fatalf("after Close: Stats = %+v", s)
+	}
+	if n := gee.experiments["b"].cache.lru.Len(); n != 10 {
+		t.Fatalf("experiment bucket holds %d items after Close, want 10", n)
+	}
+
+	// 被同名 Group 替换时自动关闭
+	replaced := lookupBus{cancelled: make(chan struct{})}
+	NewGroup("close-replaced", 2<<10, getter, WithInvalidationBus(replaced))
+	NewGroup("close-replaced", 2<<10, getter).Close()
+	select {
+	case <-replaced.cancelled:
+	default:
+		t.Fatal("a replaced group should be closed")
+	}
+}
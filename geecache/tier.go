@@ -0,0 +1,53 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTierCycle 表示分层缓存的数据源配置成了环(如 A 的数据源是 B，B 的数据源又是 A)
+var ErrTierCycle = errors.New("geecache: cyclic tiered cache")
+
+// tierPathKey 是加载路径在 context 中的键
+type tierPathKey struct{}
+
+// tierPath 返回 ctx 中记录的正在从数据源加载的缓存组名称，按加载顺序排列
+func tierPath(ctx context.Context) []string {
+	path, _ := ctx.Value(tierPathKey{}).([]string)
+	return path
+}
+
+// checkTierCycle 检查 g 是否已经在 ctx 的加载路径上，是则返回包装了 ErrTierCycle 的错误。
+// 需要在进入 singleflight 之前检查，否则环上的加载会等待自己而死锁
+func (g *Group) checkTierCycle(ctx context.Context) error {
+	path := tierPath(ctx)
+	for _, name := range path {
+		if name == g.name {
+			return fmt.Errorf("%w: %s -> %s", ErrTierCycle, strings.Join(path, " -> "), g.name)
+		}
+	}
+	return nil
+}
+
+// withTier 返回将 g 追加到加载路径之后的 ctx，传给 g 的数据源
+func (g *Group) withTier(ctx context.Context) context.Context {
+	path := tierPath(ctx)
+	next := make([]string, len(path), len(path)+1)
+	copy(next, path)
+	return context.WithValue(ctx, tierPathKey{}, append(next, g.name))
+}
+
+// TierGetter 返回以 next 作为数据源的 GetterContext，用于构建分层缓存：本层未命中时从下一层的 Group 读取。
+// ctx 会随 GetContext 传给下一层，配置成环时加载返回 ErrTierCycle 而不是无限递归；
+// 自行实现的分层数据源也需要将 ctx 传给下一层的 GetContext 才能被检测
+func TierGetter(next *Group) GetterContext {
+	return GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := next.GetContext(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return v.ByteSlice(), nil
+	})
+}
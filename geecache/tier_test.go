@@ -0,0 +1,49 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTierGetter(t *testing.T) {
+	loads := 0
+	backing := NewGroup("tier-backing", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key + "-value"), nil
+	}), WithTTL(time.Hour))
+	front := NewGroupContext("tier-front", 2<<10, TierGetter(backing), WithTTL(time.Hour))
+
+	for i := 0; i < 2; i++ {
+		if v, err := front.Get("Tom"); err != nil || v.String() != "Tom-value" {
+			t.Fatalf("front Get = %q, %v", v.String(), err)
+		}
+	}
+	if _, ok := backing.mainCache.get("Tom"); !ok || loads != 1 {
+		t.Fatalf("backing tier cached = %v after %d loads", ok, loads)
+	}
+}
+
+func TestTierCycle(t *testing.T) {
+	var b *Group
+	a := NewGroupContext("tier-cycle-a", 2<<10, GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return TierGetter(b).Get(ctx, key)
+	}))
+	b = NewGroupContext("tier-cycle-b", 2<<10, TierGetter(a))
+
+	done := make(chan error)
+	go func() {
+		_, err := a.Get("Tom")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTierCycle) || !strings.Contains(err.Error(), "tier-cycle-a -> tier-cycle-b -> tier-cycle-a") {
+			t.Fatalf("cyclic Get error = %v, want ErrTierCycle with the path", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cyclic Get did not return")
+	}
+}
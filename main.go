@@ -51,15 +51,18 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 
 // startCacheServerGrpcEtcd 函数：
 // 创建一个 geecache.Server 实例，该实例用于处理 gRPC 请求并与其他节点通信。
-// 通过 geecache.Server 实例的 Set 方法设置一组节点地址。
+// 通过 geecache.Server 实例的 SetPeers 方法设置一组节点地址。
 // 将 geecache.Server 实例注册到缓存组（gee）中。
 // 启动 geecache.Server 实例，开始处理 gRPC 请求。
 func startCacheServerGrpcEtcd(addr string, addrs []string, gee *geecache.Group) {
-	peers, _ := geecache.NewServer(addr)
-	peers.Set(addrs...)
+	peers, err := geecache.NewServer(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	peers.SetPeers(addrs...)
 	gee.RegisterPeers(peers)
 	log.Println("geecache is running at ", addr)
-	err := peers.Start()
+	err = peers.Start()
 	if err != nil {
 		peers.Stop()
 	}